@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"nickandperla.net/losp/pkg/losp"
 )
@@ -18,7 +19,7 @@ func main() {
 	var (
 		evalStr     = flag.String("e", "", "Evaluate losp string")
 		file        = flag.String("f", "", "Execute losp file")
-		dbPath      = flag.String("db", "losp.db", "SQLite database path")
+		dbPath      = flag.String("db", "losp.db", "SQLite database path; $LOSP_DB if not given. $VAR and ~ are expanded")
 		providerF   = flag.String("provider", "", "LLM provider: ollama or openrouter")
 		model       = flag.String("model", "", "LLM model name")
 		stream      = flag.Bool("stream", false, "Enable streaming output")
@@ -26,17 +27,21 @@ func main() {
 		ollamaURL   = flag.String("ollama", "http://localhost:11434", "Ollama API URL")
 		persistMode = flag.String("persist-mode", "on_demand", "Persistence mode: on_demand, always, or never")
 		compile     = flag.Bool("compile", false, "Compile mode: run program then persist all definitions")
+		cassette    = flag.String("cassette", "", "Record LLM responses to this file and replay them on later runs")
 	)
 
 	flag.Parse()
 
 	// Build options
 	opts := []losp.Option{
-		losp.WithSQLiteStore(*dbPath),
+		losp.WithSQLiteStore(resolveDBPath(*dbPath, flagSet("db"))),
 	}
 
 	// Configure provider (platform-specific)
 	configureProvider(&opts, *providerF, *ollamaURL, *model)
+	if *cassette != "" {
+		opts = append(opts, losp.WithVCR(*cassette))
+	}
 
 	// Configure streaming
 	if *stream {
@@ -45,6 +50,11 @@ func main() {
 		}))
 	}
 
+	// Show prompts skipped by SYSTEM DRY_RUN
+	opts = append(opts, losp.WithPromptLogger(func(system, user string) {
+		fmt.Fprintf(os.Stderr, "[DRY_RUN] system:\n%s\n[DRY_RUN] user:\n%s\n", system, user)
+	}))
+
 	// Configure stdlib
 	if *noStdlib {
 		opts = append(opts, losp.WithNoStdlib())
@@ -159,3 +169,31 @@ func isTerminal(f *os.File) bool {
 	}
 	return fi.Mode()&os.ModeCharDevice != 0
 }
+
+// flagSet reports whether the named flag was given on the command line, as
+// opposed to left at its default.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// resolveDBPath returns the database path to open. LOSP_DB replaces the
+// default when -db wasn't given; either way $VAR, ${VAR} and a leading ~
+// are expanded.
+func resolveDBPath(path string, explicit bool) string {
+	if env := os.Getenv("LOSP_DB"); !explicit && env != "" {
+		path = env
+	}
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return path
+}
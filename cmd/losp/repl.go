@@ -10,7 +10,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/term"
 	"nickandperla.net/losp/pkg/losp"
@@ -39,6 +41,118 @@ func printBanner() {
 	fmt.Println("  Alt+o → ◯ (defer)       Alt+* → ◆ (terminator)")
 	fmt.Println("  Alt+[ → □ (placeholder)")
 	fmt.Println()
+	fmt.Println("Commands: :timing on|off (show time and LLM usage per command)")
+	fmt.Println("          :undo (revert the namespace to before the last command)")
+	fmt.Println()
+}
+
+// undoDepth is how many commands :undo can step back through.
+const undoDepth = 20
+
+// undoRing holds the namespace snapshots taken before each command, oldest
+// first. Once full, each push drops the oldest.
+type undoRing struct {
+	snaps []losp.Snapshot
+}
+
+func (u *undoRing) push(s losp.Snapshot) {
+	if len(u.snaps) == undoDepth {
+		u.snaps = slices.Delete(u.snaps, 0, 1)
+	}
+	u.snaps = append(u.snaps, s)
+}
+
+// pop removes and returns the newest snapshot, if there is one.
+func (u *undoRing) pop() (losp.Snapshot, bool) {
+	if len(u.snaps) == 0 {
+		return losp.Snapshot{}, false
+	}
+	s := u.snaps[len(u.snaps)-1]
+	u.snaps = u.snaps[:len(u.snaps)-1]
+	return s, true
+}
+
+// replState is what the REPL keeps between commands.
+type replState struct {
+	runtime *losp.Runtime
+	timing  bool
+	undo    undoRing
+}
+
+// replCommand handles a REPL command such as ":timing on", reporting
+// whether input was one and what to print. Other input, including text
+// starting with ':', is left for Eval.
+func replCommand(input string, st *replState) (string, bool) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return "", false
+	}
+	switch fields[0] {
+	case ":timing":
+		switch {
+		case len(fields) == 1:
+		case fields[1] == "on":
+			st.timing = true
+		case fields[1] == "off":
+			st.timing = false
+		default:
+			return "usage: :timing on|off", true
+		}
+		if st.timing {
+			return "timing on", true
+		}
+		return "timing off", true
+	case ":undo":
+		snap, ok := st.undo.pop()
+		if !ok {
+			return "nothing to undo", true
+		}
+		st.runtime.Restore(snap)
+		return "undone", true
+	}
+	return "", false
+}
+
+// replEval evaluates a line of input, first saving the namespace for :undo.
+func replEval(input string, st *replState) (string, string, error) {
+	st.undo.push(st.runtime.Snapshot())
+	return timedEval(st.runtime, input)
+}
+
+// timedEval evaluates input, also returning a status line with the elapsed
+// time and any LLM usage it caused.
+func timedEval(runtime *losp.Runtime, input string) (string, string, error) {
+	before := runtime.Usage()
+	start := time.Now()
+	result, err := runtime.Eval(input)
+	elapsed := time.Since(start)
+
+	after := runtime.Usage()
+	used := losp.Usage{
+		Prompts:      after.Prompts - before.Prompts,
+		InputTokens:  after.InputTokens - before.InputTokens,
+		OutputTokens: after.OutputTokens - before.OutputTokens,
+	}
+	return result, formatStatus(elapsed, used), err
+}
+
+// formatStatus renders the :timing status line, e.g. "[1.204s, 2 prompts,
+// ~310 in / ~85 out tokens]". Usage is left out when no prompt was made.
+func formatStatus(elapsed time.Duration, used losp.Usage) string {
+	if elapsed >= time.Millisecond {
+		elapsed = elapsed.Round(time.Millisecond)
+	} else {
+		elapsed = elapsed.Round(time.Microsecond)
+	}
+	if used.Prompts == 0 {
+		return fmt.Sprintf("[%s]", elapsed)
+	}
+	prompts := "prompts"
+	if used.Prompts == 1 {
+		prompts = "prompt"
+	}
+	return fmt.Sprintf("[%s, %d %s, ~%d in / ~%d out tokens]",
+		elapsed, used.Prompts, prompts, used.InputTokens, used.OutputTokens)
 }
 
 func runREPL(runtime *losp.Runtime) {
@@ -66,6 +180,7 @@ func runBasicREPL(runtime *losp.Runtime) {
 	reader := bufio.NewReader(os.Stdin)
 	var multiline strings.Builder
 	inMultiline := false
+	st := &replState{runtime: runtime}
 
 	for {
 		if inMultiline {
@@ -102,16 +217,20 @@ func runBasicREPL(runtime *losp.Runtime) {
 		if strings.TrimSpace(input) == "" {
 			continue
 		}
-
-		result, err := runtime.Eval(input)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+		if msg, ok := replCommand(input, st); ok {
+			fmt.Println(msg)
 			continue
 		}
 
-		if result != "" {
+		result, status, err := replEval(input, st)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		} else if result != "" {
 			fmt.Println(result)
 		}
+		if st.timing {
+			fmt.Println(status)
+		}
 	}
 }
 
@@ -143,6 +262,7 @@ func runRawREPL(runtime *losp.Runtime) {
 
 	var multiline strings.Builder
 	inMultiline := false
+	st := &replState{runtime: runtime}
 
 	for {
 		if inMultiline {
@@ -177,18 +297,22 @@ func runRawREPL(runtime *losp.Runtime) {
 		if strings.TrimSpace(input) == "" {
 			continue
 		}
-
-		result, err := runtime.Eval(input)
-		if err != nil {
-			fmt.Printf("Error: %v\r\n", err)
+		if msg, ok := replCommand(input, st); ok {
+			fmt.Print(msg + "\r\n")
 			continue
 		}
 
-		if result != "" {
+		result, status, err := replEval(input, st)
+		if err != nil {
+			fmt.Printf("Error: %v\r\n", err)
+		} else if result != "" {
 			// Replace newlines with \r\n for raw mode display
 			result = strings.ReplaceAll(result, "\n", "\r\n")
 			fmt.Println(result)
 		}
+		if st.timing {
+			fmt.Print(status + "\r\n")
+		}
 	}
 }
 
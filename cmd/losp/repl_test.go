@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+//go:build !(js && wasm)
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nickandperla.net/losp/pkg/losp"
+)
+
+func TestFormatStatus(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		used    losp.Usage
+		want    string
+	}{
+		{1204567 * time.Microsecond, losp.Usage{}, "[1.205s]"},
+		{142345 * time.Nanosecond, losp.Usage{}, "[142µs]"},
+		{2 * time.Second, losp.Usage{Prompts: 1, InputTokens: 310, OutputTokens: 85}, "[2s, 1 prompt, ~310 in / ~85 out tokens]"},
+		{35 * time.Millisecond, losp.Usage{Prompts: 3, InputTokens: 12, OutputTokens: 4}, "[35ms, 3 prompts, ~12 in / ~4 out tokens]"},
+	}
+	for _, tt := range tests {
+		if got := formatStatus(tt.elapsed, tt.used); got != tt.want {
+			t.Errorf("formatStatus(%v, %+v) = %q, want %q", tt.elapsed, tt.used, got, tt.want)
+		}
+	}
+}
+
+func TestReplCommandTiming(t *testing.T) {
+	st := &replState{}
+	tests := []struct {
+		input  string
+		msg    string
+		timing bool
+	}{
+		{":timing on", "timing on", true},
+		{":timing", "timing on", true},
+		{":timing maybe", "usage: :timing on|off", true},
+		{":timing off", "timing off", false},
+	}
+	for _, tt := range tests {
+		msg, ok := replCommand(tt.input, st)
+		if !ok || msg != tt.msg || st.timing != tt.timing {
+			t.Errorf("%q: got %q, ok=%v, timing=%v", tt.input, msg, ok, st.timing)
+		}
+	}
+
+	// Anything else is losp
+	if _, ok := replCommand(":timingon", st); ok {
+		t.Error("expected :timingon to be left for Eval")
+	}
+}
+
+func TestUndoRing(t *testing.T) {
+	r := losp.New(losp.WithMemoryStore(), losp.WithNoStdlib())
+	defer r.Close()
+
+	// Push one more snapshot than the ring holds: X=0 falls off the end
+	var u undoRing
+	for i := range undoDepth + 1 {
+		r.Eval(fmt.Sprintf("▽X %d ◆", i))
+		u.push(r.Snapshot())
+	}
+	for i := undoDepth; i > 0; i-- {
+		s, ok := u.pop()
+		if !ok {
+			t.Fatalf("expected a snapshot for X=%d", i)
+		}
+		r.Restore(s)
+		if got, _ := r.Eval("▲X"); got != fmt.Sprint(i) {
+			t.Errorf("expected X=%d, got %q", i, got)
+		}
+	}
+	if _, ok := u.pop(); ok {
+		t.Error("expected the ring to be empty")
+	}
+}
+
+func TestReplCommandUndo(t *testing.T) {
+	r := losp.New(losp.WithMemoryStore(), losp.WithNoStdlib())
+	defer r.Close()
+	st := &replState{runtime: r}
+
+	replEval("▽Name Ada ◆", st)
+	replEval("▽Name Grace ◆ ▽Extra 1 ◆", st)
+
+	if msg, _ := replCommand(":undo", st); msg != "undone" {
+		t.Errorf("expected undone, got %q", msg)
+	}
+	if got, _ := r.Eval("▲Name|▲Extra"); got != "Ada|" {
+		t.Errorf("expected the second command reverted, got %q", got)
+	}
+
+	replCommand(":undo", st)
+	if got, _ := r.Eval("▲Name"); got != "" {
+		t.Errorf("expected Name undefined, got %q", got)
+	}
+	if msg, _ := replCommand(":undo", st); msg != "nothing to undo" {
+		t.Errorf("expected nothing to undo, got %q", msg)
+	}
+}
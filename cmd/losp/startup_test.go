@@ -255,3 +255,64 @@ func TestPipedInputRunsStartup(t *testing.T) {
 		t.Errorf("expected output to contain 'PIPED_STARTUP_RAN', got: %s", output)
 	}
 }
+
+// TestDBPathFromEnvironment verifies that LOSP_DB picks the database when -db is not given
+func TestDBPathFromEnvironment(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Build the CLI first
+	cmd := exec.Command("go", "build", "-o", filepath.Join(tmpDir, "losp"), "./")
+	cmd.Dir = "."
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build losp: %v\n%s", err, out)
+	}
+
+	// Run from another directory so a default losp.db would land there instead
+	workDir := filepath.Join(tmpDir, "work")
+	os.Mkdir(workDir, 0755)
+	runCmd := exec.Command(filepath.Join(tmpDir, "losp"), "-e", "▼X 1 ◆ ▶PERSIST X ◆")
+	runCmd.Dir = workDir
+	runCmd.Env = append(os.Environ(), "LOSP_DATA="+tmpDir, "LOSP_DB=$LOSP_DATA/env.db")
+	if output, err := runCmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to run losp: %v\n%s", err, output)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "env.db")); err != nil {
+		t.Errorf("expected the database at LOSP_DB: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "losp.db")); err == nil {
+		t.Error("expected no database at the default path")
+	}
+}
+
+func TestResolveDBPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	t.Setenv("LOSP_DB", "")
+	t.Setenv("LOSP_TEST_DIR", "/data")
+
+	tests := []struct {
+		path     string
+		explicit bool
+		env      string
+		want     string
+	}{
+		{"losp.db", false, "", "losp.db"},
+		{"losp.db", false, "/srv/app.db", "/srv/app.db"},
+		{"mine.db", true, "/srv/app.db", "mine.db"},
+		{"losp.db", true, "/srv/app.db", "losp.db"},
+		{"$LOSP_TEST_DIR/a.db", true, "", "/data/a.db"},
+		{"${LOSP_TEST_DIR}/a.db", true, "", "/data/a.db"},
+		{"~/a.db", true, "", filepath.Join(home, "a.db")},
+		{"losp.db", false, "~/.losp/env.db", filepath.Join(home, ".losp/env.db")},
+		{"a~/b.db", true, "", "a~/b.db"},
+	}
+	for _, tt := range tests {
+		os.Setenv("LOSP_DB", tt.env)
+		if got := resolveDBPath(tt.path, tt.explicit); got != tt.want {
+			t.Errorf("resolveDBPath(%q, %v) with LOSP_DB=%q = %q, want %q", tt.path, tt.explicit, tt.env, got, tt.want)
+		}
+	}
+}
@@ -47,8 +47,7 @@ type checkResult struct {
 }
 
 // checkFile parses a .losp file and returns syntax errors.
-// It strips conformance-test directives (# EXPECTED:, # INPUT:) before parsing.
-// If any # EXPECTED: line contains "Error:", the file is marked as expecting errors.
+// Conformance-test directives are blanked out first (see stripDirectives).
 func checkFile(path string) checkResult {
 	content, err := os.ReadFile(path)
 	if err != nil {
@@ -58,26 +57,7 @@ func checkFile(path string) checkResult {
 		}
 	}
 
-	lines := strings.Split(string(content), "\n")
-	var lospLines []string
-	expectsError := false
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "# EXPECTED:") {
-			rest := strings.TrimPrefix(line, "# EXPECTED:")
-			rest = strings.TrimSpace(rest)
-			if strings.HasPrefix(rest, "Error:") || strings.HasPrefix(rest, "Error ") {
-				expectsError = true
-			}
-			continue
-		}
-		if strings.HasPrefix(line, "# INPUT:") {
-			continue
-		}
-		lospLines = append(lospLines, line)
-	}
-
-	lospContent := strings.Join(lospLines, "\n")
+	lospContent, expectsError := stripDirectives(string(content))
 
 	// Create ANTLR4 lexer + parser with custom error collection.
 	input := antlr.NewInputStream(lospContent)
@@ -101,6 +81,32 @@ func checkFile(path string) checkResult {
 	}
 }
 
+// stripDirectives replaces conformance-test directive lines (# EXPECTED:,
+// # INPUT:) with empty lines, so the parser's line numbers still match the
+// file. It reports whether any # EXPECTED: line contains "Error:", marking
+// the file as expecting errors.
+func stripDirectives(content string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	expectsError := false
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, "# EXPECTED:") {
+			rest := strings.TrimPrefix(line, "# EXPECTED:")
+			rest = strings.TrimSpace(rest)
+			if strings.HasPrefix(rest, "Error:") || strings.HasPrefix(rest, "Error ") {
+				expectsError = true
+			}
+			lines[i] = ""
+			continue
+		}
+		if strings.HasPrefix(line, "# INPUT:") {
+			lines[i] = ""
+		}
+	}
+
+	return strings.Join(lines, "\n"), expectsError
+}
+
 // findLospFiles recursively finds all .losp files under dir.
 func findLospFiles(dir string) ([]string, error) {
 	var files []string
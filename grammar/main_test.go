@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripDirectivesKeepsLineNumbers(t *testing.T) {
+	src := "# EXPECTED: Error: unterminated\n# INPUT: hi\n▶SAY ok ◆\n# EXPECTED: more\n▼Broken"
+	got, expectsError := stripDirectives(src)
+
+	if want := "\n\n▶SAY ok ◆\n\n▼Broken"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if !expectsError {
+		t.Error("expected an Error: directive to mark the file as expecting errors")
+	}
+}
+
+func TestCheckFileErrorLineMatchesSource(t *testing.T) {
+	// The unterminated ▼ is reported at end of input, on line 6 of the file.
+	// Removing the two directive lines would have reported line 4.
+	src := "# EXPECTED: ok\n# INPUT: hi\n▶SAY ok ◆\n▼Broken\n  still going\n  never closed"
+	path := filepath.Join(t.TempDir(), "broken.losp")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := checkFile(path)
+	if len(result.errors) == 0 {
+		t.Fatal("expected a syntax error for the unterminated ▼")
+	}
+	if !strings.HasPrefix(result.errors[0], "line 6:") {
+		t.Errorf("expected the error on line 6, got %q", result.errors[0])
+	}
+}
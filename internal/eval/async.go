@@ -5,6 +5,7 @@ package eval
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,6 +14,7 @@ import (
 // AsyncHandle represents a forked async computation or timer.
 type AsyncHandle struct {
 	id       string
+	seq      int64
 	done     chan struct{}
 	result   string
 	err      error
@@ -39,9 +41,11 @@ func NewAsyncRegistry() *AsyncRegistry {
 
 // Register creates a new handle and registers it.
 func (r *AsyncRegistry) Register(isTimer bool, duration time.Duration) *AsyncHandle {
-	id := fmt.Sprintf("_async_%d", r.counter.Add(1))
+	seq := r.counter.Add(1)
+	id := fmt.Sprintf("_async_%d", seq)
 	h := &AsyncHandle{
 		id:       id,
+		seq:      seq,
 		done:     make(chan struct{}),
 		isTimer:  isTimer,
 		duration: duration,
@@ -62,6 +66,51 @@ func (r *AsyncRegistry) Get(id string) *AsyncHandle {
 	return r.handles[id]
 }
 
+// Handles returns all registered handles in creation order.
+func (r *AsyncRegistry) Handles() []*AsyncHandle {
+	r.mu.Lock()
+	handles := make([]*AsyncHandle, 0, len(r.handles))
+	for _, h := range r.handles {
+		handles = append(handles, h)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(handles, func(i, j int) bool { return handles[i].seq < handles[j].seq })
+	return handles
+}
+
+// ID returns the handle's identifier.
+func (h *AsyncHandle) ID() string {
+	return h.id
+}
+
+// IsTimer reports whether the handle was created by TIMER.
+func (h *AsyncHandle) IsTimer() bool {
+	return h.isTimer
+}
+
+// State returns RUNNING, DONE, or ERROR.
+func (h *AsyncHandle) State() string {
+	select {
+	case <-h.done:
+		if h.err != nil {
+			return "ERROR"
+		}
+		return "DONE"
+	default:
+		return "RUNNING"
+	}
+}
+
+// Remaining returns the time left before a timer fires, or 0 for completed
+// handles and non-timers.
+func (h *AsyncHandle) Remaining() time.Duration {
+	if !h.isTimer || h.State() != "RUNNING" {
+		return 0
+	}
+	return max(time.Until(h.fireAt), 0)
+}
+
 // Shutdown stops pending timers and waits for running goroutines.
 func (r *AsyncRegistry) Shutdown() {
 	r.mu.Lock()
@@ -5,8 +5,13 @@ package eval
 
 import (
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"nickandperla.net/losp/internal/expr"
+	"nickandperla.net/losp/internal/provider"
+	"nickandperla.net/losp/internal/store"
 )
 
 func TestAsyncBasic(t *testing.T) {
@@ -294,6 +299,52 @@ func TestTicks(t *testing.T) {
 	e.asyncRegistry.Shutdown()
 }
 
+func TestTasks(t *testing.T) {
+	e := New()
+
+	result, _ := e.Eval("▶TASKS ◆")
+	if result != "" {
+		t.Errorf("expected empty with no handles, got '%s'", result)
+	}
+
+	e.Eval("▼Fast fast-val ◆")
+	e.Eval("▼Slow ▶SLEEP 200 ◆ slow-val ◆")
+	e.Eval("▽f ▶ASYNC Fast ◆ ◆")
+	e.Eval("▽s ▶ASYNC Slow ◆ ◆")
+	e.Eval("▽t ▶TIMER\n1000\nFast\n◆ ◆")
+	e.Eval("▶AWAIT ▲f ◆")
+
+	result, err := e.Eval("▶TASKS ◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(result, "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 tasks, got %d: '%s'", len(lines), result)
+	}
+	if lines[0] != "_async_1 DONE" {
+		t.Errorf("expected fast task DONE, got '%s'", lines[0])
+	}
+	if lines[1] != "_async_2 RUNNING" {
+		t.Errorf("expected slow task RUNNING, got '%s'", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "_async_3 RUNNING ") || strings.HasSuffix(lines[2], " 0") {
+		t.Errorf("expected timer RUNNING with remaining ticks, got '%s'", lines[2])
+	}
+
+	e.Eval("▶AWAIT ▲s ◆")
+	result, _ = e.Eval("▶TASKS ◆")
+	if !strings.Contains(result, "_async_2 DONE") {
+		t.Errorf("expected slow task DONE after AWAIT, got '%s'", result)
+	}
+
+	e.Eval("▶AWAIT ▲t ◆")
+	result, _ = e.Eval("▶TASKS ◆")
+	if !strings.Contains(result, "_async_3 DONE") {
+		t.Errorf("expected timer DONE after AWAIT, got '%s'", result)
+	}
+}
+
 func TestTicksOnPromise(t *testing.T) {
 	e := New()
 
@@ -335,6 +386,40 @@ func TestSleep(t *testing.T) {
 	}
 }
 
+func TestWait(t *testing.T) {
+	var output strings.Builder
+	e := New(WithOutputWriter(func(text string) error {
+		output.WriteString(text)
+		return nil
+	}))
+	e.Eval("▼Ping ▶SAY pinged ◆ pong ◆")
+
+	start := time.Now()
+	result, err := e.Eval("▶WAIT\n50\nPing\n◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if result != "pong" {
+		t.Errorf("expected 'pong', got '%s'", result)
+	}
+	if elapsed < 40*time.Millisecond {
+		t.Errorf("WAIT didn't wait long enough: %v", elapsed)
+	}
+	// Unlike TIMER, the expression runs in this evaluator, so SAY is not silenced
+	if output.String() != "pinged\n" {
+		t.Errorf("expected SAY output 'pinged', got '%s'", output.String())
+	}
+
+	// A missing expression returns EMPTY without waiting
+	start = time.Now()
+	result, _ = e.Eval("▶WAIT\n1000\nNoSuchExpr\n◆")
+	if result != "" || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected immediate EMPTY for missing expression, got '%s' after %v", result, time.Since(start))
+	}
+}
+
 func TestCheckUnknownHandle(t *testing.T) {
 	e := New()
 
@@ -347,6 +432,51 @@ func TestCheckUnknownHandle(t *testing.T) {
 	}
 }
 
+func TestCheckAllAny(t *testing.T) {
+	e := New()
+
+	e.Eval("▼Quick quick-done ◆")
+	e.Eval("▼Slow slow-done ◆")
+	e.Eval("▽fast1 ▶TIMER\n0\nQuick\n◆ ◆")
+	e.Eval("▽fast2 ▶TIMER\n0\nQuick\n◆ ◆")
+	e.Eval("▽slow ▶TIMER\n60000\nSlow\n◆ ◆")
+	e.Eval("▶AWAIT ▲fast1 ◆")
+	e.Eval("▶AWAIT ▲fast2 ◆")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"▶CHECKALL ▲fast1 ▲fast2 ◆", "TRUE"},
+		{"▶CHECKALL ▲fast1 ▲slow ▲fast2 ◆", "FALSE"},
+		{"▶CHECKANY ▲slow ▲fast2 ◆", "TRUE"},
+		{"▶CHECKANY ▲slow ◆", "FALSE"},
+		{"▶CHECKANY\nnonexistent\n◆", "FALSE"},
+		{"▶CHECKALL ▲fast1\nnonexistent\n◆", "FALSE"},
+		{"▶CHECKALL ◆", "FALSE"},
+		{"▶CHECKANY ◆", "FALSE"},
+	}
+	for _, tt := range tests {
+		result, err := e.Eval(tt.input)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.input, err)
+		}
+		if result != tt.want {
+			t.Errorf("%q: expected %s, got '%s'", tt.input, tt.want, result)
+		}
+	}
+
+	// Handles collected into one list, one per line
+	e.Eval("▶APPEND\nAll\n▲fast1\n◆ ▶APPEND\nAll\n▲fast2\n◆")
+	if result, _ := e.Eval("▶CHECKALL ▲All ◆"); result != "TRUE" {
+		t.Errorf("expected TRUE for a list of finished handles, got '%s'", result)
+	}
+	e.Eval("▶APPEND\nAll\n▲slow\n◆")
+	if result, _ := e.Eval("▶CHECKALL ▲All ◆"); result != "FALSE" {
+		t.Errorf("expected FALSE once a pending handle is in the list, got '%s'", result)
+	}
+}
+
 func TestAsyncRegistryShutdown(t *testing.T) {
 	e := New()
 
@@ -375,3 +505,134 @@ func TestAsyncRegistryShutdown(t *testing.T) {
 		t.Fatal("Shutdown hung")
 	}
 }
+
+func TestProviderConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	slow := provider.NewMockHandler(func(system, user string) string {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return "done"
+	})
+
+	e := New(WithProvider(slow), WithProviderConcurrency(2))
+	e.Eval("▼Ask ▶PROMPT hi ◆ ◆")
+
+	var handles []string
+	for i := 0; i < 10; i++ {
+		h, err := e.Eval("▶ASYNC Ask ◆")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handles = append(handles, h)
+	}
+	for _, h := range handles {
+		if result, _ := e.Eval("▶AWAIT " + h + " ◆"); result != "done" {
+			t.Errorf("expected 'done' from %s, got '%s'", h, result)
+		}
+	}
+	if peak > 2 {
+		t.Errorf("expected at most 2 concurrent prompts, saw %d", peak)
+	}
+
+	// SYSTEM reads and changes the shared limit
+	result, _ := e.Eval("▶SYSTEM PROVIDER_CONCURRENCY ◆")
+	if result != "2" {
+		t.Errorf("expected limit 2, got '%s'", result)
+	}
+	e.Eval("▶SYSTEM\nPROVIDER_CONCURRENCY\n1\n◆")
+	peak = 0
+	handles = handles[:0]
+	for i := 0; i < 5; i++ {
+		h, _ := e.Eval("▶ASYNC Ask ◆")
+		handles = append(handles, h)
+	}
+	for _, h := range handles {
+		e.Eval("▶AWAIT " + h + " ◆")
+	}
+	if peak != 1 {
+		t.Errorf("expected prompts to run one at a time, peak was %d", peak)
+	}
+
+	result, _ = e.Eval("▶SYSTEM\nPROVIDER_CONCURRENCY\n-1\n◆")
+	if result != "INVALID" {
+		t.Errorf("expected INVALID for a negative limit, got '%s'", result)
+	}
+}
+
+func TestOnDone(t *testing.T) {
+	e := New()
+
+	e.Eval("▼SlowWork ▶SLEEP 100 ◆ slow-done ◆")
+	e.Eval("▼Report □r got ▲r ◆")
+
+	result, err := e.Eval("▽h ▶ASYNC SlowWork ◆ ◆ ▶ONDONE ▲h\nReport\n◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cb := strings.TrimSpace(result)
+	if !strings.HasPrefix(cb, "_async_") {
+		t.Fatalf("expected a handle for the handler, got '%s'", result)
+	}
+
+	// The handler waits for the task
+	result, _ = e.Eval("▶CHECK " + cb + " ◆")
+	if result != "FALSE" {
+		t.Errorf("expected handler to be pending while the task runs, got '%s'", result)
+	}
+
+	result, _ = e.Eval("▶AWAIT " + cb + " ◆")
+	if result != "got slow-done" {
+		t.Errorf("expected 'got slow-done', got '%s'", result)
+	}
+
+	// Unknown handles and handlers register nothing
+	result, _ = e.Eval("▶ONDONE\nnonexistent\nReport\n◆")
+	if result != "" {
+		t.Errorf("expected EMPTY for unknown handle, got '%s'", result)
+	}
+	result, _ = e.Eval("▶ONDONE ▲h\nNoSuchHandler\n◆")
+	if result != "" {
+		t.Errorf("expected EMPTY for unknown handler, got '%s'", result)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	defer func(d time.Duration) { waitForInterval = d }(waitForInterval)
+	waitForInterval = 5 * time.Millisecond
+
+	s := store.NewMemory()
+	e := New(WithStore(s))
+
+	// Another writer sets the key after a delay, as PERSIST would
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Put("Signal", expr.Stored{Body: "▼Signal ready ◆"})
+	}()
+
+	result, err := e.Eval("▶WAIT_FOR\nSignal\n2000\n◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != "ready" {
+		t.Errorf("expected 'ready', got '%s'", result)
+	}
+	if got, _ := e.Eval("▲Signal"); got != "ready" {
+		t.Errorf("expected Signal to be loaded, got '%s'", got)
+	}
+
+	start := time.Now()
+	result, _ = e.Eval("▶WAIT_FOR\nNever\n30\n◆")
+	if result != "TIMEOUT" {
+		t.Errorf("expected TIMEOUT, got '%s'", result)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected to wait out the timeout, returned after %v", elapsed)
+	}
+}
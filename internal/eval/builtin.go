@@ -4,15 +4,27 @@
 package eval
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"maps"
 	"math/rand"
-	"strconv"
-	"strings"
-	"unicode"
-
 	"nickandperla.net/losp/internal/expr"
+	"nickandperla.net/losp/internal/provider"
+	"nickandperla.net/losp/internal/scanner"
 	"nickandperla.net/losp/internal/stdlib"
+	"nickandperla.net/losp/internal/store"
 	"nickandperla.net/losp/internal/token"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // BuiltinFunc is the signature for builtin functions.
@@ -31,24 +43,78 @@ func getBuiltin(name string) BuiltinFunc {
 		return builtinIf
 	case "COMPARE":
 		return builtinCompare
+	case "COMPARE_DIFF":
+		return builtinCompareDiff
+	case "EDIT_DISTANCE":
+		return builtinEditDistance
+	case "FUZZY_EQ":
+		return builtinFuzzyEq
+	case "CONTAINSLINE":
+		return builtinContainsLine
 	case "FOREACH":
 		return builtinForeach
+	case "GROUP":
+		return builtinGroup
+	case "RENDER":
+		return builtinRender
+	case "PARAMS":
+		return builtinParams
+	case "WHICH":
+		return builtinWhich
+	case "DEFMACRO":
+		return builtinDefmacro
+	case "MEMO":
+		return builtinMemo
+	case "THROTTLE":
+		return builtinThrottle
 	case "SAY":
 		return builtinSay
+	case "LOG":
+		return builtinLog
 	case "READ":
 		return builtinRead
+	case "READ_FIELDS":
+		return builtinReadFields
+	case "MENU":
+		return builtinMenu
 	case "COUNT":
 		return builtinCount
 	case "APPEND":
 		return builtinAppend
+	case "GETLINE":
+		return builtinGetLine
+	case "SETLINE":
+		return builtinSetLine
 	case "PERSIST":
 		return builtinPersist
 	case "LOAD":
 		return builtinLoad
+	case "RENAME":
+		return builtinRename
+	case "COPY":
+		return builtinCopy
+	case "CHECKPOINT":
+		return builtinCheckpoint
+	case "RESTORE_CHECKPOINT":
+		return builtinRestoreCheckpoint
+	case "FLUSH":
+		return builtinFlush
+	case "BACKUP":
+		return builtinBackup
 	case "PROMPT":
 		return builtinPrompt
+	case "PROMPT_WITH":
+		return builtinPromptWith
+	case "STREAM_SO_FAR":
+		return builtinStreamSoFar
+	case "PROMPT_SCHEMA":
+		return builtinPromptSchema
 	case "EXTRACT":
 		return builtinExtract
+	case "EXTRACTALL":
+		return builtinExtractAll
+	case "EXTRACT_BLOCK":
+		return builtinExtractBlock
 	case "SYSTEM":
 		return builtinSystem
 	case "UPPER":
@@ -57,34 +123,94 @@ func getBuiltin(name string) BuiltinFunc {
 		return builtinLower
 	case "TRIM":
 		return builtinTrim
+	case "SUBSTITUTE":
+		return builtinSubstitute
+	case "LIMIT":
+		return builtinLimit
+	case "SPLITN":
+		return builtinSplitN
+	case "COALESCE":
+		return builtinCoalesce
+	case "CONCAT":
+		return builtinConcat
+	case "WRAP":
+		return builtinWrap
+	case "TABLE":
+		return builtinTable
+	case "ESCAPE_PROMPT":
+		return builtinEscapePrompt
+	case "BASE64_ENCODE":
+		return builtinBase64Encode
+	case "BASE64_DECODE":
+		return builtinBase64Decode
+	case "EXPAND_PATH":
+		return builtinExpandPath
 	case "GENERATE":
 		return builtinGenerate
+	case "GENERATE_AS":
+		return builtinGenerateAs
+	case "VALIDATE":
+		return builtinValidate
+	case "PARSE":
+		return builtinParse
 	case "ASYNC":
 		return builtinAsync
 	case "AWAIT":
 		return builtinAwait
+	case "ONDONE":
+		return builtinOnDone
 	case "CHECK":
 		return builtinCheck
+	case "CHECKALL":
+		return builtinCheckAll
+	case "CHECKANY":
+		return builtinCheckAny
 	case "TIMER":
 		return builtinTimer
 	case "TICKS":
 		return builtinTicks
+	case "TASKS":
+		return builtinTasks
 	case "SLEEP":
 		return builtinSleep
+	case "WAIT":
+		return builtinWait
+	case "WAIT_FOR":
+		return builtinWaitFor
+	case "BENCH":
+		return builtinBench
 	case "CORPUS":
 		return builtinCorpus
 	case "ADD":
 		return builtinAdd
 	case "INDEX":
 		return builtinIndex
+	case "REFRESH":
+		return builtinRefresh
 	case "SEARCH":
 		return builtinSearch
 	case "EMBED":
 		return builtinEmbed
+	case "ESTIMATE_EMBED":
+		return builtinEstimateEmbed
 	case "SIMILAR":
 		return builtinSimilar
+	case "SEMANTIC_EQ":
+		return builtinSemanticEq
+	case "EMBEDTEXT":
+		return builtinEmbedText
+	case "VECSIM":
+		return builtinVecSim
 	case "HISTORY":
 		return builtinHistory
+	case "FINDVALUE":
+		return builtinFindValue
+	case "ANNOTATE":
+		return builtinAnnotate
+	case "TAG":
+		return builtinTag
+	case "CHECKOUT":
+		return builtinCheckout
 	case "RANDOM":
 		return builtinRandom
 	}
@@ -105,8 +231,9 @@ func builtinEmpty(e *Evaluator, argsRaw string) (expr.Expr, error) {
 
 func builtinIf(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	// IF condition then-expr [else-expr]
-	// Parse: first arg is condition, second is then, third is else
-	args, err := e.parseArgs(argsRaw)
+	// Only the condition and the selected branch are evaluated, so side
+	// effects in the other branch never fire.
+	args, err := e.splitArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
@@ -115,26 +242,27 @@ func builtinIf(e *Evaluator, argsRaw string) (expr.Expr, error) {
 		return expr.Empty{}, nil
 	}
 
-	condition := args[0]
-	thenExpr := args[1]
-	elseExpr := ""
-	if len(args) >= 3 {
-		elseExpr = args[2]
+	condition, err := e.evalArg(args[0])
+	if err != nil {
+		return nil, err
 	}
 
-	// Evaluate condition
-	condResult := strings.TrimSpace(condition)
+	branch := ""
+	if strings.TrimSpace(condition) == "TRUE" {
+		branch = args[1]
+	} else if len(args) >= 3 {
+		branch = args[2]
+	}
+	if branch == "" {
+		return expr.Empty{}, nil
+	}
 
-	if condResult == "TRUE" {
-		// Return then-expr as text (use dynamic execute to evaluate if needed)
-		return expr.Stored{Body: thenExpr}, nil
-	} else {
-		// Return else-expr as text
-		if elseExpr == "" {
-			return expr.Empty{}, nil
-		}
-		return expr.Stored{Body: elseExpr}, nil
+	// Return the branch as text (use dynamic execute to evaluate if needed)
+	result, err := e.evalArg(branch)
+	if err != nil {
+		return nil, err
 	}
+	return expr.Stored{Body: result}, nil
 }
 
 func builtinCompare(e *Evaluator, argsRaw string) (expr.Expr, error) {
@@ -154,12 +282,134 @@ func builtinCompare(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: "FALSE"}, nil
 }
 
+func builtinContainsLine(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// CONTAINSLINE list-source item
+	// TRUE if a line of the source, trimmed, is exactly the item. The item
+	// is the last argument, so a literal list may span several lines.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Stored{Body: "FALSE"}, nil
+	}
+
+	item := args[len(args)-1]
+	for _, arg := range args[:len(args)-1] {
+		for line := range strings.SplitSeq(arg, "\n") {
+			if strings.TrimSpace(line) == item {
+				return expr.Stored{Body: "TRUE"}, nil
+			}
+		}
+	}
+	return expr.Stored{Body: "FALSE"}, nil
+}
+
+func builtinCompareDiff(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// COMPARE_DIFF a b - explains why COMPARE would return FALSE
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	for len(args) < 2 {
+		args = append(args, "")
+	}
+
+	if diff := firstDiff(args[0], args[1]); diff != "" {
+		return expr.NewText(diff), nil
+	}
+	return expr.Empty{}, nil
+}
+
+// firstDiff describes the first rune at which a and b differ, counting from
+// 1, or returns "" if they are equal. A string that ends first shows as end.
+func firstDiff(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	for i := 0; i < max(len(ra), len(rb)); i++ {
+		if i < len(ra) && i < len(rb) && ra[i] == rb[i] {
+			continue
+		}
+		return fmt.Sprintf("differ at rune %d: %s vs %s", i+1, diffRune(ra, i), diffRune(rb, i))
+	}
+	return ""
+}
+
+func diffRune(r []rune, i int) string {
+	if i >= len(r) {
+		return "end"
+	}
+	return strconv.QuoteRune(r[i])
+}
+
+func builtinEditDistance(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EDIT_DISTANCE a b - Levenshtein distance in runes
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	for len(args) < 2 {
+		args = append(args, "")
+	}
+	return expr.Stored{Body: strconv.Itoa(levenshtein(args[0], args[1]))}, nil
+}
+
+func builtinFuzzyEq(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// FUZZY_EQ a b maxDist
+	// TRUE if a can be turned into b with at most maxDist rune edits.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return expr.Empty{}, nil
+	}
+
+	maxDist, err := strconv.Atoi(strings.TrimSpace(args[2]))
+	if err != nil || maxDist < 0 {
+		return expr.Empty{}, nil
+	}
+	if levenshtein(args[0], args[1]) <= maxDist {
+		return expr.Stored{Body: "TRUE"}, nil
+	}
+	return expr.Stored{Body: "FALSE"}, nil
+}
+
+// levenshtein returns the number of rune insertions, deletions and
+// substitutions that turn a into b. It keeps one row of the classic
+// dynamic-programming table at a time.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		diag := row[0] // row[i-1][j-1]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			diag, row[j] = row[j], min(row[j]+1, row[j-1]+1, diag+cost)
+		}
+	}
+	return row[len(rb)]
+}
+
 func builtinForeach(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	// FOREACH items-expr body-name
 	// Two expression arguments:
 	//   1. items-expr - evaluates to text containing expressions (one per line or operator)
 	//   2. body-name - text name of the expression to execute per item
 	// The items text is re-parsed as expressions; each result is passed to body.
+	// Items given as a ▶SEARCH or ▶SIMILAR call are read lazily instead.
+	if raw, err := e.splitArgs(argsRaw); err == nil && len(raw) >= 2 {
+		if source, searchArgs, ok := e.searchCall(raw[0]); ok {
+			return foreachResults(e, source, searchArgs, raw[1])
+		}
+	}
+
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
@@ -202,531 +452,2087 @@ func builtinForeach(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: strings.Join(results, "\n")}, nil
 }
 
-func builtinSay(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// Evaluate args
-	result, err := e.Eval(argsRaw)
-	if err != nil {
-		return nil, err
-	}
+// resultSource starts a corpus query and returns its results as they are
+// fetched, or, when the query can't run, the builtin's result instead.
+type resultSource func(e *Evaluator, argsRaw string) (iter.Seq2[string, error], expr.Expr, error)
 
-	text := strings.TrimSpace(result)
-	if e.outputWriter != nil {
-		e.outputWriter(text + "\n")
+// searchSource returns the source of the builtin name if FOREACH can
+// consume its results lazily.
+func searchSource(name string) resultSource {
+	switch name {
+	case "SEARCH":
+		return searchResults
+	case "SIMILAR":
+		return similarResults
 	}
-
-	// Return empty - output already happened via outputWriter
-	return expr.Empty{}, nil
+	return nil
 }
 
-func builtinRead(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// Parse args as expressions
-	evaluated, err := e.Eval(argsRaw)
-	if err != nil {
-		return nil, err
+// searchCall recognizes an unevaluated argument that is a call to one of
+// the builtins with a searchSource, returning the source and the call's arguments. Calls
+// the sandbox forbids or a user expression shadows are left to execute.
+func (e *Evaluator) searchCall(raw string) (resultSource, string, bool) {
+	rest, ok := strings.CutPrefix(raw, string(token.RuneExecute))
+	if !ok {
+		return nil, "", false
 	}
-	prompt := strings.TrimSpace(evaluated)
-
-	if e.inputReader == nil {
-		return expr.Empty{}, nil
+	end := strings.IndexFunc(rest, unicode.IsSpace)
+	if end < 0 {
+		return nil, "", false
 	}
-
-	input, err := e.inputReader(prompt)
-	if err != nil {
-		return nil, err
+	name := rest[:end]
+	source := searchSource(name)
+	if source == nil || e.userShadows(name) || !e.allowsBuiltin(name) {
+		return nil, "", false
 	}
-
-	return expr.Stored{Body: strings.TrimSpace(input)}, nil
+	return source, strings.TrimSuffix(rest[end:], string(token.RuneTerminator)), true
 }
 
-func builtinCount(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// Evaluate the expression
-	result, err := e.Eval(argsRaw)
+// foreachResults is FOREACH over a search: the body runs on each result as
+// it is fetched, before later results are read.
+func foreachResults(e *Evaluator, source resultSource, searchArgs, bodyRaw string) (expr.Expr, error) {
+	results, early, err := source(e, searchArgs)
 	if err != nil {
 		return nil, err
 	}
-
-	text := strings.TrimSpace(result)
-	if text == "" {
-		return expr.Stored{Body: "0"}, nil
-	}
-
-	lines := strings.Split(text, "\n")
-	return expr.Stored{Body: fmt.Sprintf("%d", len(lines))}, nil
-}
-
-func builtinRandom(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	args, err := e.parseArgs(argsRaw)
+	bodyName, err := e.evalArg(bodyRaw)
 	if err != nil {
 		return nil, err
 	}
-	if len(args) == 0 {
-		return expr.Empty{}, nil
-	}
-
-	// Multiple args: each is a candidate (handles operator boundaries and inline text)
-	// Single arg: re-parse to extract items from stored lists (like FOREACH)
-	var items []string
-	if len(args) == 1 {
-		items, err = e.parseArgs(args[0])
+	if results == nil {
+		// Iterate over what the builtin returned, as if it had been called
+		items, err := e.parseArgs(strings.TrimSpace(early.String()))
 		if err != nil {
 			return expr.Empty{}, nil
 		}
-	} else {
-		items = args
+		results = sliceResults(items)
 	}
 
-	if len(items) == 0 {
+	s, ok := e.namespace.Get(bodyName).(expr.Stored)
+	if !ok {
 		return expr.Empty{}, nil
 	}
-
-	index := rand.Intn(len(items))
-	return expr.Stored{Body: strings.TrimSpace(items[index])}, nil
+	var out []string
+	for item, err := range results {
+		if err != nil {
+			return nil, err
+		}
+		if len(s.Params) > 0 {
+			e.namespace.Set(s.Params[0], expr.Stored{Body: item})
+		}
+		out = append(out, mustEval(e, s.Body))
+	}
+	if len(out) == 0 {
+		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: strings.Join(out, "\n")}, nil
 }
 
-func builtinAppend(e *Evaluator, argsRaw string) (expr.Expr, error) {
+func builtinGroup(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// GROUP items-expr key-name
+	// Same argument shape as FOREACH. Each item is bound to the first parameter
+	// of key-name, and the result of evaluating key-name is the item's group key.
+	// Groups are returned in first-seen key order as "key:\n  item\n  item" blocks.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
 	if len(args) < 2 {
 		return expr.Empty{}, nil
 	}
 
-	name := args[0]
-	content := strings.Join(args[1:], " ")
-
-	// Get existing value (auto-load from DB in PersistAlways mode)
-	e.autoLoad(name)
-	existing := e.namespace.Get(name)
-	var newValue string
-	if !existing.IsEmpty() {
-		newValue = existing.String() + "\n" + content
-	} else {
-		newValue = content
+	items, err := e.parseArgs(args[0])
+	if err != nil {
+		return expr.Empty{}, nil
 	}
-
-	e.namespace.Set(name, expr.Stored{Body: newValue})
-
-	// Auto-persist in ALWAYS mode
-	if e.persistMode == PersistAlways && e.store != nil {
-		e.autoPersist(name)
+	if len(items) == 0 {
+		return expr.Empty{}, nil
 	}
 
-	return expr.Empty{}, nil
-}
-
-// formatAsDefinition generates the full losp source for an expression.
-// For Stored expressions: ▼name □param1 □param2 body ◆
-// For Text expressions: just the text value
-// For other expressions: their String() representation
-func formatAsDefinition(name string, val expr.Expr) string {
-	if val == nil || val.IsEmpty() {
-		return ""
+	stored, ok := e.namespace.Get(args[1]).(expr.Stored)
+	if !ok || stored.IsEmpty() {
+		return expr.Empty{}, nil
 	}
 
-	stored, ok := val.(expr.Stored)
-	if !ok {
-		// Not a stored expression, just return the value
-		return val.String()
+	var keys []string
+	groups := make(map[string][]string)
+	for _, item := range items {
+		if len(stored.Params) > 0 {
+			e.namespace.Set(stored.Params[0], expr.Stored{Body: item})
+		}
+		key := strings.TrimSpace(mustEval(e, stored.Body))
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], item)
 	}
 
-	// Build the full definition: ▼name □param1 □param2 body ◆
 	var sb strings.Builder
-	sb.WriteRune(token.RuneStore) // ▼
-	sb.WriteString(name)
-	sb.WriteString(" ")
-
-	// Add placeholders
-	for _, param := range stored.Params {
-		sb.WriteRune(token.RunePlaceholder) // □
-		sb.WriteString(param)
-		sb.WriteString(" ")
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(key)
+		sb.WriteString(":")
+		for _, item := range groups[key] {
+			sb.WriteString("\n  ")
+			sb.WriteString(item)
+		}
 	}
 
-	// Add body
-	sb.WriteString(stored.Body)
-
-	sb.WriteRune(token.RuneTerminator) // ◆
-
-	return sb.String()
+	return expr.Stored{Body: sb.String()}, nil
 }
 
-func builtinPersist(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// In NEVER or ALWAYS mode, PERSIST is a no-op
-	if e.PersistMode() == PersistNever || e.PersistMode() == PersistAlways {
-		return expr.Empty{}, nil
-	}
-
+func builtinRender(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// RENDER template-name
+	// Executes the template with each placeholder bound from the namespace
+	// variable of the same name (EMPTY if unset) instead of positional args.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
 	if len(args) < 1 {
 		return expr.Empty{}, nil
 	}
 
 	name := args[0]
-
-	if e.store == nil {
+	e.autoLoad(name)
+	stored := e.namespace.Get(name)
+	if stored.IsEmpty() {
 		return expr.Empty{}, nil
 	}
 
-	val := e.namespace.Get(name)
-
-	// Format as full definition so we can reconstruct on LOAD
-	fullDef := formatAsDefinition(name, val)
-	if err := e.store.Put(name, expr.Stored{Body: fullDef}); err != nil {
-		return nil, err
+	var named []string
+	if s, ok := stored.(expr.Stored); ok {
+		for _, param := range s.Params {
+			e.autoLoad(param)
+			named = append(named, e.namespace.Get(param).String())
+		}
 	}
 
-	return expr.Empty{}, nil
+	return e.executeStored(name, stored, named, nil)
 }
 
-func builtinLoad(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// LOAD name [default]
-	// Loads name from store. If not found/empty and default provided, uses default.
-	// If the stored value is a full definition (starts with ▼), re-evals it to
-	// reconstruct the Stored expression with its parameters.
+func builtinParams(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// PARAMS name
+	// Returns the placeholder names of a stored expression, one per line.
+	// Reads the namespace directly, so the expression's body is not consumed.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
 	if len(args) < 1 {
 		return expr.Empty{}, nil
 	}
 
 	name := args[0]
-	var defaultVal string
-	if len(args) >= 2 {
-		defaultVal = args[1]
+	e.autoLoad(name)
+	s, ok := e.namespace.Get(name).(expr.Stored)
+	if !ok || len(s.Params) == 0 {
+		return expr.Empty{}, nil
 	}
+	return expr.Stored{Body: strings.Join(s.Params, "\n")}, nil
+}
 
-	// Try loading from store
-	var val expr.Expr
-	if e.store != nil {
-		val, err = e.store.Get(name)
-		if err != nil {
-			return nil, err
-		}
+func builtinWhich(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// WHICH name
+	// Reports what ▶name would run, checking builtins first as execute does:
+	// BUILTIN, STORED (an expression with placeholders or operators), TEXT
+	// (a plain value, which executes to itself) or UNDEFINED.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
 	}
-
-	// If we got a value from store, process it
-	if val != nil && !val.IsEmpty() {
-		text := val.String()
-
-		// Check if it's a full definition (starts with ▼)
-		// If so, re-eval to reconstruct the Stored expression
-		trimmed := strings.TrimSpace(text)
-		runes := []rune(trimmed)
-		if len(runes) > 0 && runes[0] == token.RuneStore {
-			// Re-eval the definition - this will store it in namespace
-			_, err := e.Eval(text)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			// Plain text value, just set it directly
-			e.namespace.Set(name, expr.Stored{Body: text})
-		}
+	if len(args) < 1 || args[0] == "" {
 		return expr.Empty{}, nil
 	}
 
-	// Otherwise use default if provided
-	if defaultVal != "" {
-		e.namespace.Set(name, expr.Stored{Body: defaultVal})
+	name := args[0]
+	if getBuiltin(name) != nil && !e.userShadows(name) {
+		return expr.NewText("BUILTIN"), nil
+	}
+	e.autoLoad(name)
+	switch v := e.namespace.Get(name).(type) {
+	case expr.Stored:
+		if v.IsEmpty() {
+			break
+		}
+		if len(v.Params) > 0 || token.ContainsOperator(v.Body) {
+			return expr.NewText("STORED"), nil
+		}
+		return expr.NewText("TEXT"), nil
+	case expr.Blob:
+		if !v.IsEmpty() {
+			return expr.NewText("TEXT"), nil
+		}
+	}
+	return expr.NewText("UNDEFINED"), nil
+}
+
+func builtinDefmacro(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// DEFMACRO name body
+	// Defines a macro: from here on the scanner replaces ⟦name⟧ with body,
+	// as written, before anything in the source is evaluated. An empty
+	// body removes the macro.
+	src := strings.TrimSpace(argsRaw)
+	name, body := src, ""
+	if i := strings.IndexFunc(src, unicode.IsSpace); i >= 0 {
+		name, body = src[:i], strings.TrimSpace(src[i:])
+	}
+	if !scanner.IsName(name) {
+		return expr.Error{Code: "INVALID", Message: "DEFMACRO needs a macro name"}, nil
 	}
 
+	if body == "" {
+		delete(e.macros, name)
+		return expr.Empty{}, nil
+	}
+	if e.macros == nil {
+		e.macros = make(map[string]string)
+	}
+	e.macros[name] = body
 	return expr.Empty{}, nil
 }
 
-func builtinExtract(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// EXTRACT label source
-	// Parses source for "LABEL: value" format and returns the value
+func builtinMemo(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// MEMO name
+	// Marks a stored expression as memoized: repeat executions with the same
+	// arguments return the cached result until the expression is redefined.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+	e.memoize(args[0])
+	return expr.Empty{}, nil
+}
 
+func builtinThrottle(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// THROTTLE ms name
+	// Executes name at most once per ms milliseconds, returning the result
+	// of the last run for calls inside the window.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
 	if len(args) < 2 {
 		return expr.Empty{}, nil
 	}
 
-	label := strings.ToUpper(strings.TrimSpace(args[0]))
-	source := args[1]
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || ms < 0 {
+		return expr.Empty{}, nil
+	}
+	name := args[1]
 
-	// Parse line by line looking for LABEL: value
-	lines := strings.Split(source, "\n")
-	var result strings.Builder
-	capturing := false
+	e.autoLoad(name)
+	if e.namespace.Get(name).IsEmpty() {
+		return expr.Empty{}, nil
+	}
+	return e.executeThrottled(name, time.Duration(ms)*time.Millisecond)
+}
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+func builtinSay(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// Evaluate args
+	result, err := e.Eval(argsRaw)
+	if err != nil {
+		return nil, err
+	}
 
-		// Check if this line starts a new label
-		if colonIdx := strings.Index(trimmed, ":"); colonIdx > 0 {
-			potentialLabel := strings.ToUpper(strings.TrimSpace(trimmed[:colonIdx]))
-			// Check if it looks like a label (alphanumeric/underscore only)
-			isLabel := true
-			for _, r := range potentialLabel {
-				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
-					isLabel = false
-					break
-				}
-			}
+	text := strings.TrimSpace(result)
+	if e.outputWriter != nil {
+		e.outputWriter(text + "\n")
+	}
 
-			if isLabel {
-				if potentialLabel == label {
-					// Found our label, start capturing
-					capturing = true
-					value := strings.TrimSpace(trimmed[colonIdx+1:])
-					if value != "" {
-						result.WriteString(value)
-					}
-					continue
-				} else if capturing {
-					// Hit a different label, stop capturing
-					break
-				}
-			}
+	// Return empty - output already happened via outputWriter
+	return expr.Empty{}, nil
+}
+
+func builtinRead(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// Parse args as expressions
+	evaluated, err := e.Eval(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	prompt := strings.TrimSpace(evaluated)
+
+	if e.inputReader == nil {
+		return expr.Empty{}, nil
+	}
+
+	input, err := e.inputReader(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Stored{Body: strings.TrimSpace(input)}, nil
+}
+
+func builtinReadFields(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// READ_FIELDS field1 field2 ...
+	// Prompts for each field in order and stores each response in the
+	// variable of the same name. Each non-blank line of the arguments is
+	// one field name, so a list of fields can come from a variable.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if e.inputReader == nil {
+		return expr.Empty{}, nil
+	}
+
+	for _, field := range argLines(args) {
+		input, err := e.inputReader(field + ": ")
+		if err != nil {
+			return nil, err
 		}
+		e.namespace.Set(field, expr.Stored{Body: strings.TrimSpace(input)})
 
-		// If we're capturing, append this line (it's a continuation)
-		if capturing {
-			if result.Len() > 0 {
-				result.WriteString("\n")
+		// Auto-persist in ALWAYS mode
+		if e.persistMode == PersistAlways && e.store != nil {
+			e.autoPersist(field)
+		}
+	}
+
+	return expr.Empty{}, nil
+}
+
+// menuAttempts is how many answers MENU reads before giving up.
+const menuAttempts = 3
+
+func builtinMenu(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// MENU prompt option...
+	// Shows the prompt and the options numbered from 1, reads a number and
+	// returns that option's text. An out-of-range answer is asked again, up
+	// to menuAttempts answers in all; EMPTY after that or at end of input.
+	// An option argument holding several lines offers each line.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || e.inputReader == nil {
+		return expr.Empty{}, nil
+	}
+
+	var options []string
+	for _, arg := range args[1:] {
+		for line := range strings.SplitSeq(arg, "\n") {
+			if s := strings.TrimSpace(line); s != "" {
+				options = append(options, s)
 			}
-			result.WriteString(trimmed)
 		}
 	}
+	if len(options) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	var menu strings.Builder
+	menu.WriteString(args[0] + "\n")
+	for i, option := range options {
+		fmt.Fprintf(&menu, "%d. %s\n", i+1, option)
+	}
+	menu.WriteString("> ")
+
+	prompt := menu.String()
+	for range menuAttempts {
+		input, err := e.inputReader(prompt)
+		eof := errors.Is(err, io.EOF)
+		if err != nil && !eof {
+			return nil, err
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(input)); err == nil && n >= 1 && n <= len(options) {
+			return expr.Stored{Body: options[n-1]}, nil
+		}
+		if eof {
+			break
+		}
+		prompt = fmt.Sprintf("Choose 1-%d: ", len(options))
+	}
+	return expr.Empty{}, nil
+}
 
-	extracted := strings.TrimSpace(result.String())
-	if extracted == "" {
+func builtinCount(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// Evaluate the expression
+	result, err := e.Eval(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(result)
+	if text == "" {
+		return expr.Stored{Body: "0"}, nil
+	}
+
+	lines := strings.Split(text, "\n")
+	return expr.Stored{Body: fmt.Sprintf("%d", len(lines))}, nil
+}
+
+func builtinRandom(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
 		return expr.Empty{}, nil
 	}
-	return expr.Stored{Body: extracted}, nil
+
+	// Multiple args: each is a candidate (handles operator boundaries and inline text)
+	// Single arg: re-parse to extract items from stored lists (like FOREACH)
+	var items []string
+	if len(args) == 1 {
+		items, err = e.parseArgs(args[0])
+		if err != nil {
+			return expr.Empty{}, nil
+		}
+	} else {
+		items = args
+	}
+
+	if len(items) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	index := rand.Intn(len(items))
+	return expr.Stored{Body: strings.TrimSpace(items[index])}, nil
 }
 
-func builtinPrompt(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	if e.provider == nil {
+func builtinAppend(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) < 2 {
 		return expr.Empty{}, nil
 	}
 
-	// Evaluate args to resolve any operators (like ▲)
-	evaluated, err := e.Eval(argsRaw)
+	name := args[0]
+	content := strings.Join(args[1:], " ")
+
+	// Get existing value (auto-load from DB in PersistAlways mode)
+	e.autoLoad(name)
+	existing := e.namespace.Get(name)
+	var newValue string
+	if !existing.IsEmpty() {
+		newValue = existing.String() + "\n" + content
+	} else {
+		newValue = content
+	}
+
+	e.namespace.Set(name, expr.Stored{Body: newValue})
+
+	// Auto-persist in ALWAYS mode
+	if e.persistMode == PersistAlways && e.store != nil {
+		e.autoPersist(name)
+	}
+
+	return expr.Empty{}, nil
+}
+
+func builtinGetLine(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// GETLINE name n
+	// Returns line n (counting from 1) of name's value without executing it.
+	// EMPTY when n is not a line of the value.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || n < 1 {
+		return expr.Empty{}, nil
+	}
+	e.autoLoad(args[0])
+	lines := valueLines(e.namespace.Get(args[0]))
+	if n > len(lines) {
+		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: strings.TrimSpace(lines[n-1])}, nil
+}
+
+func builtinSetLine(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// SETLINE name n content
+	// Replaces line n (counting from 1) of name's value with content and
+	// auto-persists it in ALWAYS mode. Past the last line, content is
+	// appended as a new line instead.
+	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	name := args[0]
+	if res := e.targetNameError(name); res != nil {
+		return res, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || n < 1 {
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("line number %q must be 1 or more", args[1])}, nil
+	}
+	content := strings.Join(args[2:], " ")
+
+	e.autoLoad(name)
+	existing := e.namespace.Get(name)
+	lines := valueLines(existing)
+	if n > len(lines) {
+		lines = append(lines, content)
+	} else {
+		lines[n-1] = content
+	}
+
+	// A template keeps its placeholders
+	var params []string
+	if v, ok := existing.(expr.Stored); ok {
+		params = v.Params
+	}
+	e.namespace.Set(name, expr.Stored{Params: params, Body: strings.Join(lines, "\n")})
+
+	// Auto-persist in ALWAYS mode
+	if e.persistMode == PersistAlways && e.store != nil {
+		e.autoPersist(name)
+	}
+
+	return expr.Empty{}, nil
+}
+
+// argLines returns the non-blank lines of the arguments, trimmed, for
+// builtins that take one name per line.
+func argLines(args []string) []string {
+	var lines []string
+	for _, arg := range args {
+		for line := range strings.SplitSeq(arg, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// valueLines splits a value into the lines GETLINE and SETLINE number,
+// ignoring leading and trailing blank lines as COUNT does.
+func valueLines(val expr.Expr) []string {
+	text := strings.TrimSpace(val.String())
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// redirectOutput points SAY at the named variable, appending each write to
+// its current value. STDOUT restores the original output writer.
+func (e *Evaluator) redirectOutput(target string) {
+	if e.outputVar == "" {
+		e.stdoutWriter = e.outputWriter
+	}
+	if strings.ToUpper(target) == "STDOUT" {
+		e.outputWriter = e.stdoutWriter
+		e.outputVar = ""
+		e.stdoutWriter = nil
+		return
+	}
+
+	e.outputVar = target
+	e.outputWriter = func(text string) error {
+		e.autoLoad(target)
+		existing := e.namespace.Get(target)
+		e.namespace.Set(target, expr.Stored{Body: existing.String() + text})
+		if e.persistMode == PersistAlways && e.store != nil {
+			e.autoPersist(target)
+		}
+		return nil
+	}
+}
+
+// formatAsDefinition generates the full losp source for an expression.
+// For Stored expressions: ▼name □param1 □param2 body ◆
+// For Text expressions: just the text value
+// For other expressions: their String() representation
+func formatAsDefinition(name string, val expr.Expr) string {
+	if val == nil || val.IsEmpty() {
+		return ""
+	}
+
+	stored, ok := val.(expr.Stored)
+	if !ok {
+		// Not a stored expression, just return the value
+		return val.String()
+	}
+
+	// Build the full definition: ▼name □param1 □param2 body ◆
+	var sb strings.Builder
+	sb.WriteRune(token.RuneStore) // ▼
+	sb.WriteString(name)
+	sb.WriteString(" ")
+
+	// Add placeholders
+	for _, param := range stored.Params {
+		sb.WriteRune(token.RunePlaceholder) // □
+		sb.WriteString(param)
+		sb.WriteString(" ")
+	}
+
+	// Add body
+	sb.WriteString(stored.Body)
+
+	sb.WriteRune(token.RuneTerminator) // ◆
+
+	return sb.String()
+}
+
+// persistValue is what the store receives for val: the full definition
+// text, or the raw bytes for binary values.
+func persistValue(val expr.Expr, fullDef string) expr.Expr {
+	if b, ok := val.(expr.Blob); ok {
+		return b
+	}
+	return expr.Stored{Body: fullDef}
+}
+
+func builtinPersist(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// In NEVER or ALWAYS mode, PERSIST is a no-op
+	if e.PersistMode() == PersistNever || e.PersistMode() == PersistAlways {
+		return expr.Empty{}, nil
+	}
+
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	name := args[0]
+
+	if e.store == nil {
+		return expr.Empty{}, nil
+	}
+
+	val := e.namespace.Get(name)
+
+	// Format as full definition so we can reconstruct on LOAD
+	fullDef := formatAsDefinition(name, val)
+	if err := e.store.Put(name, persistValue(val, fullDef)); err != nil {
+		return nil, err
+	}
+
+	return expr.Empty{}, nil
+}
+
+func builtinRename(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// RENAME old new
+	// Moves the expression, parameters and all, to new and removes old from
+	// the namespace and the store. A persisted copy moves with it in
+	// ON_DEMAND mode; ALWAYS mode writes new and deletes old as usual.
+	// History stays with neither: a version's definition names old, so new
+	// starts a fresh history.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+	oldName, newName := args[0], args[1]
+	if res := e.targetNameError(newName); res != nil {
+		return res, nil
+	}
+
+	e.autoLoad(oldName)
+	val := e.namespace.Get(oldName)
+	if val.IsEmpty() {
+		return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s is not defined", oldName)}, nil
+	}
+	if oldName == newName {
+		return expr.Empty{}, nil
+	}
+
+	e.namespace.Set(newName, val)
+	e.namespace.Delete(oldName)
+	if e.memoized[oldName] {
+		delete(e.memoized, oldName)
+		e.memoize(newName)
+	}
+
+	if e.store == nil {
+		return expr.Empty{}, nil
+	}
+	switch e.persistMode {
+	case PersistAlways:
+		e.autoPersist(newName)
+	case PersistOnDemand:
+		persisted, err := e.store.Get(oldName)
+		if err != nil {
+			return nil, err
+		}
+		if persisted == nil || persisted.IsEmpty() {
+			break
+		}
+		if err := e.store.Put(newName, persistValue(val, formatAsDefinition(newName, val))); err != nil {
+			return nil, err
+		}
+		if err := e.store.Delete(oldName); err != nil {
+			return nil, err
+		}
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinCopy(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// COPY src dst
+	// Defines dst as an independent copy of src, parameters and all, and
+	// auto-persists it in ALWAYS mode. src is left as it was.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+	src, dst := args[0], args[1]
+	if res := e.targetNameError(dst); res != nil {
+		return res, nil
+	}
+
+	e.autoLoad(src)
+	val := e.namespace.Get(src)
+	if val.IsEmpty() {
+		return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s is not defined", src)}, nil
+	}
+	if src == dst {
+		return expr.Empty{}, nil
+	}
+
+	switch v := val.(type) {
+	case expr.Stored:
+		val = expr.Stored{Params: slices.Clone(v.Params), Body: v.Body}
+	case expr.Blob:
+		val = expr.Blob{Data: slices.Clone(v.Data)}
+	}
+	e.namespace.Set(dst, val)
+	if e.persistMode == PersistAlways && e.store != nil {
+		e.autoPersist(dst)
+	}
+	return expr.Empty{}, nil
+}
+
+// targetNameError returns the INVALID error for a name RENAME, COPY or
+// SETLINE can't define: one the scanner can't read back or, under ShadowError, a
+// builtin's. It returns nil for a valid name.
+func (e *Evaluator) targetNameError(name string) expr.Expr {
+	switch {
+	case !scanner.IsName(name):
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("invalid name %q: only letters, digits and _ are allowed", name)}
+	case e.shadowing == ShadowError && getBuiltin(name) != nil:
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("name %s is reserved for the builtin", name)}
+	}
+	return nil
+}
+
+func builtinLoad(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// LOAD name [default]
+	// Loads name from store. If not found/empty and default provided, uses default.
+	// If the stored value is a full definition (starts with ▼), re-evals it to
+	// reconstruct the Stored expression with its parameters.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	name := args[0]
+	var defaultVal string
+	if len(args) >= 2 {
+		defaultVal = args[1]
+	}
+
+	// Try loading from store
+	var val expr.Expr
+	if e.store != nil {
+		if err := e.Flush(); err != nil {
+			return nil, err
+		}
+		val, err = e.store.Get(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If we got a value from store, process it
+	if val != nil && !val.IsEmpty() {
+		if err := e.loadValue(name, val); err != nil {
+			return nil, err
+		}
+		return expr.Empty{}, nil
+	}
+
+	// Otherwise use default if provided
+	if defaultVal != "" {
+		e.namespace.Set(name, expr.Stored{Body: defaultVal})
+	}
+
+	return expr.Empty{}, nil
+}
+
+// loadValue puts a non-empty value read from the store into the namespace.
+// A full definition (starting with ▼) is re-evaluated to reconstruct the
+// Stored expression with its parameters.
+func (e *Evaluator) loadValue(name string, val expr.Expr) error {
+	if b, ok := val.(expr.Blob); ok {
+		e.namespace.Set(name, b)
+		return nil
+	}
+
+	text := val.String()
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) > 0 && runes[0] == token.RuneStore {
+		// Re-eval the definition - this will store it in namespace
+		_, err := e.Eval(text)
+		return err
+	}
+	// Plain text value, just set it directly
+	e.namespace.Set(name, expr.Stored{Body: text})
+	return nil
+}
+
+func builtinFlush(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// FLUSH
+	// Writes buffered ALWAYS-mode changes to the store now rather than when
+	// the top-level Eval returns (e.g. before a long READ loop).
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinBackup(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// BACKUP path
+	// Flushes pending writes, then copies the store to a new file at path:
+	// a SQLite database for SQLite stores, an export file for memory stores.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || args[0] == "" {
+		return expr.Error{Code: "INVALID", Message: "BACKUP needs a path"}, nil
+	}
+	bs, ok := e.store.(store.BackupStore)
+	if !ok {
+		return expr.Error{Code: "NO_STORE", Message: "BACKUP needs a store that supports backups"}, nil
+	}
+
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	if err := bs.Backup(args[0]); err != nil {
+		return expr.Error{Code: "BACKUP_FAILED", Message: err.Error()}, nil
+	}
+	return expr.NewText("OK"), nil
+}
+
+func builtinCheckpoint(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// CHECKPOINT key
+	// Saves every definition in the namespace to the store under key, as one
+	// losp source that RESTORE_CHECKPOINT re-evaluates. A no-op in NEVER mode.
+	if e.PersistMode() == PersistNever || e.store == nil {
+		return expr.Empty{}, nil
+	}
+
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	if err := e.store.Put(args[0], expr.Stored{Body: e.snapshot()}); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+// snapshot renders the namespace as a sequence of ▼ definitions. Binary
+// values have no source form and are left out.
+func (e *Evaluator) snapshot() string {
+	var defs []string
+	for _, name := range e.namespace.Names() {
+		val := e.namespace.Get(name)
+		switch val.(type) {
+		case expr.Blob:
+			continue
+		case expr.Stored:
+		default:
+			val = expr.Stored{Body: val.String()}
+		}
+		if def := formatAsDefinition(name, val); def != "" {
+			defs = append(defs, def)
+		}
+	}
+	return strings.Join(defs, "\n")
+}
+
+func builtinRestoreCheckpoint(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// RESTORE_CHECKPOINT key
+	// Re-evaluates a CHECKPOINT, redefining every name it saved. Names
+	// defined since are left as they are.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || e.store == nil {
+		return expr.Empty{}, nil
+	}
+
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	val, err := e.store.Get(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if val == nil || val.IsEmpty() {
+		return expr.Empty{}, nil
+	}
+	if _, err := e.Eval(val.String()); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinExtract(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EXTRACT label source
+	// Parses source for "LABEL: value" format and returns the value
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	label := strings.ToUpper(strings.TrimSpace(args[0]))
+	source := args[1]
+
+	// Parse line by line looking for LABEL: value
+	lines := strings.Split(source, "\n")
+	var result strings.Builder
+	capturing := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// Check if this line starts a new label
+		if potentialLabel, value, ok := parseLabel(trimmed); ok {
+			if strings.ToUpper(potentialLabel) == label {
+				// Found our label, start capturing
+				capturing = true
+				if value != "" {
+					result.WriteString(value)
+				}
+				continue
+			} else if capturing {
+				// Hit a different label, stop capturing
+				break
+			}
+		}
+
+		// If we're capturing, append this line (it's a continuation)
+		if capturing {
+			if result.Len() > 0 {
+				result.WriteString("\n")
+			}
+			result.WriteString(trimmed)
+		}
+	}
+
+	extracted := strings.TrimSpace(result.String())
+	if extracted == "" {
+		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: extracted}, nil
+}
+
+// parseLabel splits a trimmed "LABEL: value" line. A label is letters,
+// digits and underscores only; anything else before the colon means the
+// line is not a label line.
+func parseLabel(line string) (label, value string, ok bool) {
+	colonIdx := strings.Index(line, ":")
+	if colonIdx <= 0 {
+		return "", "", false
+	}
+	label = strings.TrimSpace(line[:colonIdx])
+	for _, r := range label {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return "", "", false
+		}
+	}
+	return label, strings.TrimSpace(line[colonIdx+1:]), true
+}
+
+// extractAllJoin joins the continuation lines of a multi-line value in
+// EXTRACTALL output, keeping each field on one line.
+const extractAllJoin = " ↵ "
+
+func builtinExtractAll(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EXTRACTALL source
+	// Returns every "LABEL: value" field in source, one per line
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	source := strings.Join(args, "\n")
+
+	var fields []string
+	for _, line := range strings.Split(source, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if label, value, ok := parseLabel(trimmed); ok {
+			fields = append(fields, strings.TrimSpace(label+": "+value))
+			continue
+		}
+		// Continuation of the current field; text before the first label is skipped
+		if trimmed == "" || len(fields) == 0 {
+			continue
+		}
+		last := &fields[len(fields)-1]
+		if strings.HasSuffix(*last, ":") {
+			*last += " " + trimmed
+		} else {
+			*last += extractAllJoin + trimmed
+		}
+	}
+
+	if len(fields) == 0 {
+		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: strings.Join(fields, "\n")}, nil
+}
+
+func builtinExtractBlock(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EXTRACT_BLOCK label source
+	// Like EXTRACT, but the value runs until a line indented less than the
+	// label, or a label at the same indent. More-indented lines that look
+	// like labels stay part of the value, which keeps its inner indentation.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+	label := strings.ToUpper(args[0])
+	source := strings.Join(args[1:], "\n")
+
+	var block []string
+	labelIndent := -1
+	for line := range strings.SplitSeq(source, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		potentialLabel, value, isLabel := parseLabel(trimmed)
+		if labelIndent < 0 {
+			if isLabel && strings.ToUpper(potentialLabel) == label {
+				labelIndent = indent
+				block = append(block, value)
+			}
+			continue
+		}
+		if trimmed != "" && (indent < labelIndent || indent == labelIndent && isLabel) {
+			break
+		}
+		block = append(block, strings.TrimRightFunc(line, unicode.IsSpace))
+	}
+	if labelIndent < 0 {
+		return expr.Empty{}, nil
+	}
+
+	// Remove the indent the continuation lines share
+	common := -1
+	for _, line := range block[1:] {
+		if line == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		if common < 0 || indent < common {
+			common = indent
+		}
+	}
+	for i := 1; i < len(block); i++ {
+		if block[i] != "" {
+			block[i] = block[i][common:]
+		}
+	}
+
+	extracted := strings.Trim(strings.Join(block, "\n"), "\n")
+	if extracted == "" {
+		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: extracted}, nil
+}
+
+func builtinPrompt(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	if e.provider == nil {
+		return e.missingProvider(), nil
+	}
+
+	system, user, err := e.promptArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	system = e.withPreamble(system)
+	if res, ok := e.dryRun(system, user); ok {
+		return res, nil
+	}
+
+	response, err := e.prompt(system, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Stored{Body: response}, nil
+}
+
+// inferenceParams are the provider params accepted as PROMPT_WITH overrides
+// and part of the prompt cache key.
+var inferenceParams = []string{"CHAT_MODEL", "TEMPERATURE", "NUM_CTX", "TOP_K", "TOP_P", "MAX_TOKENS", "RETRY_ON_EMPTY"}
+
+// carriedParams are the provider params copied across a PROVIDER switch:
+// the inference params, and RERANK_MODEL, which is only stored until a
+// builtin reranks.
+var carriedParams = append(slices.Clone(inferenceParams), "RERANK_MODEL")
+
+func builtinPromptWith(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// PROMPT_WITH key=value... system user
+	// Leading key=value words override inference params for this call only.
+	if e.provider == nil {
+		return e.missingProvider(), nil
+	}
+
+	overrides := make(map[string]string)
+	rest := strings.TrimLeftFunc(argsRaw, unicode.IsSpace)
+	for rest != "" {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		key, value, ok := strings.Cut(rest[:end], "=")
+		if !ok || token.ContainsOperator(rest[:end]) {
+			break
+		}
+		key = strings.ToUpper(key)
+		if !slices.Contains(inferenceParams, key) {
+			return expr.Error{Code: "INVALID", Message: fmt.Sprintf("unknown inference parameter %q", key)}, nil
+		}
+		overrides[key] = value
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+
+	system, user, err := e.promptArgs(rest)
+	if err != nil {
+		return nil, err
+	}
+	system = e.withPreamble(system)
+	if res, ok := e.dryRun(system, user); ok {
+		return res, nil
+	}
+
+	response, err := e.promptParams(system, user, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Stored{Body: response}, nil
+}
+
+// promptArgs extracts PROMPT's system and user prompts. Exactly two
+// arguments (▶PROMPT ▲System ▲User ◆) are taken as-is, so either may span
+// several lines. Otherwise the evaluated text is split at its first newline:
+// a single line is the user prompt, with no system prompt.
+func (e *Evaluator) promptArgs(argsRaw string) (system, user string, err error) {
+	raw, err := e.splitArgs(argsRaw)
+	if err != nil {
+		return "", "", err
+	}
+	if len(raw) == 2 {
+		if system, err = e.evalArg(raw[0]); err != nil {
+			return "", "", err
+		}
+		if user, err = e.evalArg(raw[1]); err != nil {
+			return "", "", err
+		}
+		return system, user, nil
+	}
+
+	// Evaluate args to resolve any operators (like ▲)
+	evaluated, err := e.Eval(argsRaw)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(evaluated), "\n", 2)
+	if len(parts) == 1 {
+		return "", parts[0], nil
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+func builtinPromptSchema(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// PROMPT_SCHEMA system user schema-name
+	// Constrains the response to the JSON schema stored in schema-name.
+	// Providers without structured output get the schema in the prompt.
+	if e.provider == nil {
+		return e.missingProvider(), nil
+	}
+
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return expr.Empty{}, nil
+	}
+	system, user, schemaName := e.withPreamble(args[0]), args[1], args[2]
+
+	e.autoLoad(schemaName)
+	schema := strings.TrimSpace(e.namespace.Get(schemaName).String())
+	if schema == "" {
+		return expr.Empty{}, nil
+	}
+	if res, ok := e.dryRun(system, user+"\n\nRespond only with JSON matching this schema:\n"+schema); ok {
+		return res, nil
+	}
+
+	var response string
+	if sp, ok := e.provider.(provider.StructuredProvider); ok {
+		response, err = e.timePrompt(system+user+schema, func() (string, error) {
+			return sp.PromptSchema(system, user, schema)
+		})
+	} else {
+		response, err = e.prompt(system, user+"\n\nRespond only with JSON matching this schema:\n"+schema)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Stored{Body: response}, nil
+}
+
+// missingProvider is what prompt-requiring builtins return when no provider
+// is configured: EMPTY by default, NO_PROVIDER when PROVIDER_REQUIRED is set.
+func (e *Evaluator) missingProvider() expr.Expr {
+	if e.GetSetting("PROVIDER_REQUIRED", "FALSE") == "TRUE" {
+		return expr.Stored{Body: "NO_PROVIDER"}
+	}
+	return expr.Empty{}
+}
+
+// withPreamble prepends the PREAMBLE setting to a system prompt.
+func (e *Evaluator) withPreamble(system string) string {
+	preamble := e.GetSetting("PREAMBLE", "")
+	if preamble == "" {
+		return system
+	}
+	if system == "" {
+		return preamble
+	}
+	return preamble + "\n\n" + system
+}
+
+// dryRunLen is how many runes of the user prompt a dry-run placeholder shows.
+const dryRunLen = 80
+
+// dryRun returns the placeholder PROMPT, PROMPT_SCHEMA and GENERATE give
+// instead of calling the provider while SYSTEM DRY_RUN is TRUE, handing the
+// full prompt to the prompt logger. ok is false when dry-run is off.
+func (e *Evaluator) dryRun(system, user string) (result expr.Expr, ok bool) {
+	if e.GetSetting("DRY_RUN", "FALSE") != "TRUE" {
+		return nil, false
+	}
+	if e.promptLogger != nil {
+		e.promptLogger(system, user)
+	}
+	if r := []rune(user); len(r) > dryRunLen {
+		user = string(r[:dryRunLen])
+	}
+	return expr.Stored{Body: "[DRY_RUN] " + user}, true
+}
+
+func builtinSystem(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// SYSTEM setting [value]
+	// With one arg: returns current value
+	// With two args: sets new value
+	// Arguments are expressions — newlines or operators separate them
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	setting := strings.ToUpper(strings.TrimSpace(args[0]))
+	var value string
+	if len(args) >= 2 {
+		value = strings.TrimSpace(args[1])
+	}
+	if (value != "" || settingActions[setting]) && !e.allowsSetting(setting) {
+		return forbidden(), nil
+	}
+
+	switch setting {
+	case "PERSIST_MODE":
+		if value != "" {
+			mode, ok := ParsePersistMode(value)
+			if !ok {
+				return expr.Stored{Body: "UNKNOWN"}, nil
+			}
+			e.SetPersistMode(mode)
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.PersistMode().String()}, nil
+
+	case "MODEL":
+		if cfg, ok := e.provider.(Configurable); ok {
+			if value != "" {
+				cfg.SetModel(value)
+				return expr.Empty{}, nil
+			}
+			return expr.Stored{Body: cfg.GetModel()}, nil
+		}
+		return expr.Empty{}, nil
+
+	case "PROVIDER":
+		if value != "" {
+			name := strings.ToUpper(value)
+			factory, ok := e.providerFactories[name]
+			if !ok {
+				return expr.Stored{Body: "UNKNOWN_PROVIDER"}, nil
+			}
+			// Copy inference params from old provider to new one
+			var oldParams map[string]string
+			if cfg, ok := e.provider.(Configurable); ok {
+				for _, key := range carriedParams {
+					if v := cfg.GetParam(key); v != "" {
+						if oldParams == nil {
+							oldParams = make(map[string]string)
+						}
+						oldParams[key] = v
+					}
+				}
+			}
+			newProvider := factory(e.streamCallback())
+			if cfg, ok := newProvider.(Configurable); ok && oldParams != nil {
+				for k, v := range oldParams {
+					cfg.SetParam(k, v)
+				}
+			}
+			e.provider = newProvider
+			return expr.Empty{}, nil
+		}
+		// Get current provider name
+		if cfg, ok := e.provider.(Configurable); ok {
+			return expr.Stored{Body: cfg.ProviderName()}, nil
+		}
+		return expr.Empty{}, nil
+
+	case "TEMPERATURE", "NUM_CTX", "TOP_K", "TOP_P", "MAX_TOKENS", "CHAT_MODEL", "RERANK_MODEL", "RETRY_ON_EMPTY":
+		if cfg, ok := e.provider.(Configurable); ok {
+			if value != "" {
+				cfg.SetParam(setting, value)
+				return expr.Empty{}, nil
+			}
+			return expr.Stored{Body: cfg.GetParam(setting)}, nil
+		}
+		return expr.Empty{}, nil
+
+	case "EMBED_MODEL":
+		if value != "" {
+			e.SetSetting("EMBED_MODEL", value)
+			// Update the embedding provider's model, and the chat provider's
+			// in case it also serves embeddings
+			if e.embeddingProvider != nil {
+				if cfg, ok := e.embeddingProvider.(Configurable); ok {
+					cfg.SetParam("EMBED_MODEL", value)
+				}
+			}
+			if cfg, ok := e.provider.(Configurable); ok {
+				cfg.SetParam("EMBED_MODEL", value)
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("EMBED_MODEL", "nomic-embed-text:latest")}, nil
+
+	case "MOCK_RESPONSE":
+		if value != "" {
+			e.SetSetting("MOCK_RESPONSE", value)
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("MOCK_RESPONSE", "")}, nil
+
+	case "PROVIDER_REQUIRED":
+		if value != "" {
+			switch strings.ToUpper(value) {
+			case "TRUE", "FALSE":
+				e.SetSetting("PROVIDER_REQUIRED", strings.ToUpper(value))
+			default:
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("PROVIDER_REQUIRED", "FALSE")}, nil
+
+	case "PROVIDER_CONCURRENCY":
+		if value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			e.providerLimit.SetLimit(n)
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: strconv.Itoa(e.providerLimit.Limit())}, nil
+
+	case "MAX_OUTPUT":
+		if value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			e.maxOutput = n
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: strconv.Itoa(e.maxOutput)}, nil
+
+	case "MAX_DEPTH":
+		if value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			e.maxDepth = n
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: strconv.Itoa(e.maxDepth)}, nil
+
+	case "AUTO_EMBED":
+		if value != "" {
+			switch strings.ToUpper(value) {
+			case "TRUE", "FALSE":
+				e.SetSetting("AUTO_EMBED", strings.ToUpper(value))
+			default:
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("AUTO_EMBED", "FALSE")}, nil
+
+	case "DRY_RUN":
+		if value != "" {
+			switch strings.ToUpper(value) {
+			case "TRUE", "FALSE":
+				e.SetSetting("DRY_RUN", strings.ToUpper(value))
+			default:
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("DRY_RUN", "FALSE")}, nil
+
+	case "KEEP_EMPTY_ARGS":
+		if value != "" {
+			switch strings.ToUpper(value) {
+			case "TRUE", "FALSE":
+				e.SetSetting("KEEP_EMPTY_ARGS", strings.ToUpper(value))
+			default:
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("KEEP_EMPTY_ARGS", "FALSE")}, nil
+
+	case "PREAMBLE":
+		if value != "" {
+			if strings.ToUpper(value) == "NONE" {
+				value = ""
+			}
+			e.SetSetting("PREAMBLE", value)
+			return expr.Empty{}, nil
+		}
+		return expr.NewText(e.GetSetting("PREAMBLE", "")), nil
+
+	case "GEN_PROMPT":
+		if value != "" {
+			if strings.ToUpper(value) == "NONE" {
+				value = ""
+			}
+			e.SetSetting("GEN_PROMPT", value)
+			return expr.Empty{}, nil
+		}
+		return expr.NewText(e.GetSetting("GEN_PROMPT", "")), nil
+
+	case "SEARCH_LIMIT":
+		if value != "" {
+			e.SetSetting("SEARCH_LIMIT", value)
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("SEARCH_LIMIT", "10")}, nil
+
+	case "HISTORY_LIMIT":
+		if value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			e.historyLimit = n
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: strconv.Itoa(e.historyLimit)}, nil
+
+	case "PROMPT_LATENCY":
+		summary := e.promptLatency.Summary()
+		if summary == "" {
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: summary}, nil
+
+	case "RESET":
+		// Every collected metric: PROMPT_LATENCY, the usage totals, and the
+		// prompt cache with CACHE_STATS
+		e.promptLatency.Reset()
+		e.promptUsage.Reset()
+		e.promptCache.Clear()
+		return expr.Empty{}, nil
+
+	case "PROMPT_CACHE":
+		if value != "" {
+			switch strings.ToUpper(value) {
+			case "TRUE", "FALSE":
+				e.SetSetting("PROMPT_CACHE", strings.ToUpper(value))
+			default:
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("PROMPT_CACHE", "FALSE")}, nil
+
+	case "CACHE_SIZE":
+		return expr.Stored{Body: strconv.Itoa(e.promptCache.Len())}, nil
+
+	case "CACHE_STATS":
+		return expr.Stored{Body: e.promptCache.Stats()}, nil
+
+	case "CACHE_CLEAR":
+		e.promptCache.Clear()
+		return expr.Empty{}, nil
+
+	case "LOG_LEVEL":
+		if value != "" {
+			level := strings.ToUpper(value)
+			if _, ok := logLevels[level]; !ok {
+				return expr.Stored{Body: "INVALID"}, nil
+			}
+			e.SetSetting("LOG_LEVEL", level)
+			return expr.Empty{}, nil
+		}
+		return expr.Stored{Body: e.GetSetting("LOG_LEVEL", "INFO")}, nil
+
+	case "OUTPUT":
+		if value != "" {
+			e.redirectOutput(value)
+			return expr.Empty{}, nil
+		}
+		if e.outputVar == "" {
+			return expr.Stored{Body: "STDOUT"}, nil
+		}
+		return expr.Stored{Body: e.outputVar}, nil
+
+	default:
+		return expr.Stored{Body: "UNKNOWN_SETTING"}, nil
+	}
+}
+
+func builtinUpper(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	var results []string
+	for _, arg := range args {
+		results = append(results, strings.ToUpper(arg))
+	}
+
+	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+}
+
+func builtinLower(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	var results []string
+	for _, arg := range args {
+		results = append(results, strings.ToLower(arg))
+	}
+
+	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+}
+
+func builtinTrim(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	var results []string
+	for _, arg := range args {
+		trimmed := strings.TrimSpace(arg)
+		if trimmed != "" {
+			results = append(results, trimmed)
+		}
+	}
+
+	if len(results) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+}
+
+func builtinSubstitute(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// SUBSTITUTE source find1 replace1 [find2 replace2 ...]
+	// All pairs are applied in a single left-to-right scan, so replaced text
+	// is never re-matched by a later pair. An unpaired find or an empty one
+	// is INVALID.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	pairs := args[1:]
+	if len(pairs)%2 != 0 {
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("SUBSTITUTE needs find/replace pairs, got %d arguments after the source", len(pairs))}, nil
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == "" {
+			return expr.Error{Code: "INVALID", Message: "SUBSTITUTE cannot find empty text"}, nil
+		}
+	}
+
+	return expr.NewText(strings.NewReplacer(pairs...).Replace(args[0])), nil
+}
+
+func builtinLimit(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// LIMIT source n [ellipsis]
+	// Returns the first n runes of source, plus ellipsis (default "…") if
+	// anything was cut. Counts runes, so operator glyphs count as one.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		return expr.Empty{}, nil
+	}
+	ellipsis := "…"
+	if len(args) >= 3 {
+		ellipsis = args[2]
+	}
+
+	runes := []rune(args[0])
+	if len(runes) <= n {
+		return expr.NewText(args[0]), nil
+	}
+	return expr.NewText(string(runes[:n]) + ellipsis), nil
+}
+
+func builtinSplitN(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// SPLITN source [delimiter] n
+	// Splits source on delimiter into at most n pieces, one per line; the
+	// last piece keeps any further delimiters. Arguments are trimmed, so
+	// ": " arrives as ":" and pieces are trimmed to match. Without a
+	// delimiter, splits on runs of whitespace.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	n, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || n < 1 {
+		return expr.Empty{}, nil
+	}
+
+	var pieces []string
+	if len(args) == 2 || args[1] == "" {
+		pieces = fieldsN(args[0], n)
+	} else {
+		pieces = strings.SplitN(args[0], args[1], n)
+	}
+	for i, p := range pieces {
+		pieces[i] = strings.TrimSpace(p)
+	}
+	return expr.NewText(strings.Join(pieces, "\n")), nil
+}
+
+// fieldsN splits s around runs of whitespace into at most n pieces, the
+// last holding the rest of s.
+func fieldsN(s string, n int) []string {
+	var pieces []string
+	s = strings.TrimSpace(s)
+	for len(pieces) < n-1 {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			break
+		}
+		pieces = append(pieces, s[:i])
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	}
+	return append(pieces, s)
+}
+
+func builtinWrap(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// WRAP width source
+	// Reflows each paragraph of source to at most width runes per line,
+	// breaking only between words. Blank lines between paragraphs are kept;
+	// a word longer than width gets a line of its own.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	width, err := strconv.Atoi(args[0])
+	if err != nil || width <= 0 {
+		return expr.Empty{}, nil
+	}
+	source := strings.Join(args[1:], "\n")
+
+	var out []string
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			out = append(out, wrapWords(paragraph, width)...)
+			paragraph = nil
+		}
+	}
+	for _, line := range strings.Split(source, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			flush()
+			out = append(out, "")
+			continue
+		}
+		paragraph = append(paragraph, words...)
+	}
+	flush()
 
-	// Parse args: system-prompt user-prompt
-	// Split on double newline or use first line as system
-	text := strings.TrimSpace(evaluated)
-
-	parts := strings.SplitN(text, "\n", 2)
+	return expr.NewText(strings.Join(out, "\n")), nil
+}
 
-	var system, user string
-	if len(parts) == 1 {
-		user = parts[0]
-	} else {
-		system = strings.TrimSpace(parts[0])
-		user = strings.TrimSpace(parts[1])
+// wrapWords greedily packs words into lines of at most width runes.
+func wrapWords(words []string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	lineLen := 0
+	for _, word := range words {
+		n := utf8.RuneCountInString(word)
+		if lineLen > 0 && lineLen+1+n > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			lineLen = 0
+		}
+		if lineLen > 0 {
+			line.WriteByte(' ')
+			lineLen++
+		}
+		line.WriteString(word)
+		lineLen += n
 	}
-
-	response, err := e.provider.Prompt(system, user)
-	if err != nil {
-		return nil, err
+	if lineLen > 0 {
+		lines = append(lines, line.String())
 	}
-
-	return expr.Stored{Body: response}, nil
+	return lines
 }
 
-func builtinSystem(e *Evaluator, argsRaw string) (expr.Expr, error) {
-	// SYSTEM setting [value]
-	// With one arg: returns current value
-	// With two args: sets new value
-	// Arguments are expressions — newlines or operators separate them
+func builtinTable(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// TABLE [HEADER] source
+	// Renders tab-separated lines as columns padded with spaces to the widest
+	// cell, measured in runes. With HEADER, the first row is followed by a
+	// line of dashes. Short rows are padded with empty cells.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-	if len(args) < 1 {
-		return expr.Empty{}, nil
-	}
-
-	setting := strings.ToUpper(strings.TrimSpace(args[0]))
-	var value string
-	if len(args) >= 2 {
-		value = strings.TrimSpace(args[1])
+	header := len(args) > 1 && strings.ToUpper(args[0]) == "HEADER"
+	if header {
+		args = args[1:]
 	}
 
-	switch setting {
-	case "PERSIST_MODE":
-		if value != "" {
-			mode, ok := ParsePersistMode(value)
-			if !ok {
-				return expr.Stored{Body: "UNKNOWN"}, nil
-			}
-			e.SetPersistMode(mode)
-			return expr.Empty{}, nil
+	var rows [][]string
+	var widths []int
+	for _, line := range strings.Split(strings.Join(args, "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
-		return expr.Stored{Body: e.PersistMode().String()}, nil
-
-	case "MODEL":
-		if cfg, ok := e.provider.(Configurable); ok {
-			if value != "" {
-				cfg.SetModel(value)
-				return expr.Empty{}, nil
+		cells := strings.Split(line, "\t")
+		for i, cell := range cells {
+			cells[i] = strings.TrimSpace(cell)
+			if i == len(widths) {
+				widths = append(widths, 0)
 			}
-			return expr.Stored{Body: cfg.GetModel()}, nil
+			widths[i] = max(widths[i], utf8.RuneCountInString(cells[i]))
 		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 {
 		return expr.Empty{}, nil
+	}
 
-	case "PROVIDER":
-		if value != "" {
-			name := strings.ToUpper(value)
-			factory, ok := e.providerFactories[name]
-			if !ok {
-				return expr.Stored{Body: "UNKNOWN_PROVIDER"}, nil
-			}
-			// Copy inference params from old provider to new one
-			var oldParams map[string]string
-			if cfg, ok := e.provider.(Configurable); ok {
-				for _, key := range []string{"TEMPERATURE", "NUM_CTX", "TOP_K", "TOP_P", "MAX_TOKENS"} {
-					if v := cfg.GetParam(key); v != "" {
-						if oldParams == nil {
-							oldParams = make(map[string]string)
-						}
-						oldParams[key] = v
-					}
-				}
-			}
-			newProvider := factory(e.streamCb)
-			if cfg, ok := newProvider.(Configurable); ok && oldParams != nil {
-				for k, v := range oldParams {
-					cfg.SetParam(k, v)
-				}
+	var out []string
+	for i, cells := range rows {
+		out = append(out, tableRow(cells, widths))
+		if header && i == 0 {
+			dashes := make([]string, len(widths))
+			for j, w := range widths {
+				dashes[j] = strings.Repeat("-", w)
 			}
-			e.provider = newProvider
-			return expr.Empty{}, nil
-		}
-		// Get current provider name
-		if cfg, ok := e.provider.(Configurable); ok {
-			return expr.Stored{Body: cfg.ProviderName()}, nil
+			out = append(out, tableRow(dashes, widths))
 		}
-		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: strings.Join(out, "\n")}, nil
+}
 
-	case "TEMPERATURE", "NUM_CTX", "TOP_K", "TOP_P", "MAX_TOKENS":
-		if cfg, ok := e.provider.(Configurable); ok {
-			if value != "" {
-				cfg.SetParam(setting, value)
-				return expr.Empty{}, nil
-			}
-			return expr.Stored{Body: cfg.GetParam(setting)}, nil
+// tableRow pads cells to their column widths, two spaces apart, without
+// trailing spaces.
+func tableRow(cells []string, widths []int) string {
+	var sb strings.Builder
+	for i, w := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		if i > 0 {
+			sb.WriteString("  ")
 		}
+		sb.WriteString(cell)
+		sb.WriteString(strings.Repeat(" ", w-utf8.RuneCountInString(cell)))
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+func builtinEscapePrompt(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// ESCAPE_PROMPT source
+	// Wraps source in a ``` fenced block for interpolation into a prompt.
+	// Backticks in runs of three or more are backslash-escaped, so the
+	// source can't close the fence and break out of the block.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	source := strings.Join(args, "\n")
+	if strings.TrimSpace(source) == "" {
 		return expr.Empty{}, nil
+	}
+	return expr.Stored{Body: "```\n" + escapeFences(source) + "\n```"}, nil
+}
 
-	case "EMBED_MODEL":
-		if value != "" {
-			e.SetSetting("EMBED_MODEL", value)
-			// Update the embedding provider's model
-			if e.embeddingProvider != nil {
-				if cfg, ok := e.embeddingProvider.(Configurable); ok {
-					cfg.SetParam("EMBED_MODEL", value)
-				}
-			}
-			return expr.Empty{}, nil
+// escapeFences backslash-escapes every backtick in runs of three or more.
+func escapeFences(s string) string {
+	var sb strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '`')
+		if i < 0 {
+			sb.WriteString(s)
+			break
 		}
-		return expr.Stored{Body: e.GetSetting("EMBED_MODEL", "nomic-embed-text:latest")}, nil
+		sb.WriteString(s[:i])
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if run >= 3 {
+			sb.WriteString(strings.Repeat("\\`", run))
+		} else {
+			sb.WriteString(s[i : i+run])
+		}
+		s = s[i+run:]
+	}
+	return sb.String()
+}
 
-	case "SEARCH_LIMIT":
-		if value != "" {
-			e.SetSetting("SEARCH_LIMIT", value)
-			return expr.Empty{}, nil
+func builtinConcat(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// CONCAT ["separator"] arg1 arg2 ...
+	// Joins the evaluated arguments with no separator, bypassing the newline
+	// rules between statements. A double-quoted literal as the first argument
+	// (e.g. ", " or "\n") is used as the separator instead.
+	raw, err := e.splitArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := ""
+	if len(raw) > 0 && len(raw[0]) >= 2 && strings.HasPrefix(raw[0], `"`) && strings.HasSuffix(raw[0], `"`) {
+		if unquoted, err := strconv.Unquote(raw[0]); err == nil {
+			sep = unquoted
+			raw = raw[1:]
 		}
-		return expr.Stored{Body: e.GetSetting("SEARCH_LIMIT", "10")}, nil
+	}
 
-	case "HISTORY_LIMIT":
-		if value != "" {
-			n, err := strconv.Atoi(value)
-			if err != nil {
-				return expr.Stored{Body: "INVALID"}, nil
-			}
-			e.historyLimit = n
-			return expr.Empty{}, nil
+	parts := make([]string, 0, len(raw))
+	for _, r := range raw {
+		arg, err := e.evalArg(r)
+		if err != nil {
+			return nil, err
 		}
-		return expr.Stored{Body: strconv.Itoa(e.historyLimit)}, nil
+		parts = append(parts, arg)
+	}
+	return expr.NewText(strings.Join(parts, sep)), nil
+}
 
-	default:
-		return expr.Stored{Body: "UNKNOWN_SETTING"}, nil
+func builtinCoalesce(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// COALESCE arg1 arg2 ...
+	// Returns the first argument that is non-empty after trimming. Arguments
+	// after it are not evaluated, so a costly fallback runs only when needed.
+	raw, err := e.splitArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range raw {
+		arg, err := e.evalArg(r)
+		if err != nil {
+			return nil, err
+		}
+		if arg = strings.TrimSpace(arg); arg != "" {
+			return expr.NewText(arg), nil
+		}
 	}
+	return expr.Empty{}, nil
 }
 
-func builtinUpper(e *Evaluator, argsRaw string) (expr.Expr, error) {
+func builtinBase64Encode(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// BASE64_ENCODE name
+	// Returns the standard base64 encoding of name's value. Blobs are encoded
+	// byte-for-byte; text values are read as a ▲ argument would be.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(args) == 0 {
+	if len(args) < 1 {
 		return expr.Empty{}, nil
 	}
 
-	var results []string
-	for _, arg := range args {
-		results = append(results, strings.ToUpper(arg))
+	name := args[0]
+	e.autoLoad(name)
+	if b, ok := e.namespace.Get(name).(expr.Blob); ok {
+		return expr.NewText(base64.StdEncoding.EncodeToString(b.Data)), nil
 	}
-
-	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+	text, err := e.peek(name)
+	if err != nil {
+		return nil, err
+	}
+	return expr.NewText(base64.StdEncoding.EncodeToString([]byte(strings.TrimSpace(text)))), nil
 }
 
-func builtinLower(e *Evaluator, argsRaw string) (expr.Expr, error) {
+func builtinBase64Decode(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// BASE64_DECODE name text
+	// Decodes base64 text and stores the bytes under name as a Blob, which is
+	// persisted and retrieved without operator parsing or trimming. Invalid
+	// base64 leaves name unchanged.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(args) == 0 {
+	if len(args) < 2 {
 		return expr.Empty{}, nil
 	}
 
-	var results []string
-	for _, arg := range args {
-		results = append(results, strings.ToLower(arg))
+	name := args[0]
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(args[1]), ""))
+	if err != nil {
+		return expr.Empty{}, nil
 	}
-
-	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+	e.namespace.Set(name, expr.Blob{Data: data})
+	if e.persistMode == PersistAlways && e.store != nil {
+		e.autoPersist(name)
+	}
+	return expr.Empty{}, nil
 }
 
-func builtinTrim(e *Evaluator, argsRaw string) (expr.Expr, error) {
+// envRef matches $VAR and ${VAR}.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+func builtinExpandPath(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EXPAND_PATH path
+	// Expands a leading ~ to the home directory and $VAR or ${VAR} to
+	// environment variables on the evaluator's allow-list. Variables not
+	// on the list are left as written.
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(args) == 0 {
+	if len(args) < 1 {
 		return expr.Empty{}, nil
 	}
+	path := args[0]
 
-	var results []string
-	for _, arg := range args {
-		trimmed := strings.TrimSpace(arg)
-		if trimmed != "" {
-			results = append(results, trimmed)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home := e.homeDir
+		if home == "" {
+			home, _ = os.UserHomeDir()
+		}
+		if home != "" {
+			path = home + path[1:]
 		}
 	}
 
-	if len(results) == 0 {
-		return expr.Empty{}, nil
-	}
-
-	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+	path = envRef.ReplaceAllStringFunc(path, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		name := m[1] + m[2]
+		if !slices.Contains(e.envAllowed, name) {
+			return ref
+		}
+		return os.Getenv(name)
+	})
+	return expr.NewText(path), nil
 }
 
 func builtinGenerate(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	if e.provider == nil {
-		return expr.Empty{}, nil
+		return e.missingProvider(), nil
 	}
 
 	evaluated, err := e.Eval(argsRaw)
@@ -738,6 +2544,45 @@ func builtinGenerate(e *Evaluator, argsRaw string) (expr.Expr, error) {
 		return expr.Empty{}, nil
 	}
 
+	code, res, err := e.generate(request)
+	if res != nil || err != nil {
+		return res, err
+	}
+	return expr.Stored{Body: code}, nil
+}
+
+func builtinGenerateAs(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// GENERATE_AS name request...
+	// Generates code for request and evaluates it to define name.
+	if e.provider == nil {
+		return e.missingProvider(), nil
+	}
+
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+	target := args[0]
+	switch {
+	case !scanner.IsName(target):
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("invalid name %q: only letters, digits and _ are allowed", target)}, nil
+	case getBuiltin(target) != nil:
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("%s is a builtin and can't be generated", target)}, nil
+	}
+
+	code, res, err := e.generate(strings.Join(args[1:], "\n"))
+	if res != nil || err != nil {
+		return res, err
+	}
+	return e.defineGenerated(target, code)
+}
+
+// generate asks the provider for losp code fulfilling request. Under
+// SYSTEM DRY_RUN it returns the dry-run placeholder as res instead.
+func (e *Evaluator) generate(request string) (code string, res expr.Expr, err error) {
 	// Use compact primer to fit within model context limits.
 	// Select model-specific primer when available.
 	system := stdlib.PrimerCompact
@@ -746,12 +2591,181 @@ func builtinGenerate(e *Evaluator, argsRaw string) (expr.Expr, error) {
 			system = stdlib.PrimerCompactNemotron
 		}
 	}
+	if conventions := e.GetSetting("GEN_PROMPT", ""); conventions != "" {
+		system = conventions + "\n\n" + system
+	}
+	system = e.withPreamble(system)
 	user := request + "\n\nOutput ONLY raw losp code. Do NOT wrap in markdown code fences. No ``` blocks. No explanation. Just the raw losp operators and text."
+	if res, ok := e.dryRun(system, user); ok {
+		return "", res, nil
+	}
+
+	response, err := e.prompt(system, user)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(response), nil, nil
+}
+
+// defineGenerated evaluates GENERATE_AS output meant to define target, in the
+// safe sandbox. The code must pass VALIDATE, may not change or delete an
+// existing expression other than target, and must leave target defined.
+// Writes to existing names are refused as they happen, however the code
+// makes them. If the code fails, everything it defined is undone.
+// Returns target's name.
+func (e *Evaluator) defineGenerated(target, code string) (expr.Expr, error) {
+	if findings := e.validate(code); len(findings) > 0 {
+		return expr.Error{Code: "INVALID", Message: "generated code failed validation: " + strings.Join(findings, "; ")}, nil
+	}
+
+	priorTarget, priorKept := e.namespace.Get(target), maps.Clone(e.sandboxNames)
+	existing := make(map[string]bool)
+	for _, name := range e.namespace.Names() {
+		existing[name] = name != target
+	}
+	var refused []string
+	e.namespace.Guard(func(name string) bool {
+		if existing[name] {
+			refused = append(refused, name)
+			return false
+		}
+		return true
+	})
+
+	prior, priorOutput, priorDepth := e.sandbox, e.maxOutput, e.maxDepth
+	e.sandbox = SandboxSafe
+	e.maxOutput = tighterLimit(e.maxOutput, SafeMaxOutput)
+	e.maxDepth = tighterLimit(e.maxDepth, e.depth+SafeMaxDepth)
+	_, err := e.Eval(code)
+	e.sandbox, e.maxOutput, e.maxDepth = prior, priorOutput, priorDepth
+	e.namespace.Guard(nil)
+
+	var res expr.Expr
+	switch {
+	case len(refused) > 0:
+		res = expr.Error{Code: "INVALID", Message: fmt.Sprintf("generated code redefines %s", refused[0])}
+	case err == nil && e.namespace.Get(target).IsEmpty():
+		res = expr.Error{Code: "INVALID", Message: fmt.Sprintf("generated code did not define %s", target)}
+	}
+	if res != nil || err != nil {
+		for _, name := range e.namespace.Names() {
+			if _, ok := existing[name]; !ok {
+				e.namespace.Delete(name)
+			}
+		}
+		if _, ok := existing[target]; ok {
+			e.namespace.Set(target, priorTarget)
+		}
+		e.sandboxNames = priorKept
+		if res != nil {
+			return res, nil
+		}
+		return nil, err
+	}
+	// The sandbox kept the definition out of the store; the caller asked for it
+	if e.persistMode == PersistAlways && e.store != nil {
+		delete(e.sandboxNames, target)
+		e.autoPersist(target)
+	}
+	return expr.Stored{Body: target}, nil
+}
+
+// validateMaxDepth is how deeply VALIDATE lets operators nest.
+const validateMaxDepth = 16
 
-	response, err := e.provider.Prompt(system, user)
+func builtinValidate(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// VALIDATE source
+	// Checks source without running it: OK, or one finding per line.
+	source, err := e.sourceArg(argsRaw)
 	if err != nil {
 		return nil, err
 	}
+	findings := e.validate(source)
+	if len(findings) == 0 {
+		return expr.Stored{Body: "OK"}, nil
+	}
+	return expr.Stored{Body: strings.Join(findings, "\n")}, nil
+}
+
+func builtinParse(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// PARSE source
+	// TRUE if source is well-formed losp, otherwise the first parse error.
+	// Nothing is run.
+	source, err := e.sourceArg(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.Parse(source); err != nil {
+		return expr.Stored{Body: err.Error()}, nil
+	}
+	return expr.Stored{Body: "TRUE"}, nil
+}
+
+// sourceArg returns the losp source VALIDATE and PARSE check. A lone ▲Name
+// is taken as stored, since retrieving it as an argument would already
+// have parsed it.
+func (e *Evaluator) sourceArg(argsRaw string) (string, error) {
+	raw, err := e.splitArgs(argsRaw)
+	if err != nil {
+		return "", err
+	}
+	if name, ok := retrievedName(raw); ok {
+		e.autoLoad(name)
+		return e.namespace.Get(name).String(), nil
+	}
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(args, "\n"), nil
+}
+
+// retrievedName returns Name when raw is a single ▲Name or △Name argument.
+func retrievedName(raw []string) (string, bool) {
+	if len(raw) != 1 {
+		return "", false
+	}
+	for _, op := range []string{string(token.RuneRetrieve), string(token.RuneImmRetrieve)} {
+		if name, ok := strings.CutPrefix(raw[0], op); ok && scanner.IsName(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// validate statically checks losp source for what would make it unsafe to
+// run: an operator missing its ◆, a call to a builtin the safe sandbox
+// forbids, or nesting deeper than validateMaxDepth. Builtins reached
+// through dynamic names can't be seen; the sandbox still catches those.
+func (e *Evaluator) validate(source string) []string {
+	nodes, err := e.Parse(source)
+	if err != nil {
+		return []string{err.Error()}
+	}
 
-	return expr.Stored{Body: strings.TrimSpace(response)}, nil
+	var findings []string
+	tooDeep := false
+	var walk func(nodes []Node, depth int)
+	walk = func(nodes []Node, depth int) {
+		for _, n := range nodes {
+			if n.Op == 0 || n.Op == token.RuneTerminator {
+				continue
+			}
+			if depth > validateMaxDepth && !tooDeep {
+				findings = append(findings, fmt.Sprintf("nesting depth %d exceeds %d at line %d", depth, validateMaxDepth, n.Line))
+				tooDeep = true
+			}
+			if n.Op == token.RuneExecute || n.Op == token.RuneImmExecute {
+				if getBuiltin(n.Name) != nil && !safeBuiltins[n.Name] {
+					findings = append(findings, fmt.Sprintf("forbidden builtin %s at line %d", n.Name, n.Line))
+				}
+			}
+			if n.DynName != nil {
+				walk([]Node{*n.DynName}, depth+1)
+			}
+			walk(n.Children, depth+1)
+		}
+	}
+	walk(nodes, 1)
+	return findings
 }
@@ -36,6 +36,7 @@ func builtinAsync(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	go func() {
 		defer e.asyncRegistry.wg.Done()
 		defer close(h.done)
+		defer forked.Flush()
 		result, err := forked.execute(name, "")
 		if err != nil {
 			h.err = err
@@ -70,6 +71,57 @@ func builtinAwait(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: h.result}, nil
 }
 
+func builtinOnDone(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// ONDONE handle handler-name
+	// Callback alternative to AWAIT: once handle completes, handler-name runs
+	// in a forked evaluator with the result bound to its first placeholder.
+	// Returns a handle for the handler's own run.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	target := e.asyncRegistry.Get(args[0])
+	if target == nil {
+		return expr.Empty{}, nil
+	}
+	name := args[1]
+
+	// Verify expression exists
+	e.autoLoad(name)
+	stored := e.namespace.Get(name)
+	if stored.IsEmpty() {
+		return expr.Empty{}, nil
+	}
+
+	h := e.asyncRegistry.Register(false, 0)
+	forked := e.forkForAsync()
+
+	e.asyncRegistry.wg.Add(1)
+	go func() {
+		defer e.asyncRegistry.wg.Done()
+		defer close(h.done)
+		defer forked.Flush()
+		<-target.done
+		// A failed task hands over EMPTY, as AWAIT would
+		var value string
+		if target.err == nil {
+			value = target.result
+		}
+		result, err := forked.executeStored(name, stored, []string{value}, nil)
+		if err != nil {
+			h.err = err
+			return
+		}
+		h.result = strings.TrimSpace(result.String())
+	}()
+
+	return expr.Stored{Body: h.id}, nil
+}
+
 func builtinCheck(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
@@ -78,18 +130,60 @@ func builtinCheck(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	if len(args) < 1 {
 		return expr.Stored{Body: "FALSE"}, nil
 	}
+	if e.handleDone(args[0]) {
+		return expr.Stored{Body: "TRUE"}, nil
+	}
+	return expr.Stored{Body: "FALSE"}, nil
+}
 
-	id := args[0]
-	h := e.asyncRegistry.Get(id)
-	if h == nil {
+func builtinCheckAll(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	ids, err := e.handleArgs(argsRaw)
+	if err != nil || len(ids) == 0 {
+		return expr.Stored{Body: "FALSE"}, nil
+	}
+	for _, id := range ids {
+		if !e.handleDone(id) {
+			return expr.Stored{Body: "FALSE"}, nil
+		}
+	}
+	return expr.Stored{Body: "TRUE"}, nil
+}
+
+func builtinCheckAny(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	ids, err := e.handleArgs(argsRaw)
+	if err != nil {
 		return expr.Stored{Body: "FALSE"}, nil
 	}
+	for _, id := range ids {
+		if e.handleDone(id) {
+			return expr.Stored{Body: "TRUE"}, nil
+		}
+	}
+	return expr.Stored{Body: "FALSE"}, nil
+}
+
+// handleArgs returns the handle IDs in argsRaw, one per non-blank line. An
+// argument may hold several lines, such as a list collected with APPEND.
+func (e *Evaluator) handleArgs(argsRaw string) ([]string, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	return argLines(args), nil
+}
 
+// handleDone reports, without blocking, whether the async task or timer
+// with the given ID has finished. Unknown IDs are never done.
+func (e *Evaluator) handleDone(id string) bool {
+	h := e.asyncRegistry.Get(id)
+	if h == nil {
+		return false
+	}
 	select {
 	case <-h.done:
-		return expr.Stored{Body: "TRUE"}, nil
+		return true
 	default:
-		return expr.Stored{Body: "FALSE"}, nil
+		return false
 	}
 }
 
@@ -122,6 +216,7 @@ func builtinTimer(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	h.timer = time.AfterFunc(duration, func() {
 		defer e.asyncRegistry.wg.Done()
 		defer close(h.done)
+		defer forked.Flush()
 		result, err := forked.execute(name, "")
 		if err != nil {
 			h.err = err
@@ -148,22 +243,28 @@ func builtinTicks(e *Evaluator, argsRaw string) (expr.Expr, error) {
 		return expr.Stored{Body: "0"}, nil
 	}
 
-	// Non-timer or already completed: return 0
-	if !h.isTimer {
-		return expr.Stored{Body: "0"}, nil
-	}
+	// Non-timer or already completed: 0
+	return expr.Stored{Body: fmt.Sprintf("%d", h.Remaining().Milliseconds())}, nil
+}
 
-	select {
-	case <-h.done:
-		return expr.Stored{Body: "0"}, nil
-	default:
+func builtinTasks(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// TASKS
+	// One line per handle: "id STATE", plus remaining ms for pending timers.
+	handles := e.asyncRegistry.Handles()
+	if len(handles) == 0 {
+		return expr.Empty{}, nil
 	}
 
-	remaining := time.Until(h.fireAt)
-	if remaining < 0 {
-		remaining = 0
+	lines := make([]string, 0, len(handles))
+	for _, h := range handles {
+		state := h.State()
+		line := h.ID() + " " + state
+		if h.IsTimer() && state == "RUNNING" {
+			line += fmt.Sprintf(" %d", h.Remaining().Milliseconds())
+		}
+		lines = append(lines, line)
 	}
-	return expr.Stored{Body: fmt.Sprintf("%d", remaining.Milliseconds())}, nil
+	return expr.Stored{Body: strings.Join(lines, "\n")}, nil
 }
 
 func builtinSleep(e *Evaluator, argsRaw string) (expr.Expr, error) {
@@ -183,3 +284,81 @@ func builtinSleep(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	time.Sleep(time.Duration(ms) * time.Millisecond)
 	return expr.Empty{}, nil
 }
+
+func builtinWait(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// WAIT ms expression-name
+	// Synchronous counterpart to TIMER: blocks for ms, then executes the
+	// expression and returns its result inline.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+	name := args[1]
+
+	// Verify expression exists
+	e.autoLoad(name)
+	if e.namespace.Get(name).IsEmpty() {
+		return expr.Empty{}, nil
+	}
+
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return e.execute(name, "")
+}
+
+// waitForInterval is how often WAIT_FOR polls the store. Tests shorten it.
+var waitForInterval = 100 * time.Millisecond
+
+func builtinWaitFor(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// WAIT_FOR key timeout-ms
+	// Polls the store until key has a value, e.g. one PERSISTed by another
+	// process sharing the database. The value is loaded as LOAD would and
+	// returned; TIMEOUT if it doesn't appear in time.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || e.store == nil {
+		return expr.Empty{}, nil
+	}
+
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+	name := args[0]
+	deadline := time.Now().Add(time.Duration(ms) * time.Millisecond)
+
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	for {
+		val, err := e.store.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if val != nil && !val.IsEmpty() {
+			if err := e.loadValue(name, val); err != nil {
+				return nil, err
+			}
+			loaded := e.namespace.Get(name)
+			if b, ok := loaded.(expr.Blob); ok {
+				return b, nil
+			}
+			return expr.NewText(strings.TrimSpace(loaded.String())), nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return expr.Stored{Body: "TIMEOUT"}, nil
+		}
+		time.Sleep(min(waitForInterval, remaining))
+	}
+}
@@ -5,9 +5,17 @@ package eval
 
 import (
 	"bytes"
+	"cmp"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/coder/hnsw"
 	"nickandperla.net/losp/internal/expr"
@@ -19,12 +27,12 @@ func builtinCorpus(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(args) < 1 {
-		return expr.Empty{}, nil
+	name := ""
+	if len(args) > 0 {
+		name = strings.TrimSpace(args[0])
 	}
-	name := strings.TrimSpace(args[0])
 	if name == "" {
-		return expr.Empty{}, nil
+		return expr.Error{Code: "INVALID", Message: "CORPUS needs a corpus name"}, nil
 	}
 
 	// Try to load from database if not already in registry
@@ -34,42 +42,10 @@ func builtinCorpus(e *Evaluator, argsRaw string) (expr.Expr, error) {
 			return nil, err
 		}
 		if exists && e.corpusRegistry.GetByName(name) == nil {
-			// Load from DB
-			c := &Corpus{
-				name:       name,
-				embeddings: make(map[string][]float32),
-			}
-			members, err := cs.GetCorpusMembers(name)
-			if err != nil {
-				return nil, err
-			}
-			c.members = members
-
-			// Load embeddings
-			embs, err := cs.GetEmbeddings(name)
-			if err != nil {
-				return nil, err
-			}
-			if embs != nil {
-				c.embeddings = embs
-			}
-
-			// Load HNSW index
-			indexData, err := cs.GetVectorIndex(name)
+			c, err := loadCorpus(cs, name)
 			if err != nil {
 				return nil, err
 			}
-			if indexData != nil {
-				g := hnsw.NewGraph[string]()
-				if err := g.Import(bytes.NewReader(indexData)); err == nil {
-					c.hnswGraph = g
-					c.vecReady = true
-				}
-			}
-
-			// Check if FTS table exists by trying a search
-			c.ftsReady = ftsTableExists(cs, name)
-
 			e.corpusRegistry.SetCorpus(name, c)
 		} else if !exists {
 			// Create in DB
@@ -83,13 +59,18 @@ func builtinCorpus(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: handleID}, nil
 }
 
+// corpusNotFound is the error for a handle that names no corpus.
+func corpusNotFound(handleID string) expr.Error {
+	return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("no corpus for handle %q", handleID)}
+}
+
 func builtinAdd(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
 		return nil, err
 	}
 	if len(args) < 2 {
-		return expr.Empty{}, nil
+		return expr.Error{Code: "INVALID", Message: "ADD needs a corpus handle and an expression name"}, nil
 	}
 
 	handleID := strings.TrimSpace(args[0])
@@ -97,7 +78,7 @@ func builtinAdd(e *Evaluator, argsRaw string) (expr.Expr, error) {
 
 	c := e.corpusRegistry.Get(handleID)
 	if c == nil {
-		return expr.Empty{}, nil
+		return corpusNotFound(handleID), nil
 	}
 
 	c.AddMember(exprName)
@@ -108,6 +89,15 @@ func builtinAdd(e *Evaluator, argsRaw string) (expr.Expr, error) {
 		}
 	}
 
+	if e.GetSetting("AUTO_EMBED", "FALSE") == "TRUE" {
+		if e.embeddingProvider == nil {
+			return expr.Error{Code: "NO_PROVIDER", Message: "AUTO_EMBED needs an embedding provider"}, nil
+		}
+		if err := e.autoEmbed(c, exprName); err != nil {
+			return nil, err
+		}
+	}
+
 	return expr.Empty{}, nil
 }
 
@@ -148,37 +138,77 @@ func builtinIndex(e *Evaluator, argsRaw string) (expr.Expr, error) {
 }
 
 func builtinSearch(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	results, early, err := searchResults(e, argsRaw)
+	if results == nil {
+		return early, err
+	}
+	return collectResults(results)
+}
+
+// searchResults runs SEARCH's query and returns the matching names as they
+// are read from the store. When the query can't run, the iterator is nil
+// and the expression is what SEARCH returns instead.
+func searchResults(e *Evaluator, argsRaw string) (iter.Seq2[string, error], expr.Expr, error) {
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(args) < 2 {
-		return expr.Empty{}, nil
+		return nil, expr.Error{Code: "INVALID", Message: "SEARCH needs a corpus handle and a query"}, nil
 	}
 
 	handleID := strings.TrimSpace(args[0])
 	query := strings.TrimSpace(args[1])
 
 	c := e.corpusRegistry.Get(handleID)
-	if c == nil || !c.ftsReady {
-		return expr.Empty{}, nil
+	if c == nil {
+		return nil, corpusNotFound(handleID), nil
 	}
 
 	cs := corpusStore(e)
 	if cs == nil {
-		return expr.Empty{}, nil
+		return nil, expr.Error{Code: "NO_STORE", Message: "SEARCH needs a store"}, nil
+	}
+	if !c.ftsReady {
+		return nil, expr.Error{Code: "NOT_INDEXED", Message: fmt.Sprintf("corpus %q has not been INDEXed", c.name)}, nil
 	}
 
 	limit := searchLimit(e)
+	if ss, ok := cs.(store.SearchStreamer); ok {
+		return ss.SearchFTSSeq(c.name, query, limit), nil, nil
+	}
 	results, err := cs.SearchFTS(c.name, query, limit)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return sliceResults(results), nil, nil
+}
 
-	if len(results) == 0 {
+// sliceResults is an iterator over results that are already in memory.
+func sliceResults(names []string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for _, name := range names {
+			if !yield(name, nil) {
+				return
+			}
+		}
+	}
+}
+
+// collectResults reads a search iterator to the end, returning the names
+// one per line as SEARCH and SIMILAR do.
+func collectResults(results iter.Seq2[string, error]) (expr.Expr, error) {
+	var names []string
+	for name, err := range results {
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
 		return expr.Empty{}, nil
 	}
-	return expr.Stored{Body: strings.Join(results, "\n")}, nil
+	return expr.Stored{Body: strings.Join(names, "\n")}, nil
 }
 
 func builtinEmbed(e *Evaluator, argsRaw string) (expr.Expr, error) {
@@ -199,68 +229,219 @@ func builtinEmbed(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	if e.embeddingProvider == nil {
 		return nil, fmt.Errorf("no embedding provider configured")
 	}
-	ep := e.embeddingProvider
 
-	// Collect texts that need embedding
-	var toEmbed []string
-	var toEmbedNames []string
+	if _, err := e.embedMembers(c, c.members); err != nil {
+		return nil, err
+	}
+
+	// Build HNSW graph from all embeddings
+	// Add nodes in name order: map order would make the graph, and so the
+	// order of equally similar results, differ from run to run
+	g := newVectorGraph()
+	for _, name := range slices.Sorted(maps.Keys(c.embeddings)) {
+		g.Add(hnsw.MakeNode(name, c.embeddings[name]))
+	}
+	c.hnswGraph = g
+	c.vecReady = true
+
+	if err := e.persistVectorIndex(c); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinEstimateEmbed(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || args[0] == "" {
+		return expr.Error{Code: "INVALID", Message: "ESTIMATE_EMBED needs a corpus handle"}, nil
+	}
+
+	handleID := args[0]
+	c := e.corpusRegistry.Get(handleID)
+	if c == nil {
+		return corpusNotFound(handleID), nil
+	}
+
+	// Same selection as embedMembers, without calling the provider
+	total := 0
 	for _, member := range c.members {
 		if _, exists := c.embeddings[member]; exists {
 			continue
 		}
-		val := e.namespace.Get(member)
-		toEmbed = append(toEmbed, val.String())
-		toEmbedNames = append(toEmbedNames, member)
+		total += estimateTokens(e.namespace.Get(member).String())
+	}
+	return expr.NewText(strconv.Itoa(total)), nil
+}
+
+// estimateTokens approximates a text's token count at four characters per
+// token, the usual rule of thumb for English with BPE tokenizers.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func builtinRefresh(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || args[0] == "" {
+		return expr.Error{Code: "INVALID", Message: "REFRESH needs a corpus handle"}, nil
 	}
 
-	if len(toEmbed) > 0 {
-		vectors, err := ep.Embed(toEmbed)
+	handleID := args[0]
+	c := e.corpusRegistry.Get(handleID)
+	if c == nil {
+		return corpusNotFound(handleID), nil
+	}
+
+	// The named member, or every member changed since it was indexed
+	var members []string
+	if len(args) > 1 && args[1] != "" {
+		if !c.hasMember(args[1]) {
+			return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s is not a member of corpus %q", args[1], c.name)}, nil
+		}
+		members = []string{args[1]}
+	} else {
+		for _, member := range c.members {
+			if c.stale[member] {
+				members = append(members, member)
+			}
+		}
+	}
+	if len(members) == 0 {
+		return expr.Empty{}, nil
+	}
+
+	if cs := corpusStore(e); cs != nil && c.ftsReady {
+		for _, member := range members {
+			if err := cs.UpdateFTSContent(c.name, member, e.namespace.Get(member).String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Only members that were embedded are embedded again
+	var reembed []string
+	for _, member := range members {
+		if _, ok := c.embeddings[member]; ok {
+			reembed = append(reembed, member)
+		}
+	}
+	if len(reembed) > 0 {
+		if e.embeddingProvider == nil {
+			return expr.Error{Code: "NO_PROVIDER", Message: "REFRESH needs an embedding provider to re-embed"}, nil
+		}
+		for _, member := range reembed {
+			delete(c.embeddings, member)
+		}
+		embedded, err := e.embedMembers(c, reembed)
 		if err != nil {
 			return nil, err
 		}
-
-		cs := corpusStore(e)
-		for i, name := range toEmbedNames {
-			if i < len(vectors) {
-				c.embeddings[name] = vectors[i]
-				if cs != nil {
-					if err := cs.StoreEmbedding(c.name, name, vectors[i]); err != nil {
-						return nil, err
-					}
-				}
+		if c.hnswGraph != nil && len(embedded) > 0 {
+			for _, member := range embedded {
+				c.hnswGraph.Delete(member)
+				c.hnswGraph.Add(hnsw.MakeNode(member, c.embeddings[member]))
+			}
+			if err := e.persistVectorIndex(c); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	// Build HNSW graph from all embeddings
-	g := hnsw.NewGraph[string]()
-	for name, vec := range c.embeddings {
-		g.Add(hnsw.MakeNode(name, vec))
+	for _, member := range members {
+		delete(c.stale, member)
 	}
-	c.hnswGraph = g
+	return expr.Stored{Body: strings.Join(members, "\n")}, nil
+}
+
+// autoEmbed embeds a member just added to c and inserts it into the
+// existing vector index, for SYSTEM AUTO_EMBED.
+func (e *Evaluator) autoEmbed(c *Corpus, member string) error {
+	embedded, err := e.embedMembers(c, []string{member})
+	if err != nil || len(embedded) == 0 {
+		return err
+	}
+
+	if c.hnswGraph == nil {
+		c.hnswGraph = newVectorGraph()
+	}
+	c.hnswGraph.Add(hnsw.MakeNode(member, c.embeddings[member]))
 	c.vecReady = true
+	return e.persistVectorIndex(c)
+}
 
-	// Serialize and persist
-	if cs := corpusStore(e); cs != nil {
-		var buf bytes.Buffer
-		if err := g.Export(&buf); err != nil {
-			return nil, err
+// embedMembers embeds those of members that have no embedding yet, keeping
+// the vectors on c and in the store. It returns the names it embedded.
+func (e *Evaluator) embedMembers(c *Corpus, members []string) ([]string, error) {
+	var toEmbed []string
+	var toEmbedNames []string
+	for _, member := range members {
+		if _, exists := c.embeddings[member]; exists {
+			continue
 		}
-		if err := cs.StoreVectorIndex(c.name, buf.Bytes()); err != nil {
-			return nil, err
+		val := e.namespace.Get(member)
+		toEmbed = append(toEmbed, val.String())
+		toEmbedNames = append(toEmbedNames, member)
+	}
+	if len(toEmbed) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := e.embed(e.embeddingProvider, toEmbed)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := corpusStore(e)
+	var embedded []string
+	for i, name := range toEmbedNames {
+		if i < len(vectors) {
+			c.embeddings[name] = vectors[i]
+			embedded = append(embedded, name)
+			if cs != nil {
+				if err := cs.StoreEmbedding(c.name, name, vectors[i]); err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
+	return embedded, nil
+}
 
-	return expr.Empty{}, nil
+// persistVectorIndex serializes c's HNSW graph to the store, if any.
+func (e *Evaluator) persistVectorIndex(c *Corpus) error {
+	cs := corpusStore(e)
+	if cs == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := c.hnswGraph.Export(&buf); err != nil {
+		return err
+	}
+	return cs.StoreVectorIndex(c.name, buf.Bytes())
 }
 
 func builtinSimilar(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	results, early, err := similarResults(e, argsRaw)
+	if results == nil {
+		return early, err
+	}
+	return collectResults(results)
+}
+
+// similarResults is searchResults for SIMILAR. The vector index is held in
+// memory, so its results are all found before the first is yielded.
+func similarResults(e *Evaluator, argsRaw string) (iter.Seq2[string, error], expr.Expr, error) {
 	args, err := e.parseArgs(argsRaw)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(args) < 2 {
-		return expr.Empty{}, nil
+		return nil, expr.Empty{}, nil
 	}
 
 	handleID := strings.TrimSpace(args[0])
@@ -268,35 +449,251 @@ func builtinSimilar(e *Evaluator, argsRaw string) (expr.Expr, error) {
 
 	c := e.corpusRegistry.Get(handleID)
 	if c == nil || !c.vecReady || c.hnswGraph == nil {
-		return expr.Empty{}, nil
+		return nil, expr.Empty{}, nil
 	}
 
 	if e.embeddingProvider == nil {
-		return nil, fmt.Errorf("no embedding provider configured")
+		return nil, nil, fmt.Errorf("no embedding provider configured")
 	}
 	ep := e.embeddingProvider
 
 	// Embed the query
-	vectors, err := ep.Embed([]string{query})
+	vectors, err := e.embed(ep, []string{query})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(vectors) == 0 {
-		return expr.Empty{}, nil
+		return nil, expr.Empty{}, nil
 	}
 
 	limit := searchLimit(e)
 	results := c.hnswGraph.Search(vectors[0], limit)
 
-	if len(results) == 0 {
-		return expr.Empty{}, nil
-	}
+	// Break ties between equally distant results by name
+	dist := func(n hnsw.Node[string]) float32 { return c.hnswGraph.Distance(vectors[0], n.Value) }
+	slices.SortStableFunc(results, func(a, b hnsw.Node[string]) int {
+		return cmp.Or(cmp.Compare(dist(a), dist(b)), strings.Compare(a.Key, b.Key))
+	})
 
 	var names []string
 	for _, r := range results {
 		names = append(names, r.Key)
 	}
-	return expr.Stored{Body: strings.Join(names, "\n")}, nil
+	return sliceResults(names), nil, nil
+}
+
+func builtinSemanticEq(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// SEMANTIC_EQ a b threshold
+	// TRUE if the cosine similarity of the embeddings of a and b exceeds threshold.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return expr.Empty{}, nil
+	}
+
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+
+	if e.embeddingProvider == nil {
+		return expr.Stored{Body: "NO_EMBEDDINGS"}, nil
+	}
+
+	vectors, err := e.embed(e.embeddingProvider, []string{
+		strings.TrimSpace(args[0]),
+		strings.TrimSpace(args[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	if cosineSimilarity(vectors[0], vectors[1]) > threshold {
+		return expr.Stored{Body: "TRUE"}, nil
+	}
+	return expr.Stored{Body: "FALSE"}, nil
+}
+
+func builtinEmbedText(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// EMBEDTEXT text
+	// Returns the embedding of text as base64 of its little-endian float32s,
+	// the encoding corpus embeddings are stored in, for VECSIM.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.Join(args, "\n")
+	if text == "" {
+		return expr.Empty{}, nil
+	}
+	if e.embeddingProvider == nil {
+		return expr.Error{Code: "NO_PROVIDER", Message: "EMBEDTEXT needs an embedding provider"}, nil
+	}
+
+	vectors, err := e.embed(e.embeddingProvider, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		return expr.Empty{}, nil
+	}
+	return expr.NewText(base64.StdEncoding.EncodeToString(store.Float32sToBytes(vectors[0]))), nil
+}
+
+func builtinVecSim(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// VECSIM a b
+	// Returns the cosine similarity of two EMBEDTEXT vectors, rounded to
+	// three decimals.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Error{Code: "INVALID", Message: "VECSIM needs two vectors"}, nil
+	}
+
+	var vectors [2][]float32
+	for i, arg := range args[:2] {
+		data, err := base64.StdEncoding.DecodeString(arg)
+		if err != nil || len(data) == 0 || len(data)%4 != 0 {
+			return expr.Error{Code: "INVALID", Message: fmt.Sprintf("VECSIM argument %d is not an EMBEDTEXT vector", i+1)}, nil
+		}
+		vectors[i] = store.BytesToFloat32s(data)
+	}
+	if len(vectors[0]) != len(vectors[1]) {
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("VECSIM vectors differ in length: %d vs %d", len(vectors[0]), len(vectors[1]))}, nil
+	}
+
+	sim := cosineSimilarity(vectors[0], vectors[1])
+	return expr.NewText(strconv.FormatFloat(math.Round(sim*1000)/1000, 'f', -1, 64)), nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if
+// either vector is zero or their lengths differ.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// ExportCorpus writes the named corpus to w as a portable bundle holding its
+// members and their definitions, FTS content, embeddings and HNSW index.
+func (e *Evaluator) ExportCorpus(name string, w io.Writer) error {
+	c := e.corpusRegistry.GetByName(name)
+	if c == nil {
+		cs := corpusStore(e)
+		if cs == nil {
+			return fmt.Errorf("corpus %q not found", name)
+		}
+		exists, err := cs.CorpusExists(name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("corpus %q not found", name)
+		}
+		if c, err = loadCorpus(cs, name); err != nil {
+			return err
+		}
+	}
+
+	b := &store.CorpusBundle{
+		Name:        c.name,
+		Members:     c.members,
+		Definitions: make(map[string]string),
+		Embeddings:  c.embeddings,
+	}
+	if c.ftsReady {
+		b.Content = make(map[string]string)
+	}
+	for _, member := range c.members {
+		e.autoLoad(member)
+		val := e.namespace.Get(member)
+		if !val.IsEmpty() {
+			b.Definitions[member] = formatAsDefinition(member, val)
+		}
+		if b.Content != nil {
+			b.Content[member] = val.String()
+		}
+	}
+	if c.hnswGraph != nil {
+		var buf bytes.Buffer
+		if err := c.hnswGraph.Export(&buf); err != nil {
+			return err
+		}
+		b.VectorIndex = buf.Bytes()
+	}
+
+	return b.Encode(w)
+}
+
+// ImportCorpus reads a bundle written by ExportCorpus, defining its members
+// and registering the corpus (and restoring it in the store, if one is
+// configured). It returns the corpus name.
+func (e *Evaluator) ImportCorpus(r io.Reader) (string, error) {
+	b, err := store.DecodeCorpusBundle(r)
+	if err != nil {
+		return "", err
+	}
+
+	for _, member := range b.Members {
+		def, ok := b.Definitions[member]
+		if !ok {
+			continue
+		}
+		if _, err := e.Eval(def); err != nil {
+			return "", fmt.Errorf("corpus %q member %s: %w", b.Name, member, err)
+		}
+		// PersistAlways has already queued the write; on-demand stores get
+		// the definition as PERSIST would write it.
+		if e.store != nil && e.persistMode == PersistOnDemand {
+			if err := e.store.Put(member, expr.Stored{Body: def}); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if cs := corpusStore(e); cs != nil {
+		if err := store.RestoreCorpus(cs, b); err != nil {
+			return "", err
+		}
+	}
+
+	c := &Corpus{
+		name:       b.Name,
+		members:    b.Members,
+		embeddings: b.Embeddings,
+		ftsReady:   b.Content != nil && corpusStore(e) != nil,
+	}
+	if c.embeddings == nil {
+		c.embeddings = make(map[string][]float32)
+	}
+	if b.VectorIndex != nil {
+		g := newVectorGraph()
+		if err := g.Import(bytes.NewReader(b.VectorIndex)); err != nil {
+			return "", fmt.Errorf("corpus %q: vector index: %w", b.Name, err)
+		}
+		c.hnswGraph = g
+		c.vecReady = true
+	}
+	e.corpusRegistry.SetCorpus(b.Name, c)
+
+	return b.Name, nil
 }
 
 // corpusStore type-asserts the evaluator's store to CorpusStore.
@@ -308,6 +705,45 @@ func corpusStore(e *Evaluator) store.CorpusStore {
 	return cs
 }
 
+// loadCorpus reads a corpus's members, embeddings and HNSW index from the store.
+func loadCorpus(cs store.CorpusStore, name string) (*Corpus, error) {
+	c := &Corpus{
+		name:       name,
+		embeddings: make(map[string][]float32),
+	}
+	members, err := cs.GetCorpusMembers(name)
+	if err != nil {
+		return nil, err
+	}
+	c.members = members
+
+	// Load embeddings
+	embs, err := cs.GetEmbeddings(name)
+	if err != nil {
+		return nil, err
+	}
+	if embs != nil {
+		c.embeddings = embs
+	}
+
+	// Load HNSW index
+	indexData, err := cs.GetVectorIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if indexData != nil {
+		g := newVectorGraph()
+		if err := g.Import(bytes.NewReader(indexData)); err == nil {
+			c.hnswGraph = g
+			c.vecReady = true
+		}
+	}
+
+	// Check if FTS table exists by trying a search
+	c.ftsReady = ftsTableExists(cs, name)
+	return c, nil
+}
+
 // searchLimit returns the SEARCH_LIMIT setting as an int.
 func searchLimit(e *Evaluator) int {
 	s := e.GetSetting("SEARCH_LIMIT", "10")
@@ -5,6 +5,8 @@ package eval
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"nickandperla.net/losp/internal/expr"
@@ -31,6 +33,11 @@ func builtinHistory(e *Evaluator, argsRaw string) (expr.Expr, error) {
 		return expr.Empty{}, nil
 	}
 
+	// Buffered auto-persist writes must land before versions are listed
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+
 	entries, err := hs.GetHistory(name, e.historyLimit)
 	if err != nil {
 		return nil, err
@@ -65,6 +72,65 @@ func builtinHistory(e *Evaluator, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: strings.Join(names, "\n")}, nil
 }
 
+func builtinFindValue(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// FINDVALUE value
+	// Names currently holding value, one per line in name order. A name in
+	// the namespace counts by its value there; any other, by its latest
+	// version in the store.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	value := strings.TrimSpace(strings.Join(args, "\n"))
+	if value == "" {
+		return expr.Error{Code: "INVALID", Message: "FINDVALUE needs a value"}, nil
+	}
+
+	found := make(map[string]bool)
+	for _, name := range e.namespace.Names() {
+		if holdsValue(e.namespace.Get(name), value) {
+			found[name] = true
+		}
+	}
+	if vf, ok := e.store.(store.ValueFinder); ok {
+		// Buffered auto-persist writes must land before the store is searched
+		if err := e.Flush(); err != nil {
+			return nil, err
+		}
+		names, err := vf.FindValue(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !e.namespace.Has(name) {
+				found[name] = true
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return expr.Empty{}, nil
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return expr.Stored{Body: strings.Join(names, "\n")}, nil
+}
+
+// holdsValue reports whether a namespace value is value, as text or as the
+// body of an expression without parameters.
+func holdsValue(val expr.Expr, value string) bool {
+	switch v := val.(type) {
+	case expr.Stored:
+		return len(v.Params) == 0 && strings.TrimSpace(v.Body) == value
+	case expr.Blob:
+		return false
+	}
+	return strings.TrimSpace(val.String()) == value
+}
+
 // historyStore type-asserts the evaluator's store to HistoryStore.
 func historyStore(e *Evaluator) store.HistoryStore {
 	if e.store == nil {
@@ -73,3 +139,159 @@ func historyStore(e *Evaluator) store.HistoryStore {
 	hs, _ := e.store.(store.HistoryStore)
 	return hs
 }
+
+// tagKey is the metadata key under which TAG records a version label.
+func tagKey(name, tag string) string {
+	return "tag:" + name + ":" + tag
+}
+
+// findVersion returns the stored value of one version of name.
+func findVersion(e *Evaluator, hs store.HistoryStore, name string, version int) (string, bool, error) {
+	// Buffered auto-persist writes must land before versions are listed
+	if err := e.Flush(); err != nil {
+		return "", false, err
+	}
+	entries, err := hs.GetHistory(name, 0)
+	if err != nil {
+		return "", false, err
+	}
+	for _, ve := range entries {
+		if ve.Version == version {
+			return ve.Value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func builtinTag(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// TAG name version tag
+	// Labels a version of name so CHECKOUT can restore it by that label.
+	// The label is stored in the metadata table under "tag:name:tag".
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 || args[0] == "" || args[2] == "" {
+		return expr.Error{Code: "INVALID", Message: "TAG needs a name, a version and a tag"}, nil
+	}
+	name, tag := args[0], args[2]
+	version, err := strconv.Atoi(args[1])
+	if err != nil || version < 1 {
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("%q is not a version number", args[1])}, nil
+	}
+
+	hs := historyStore(e)
+	ms, ok := e.store.(MetadataStore)
+	if hs == nil || !ok {
+		return expr.Error{Code: "NO_STORE", Message: "TAG needs a store with history"}, nil
+	}
+	if _, found, err := findVersion(e, hs, name, version); err != nil {
+		return nil, err
+	} else if !found {
+		return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s has no version %d", name, version)}, nil
+	}
+
+	if err := ms.SetMetadata(tagKey(name, tag), strconv.Itoa(version)); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinCheckout(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// CHECKOUT name tag
+	// Restores the version of name labelled tag as its current value, the
+	// same way executing a HISTORY version expression does.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || args[0] == "" || args[1] == "" {
+		return expr.Error{Code: "INVALID", Message: "CHECKOUT needs a name and a tag"}, nil
+	}
+	name, tag := args[0], args[1]
+
+	hs := historyStore(e)
+	ms, ok := e.store.(MetadataStore)
+	if hs == nil || !ok {
+		return expr.Error{Code: "NO_STORE", Message: "CHECKOUT needs a store with history"}, nil
+	}
+	tagged, err := ms.GetMetadata(tagKey(name, tag))
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(tagged)
+	if err != nil {
+		return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s has no tag %q", name, tag)}, nil
+	}
+	value, found, err := findVersion(e, hs, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return expr.Error{Code: "NOT_FOUND", Message: fmt.Sprintf("%s has no version %d", name, version)}, nil
+	}
+
+	if strings.TrimSpace(value) == "" {
+		e.namespace.Set(name, expr.Empty{})
+		return expr.Empty{}, nil
+	}
+	if err := e.loadValue(name, expr.Stored{Body: value}); err != nil {
+		return nil, err
+	}
+	return expr.Empty{}, nil
+}
+
+func builtinAnnotate(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// ANNOTATE name [key [value]]
+	// With three args: records a note for name in the store's metadata,
+	// keyed "name:key". With two: returns that note. With one: lists all
+	// notes for name as "key: value" lines.
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return expr.Empty{}, nil
+	}
+
+	ms, ok := e.store.(MetadataStore)
+	if !ok {
+		return expr.Empty{}, nil
+	}
+	prefix := args[0] + ":"
+
+	switch len(args) {
+	case 1:
+		ml, ok := e.store.(store.MetadataLister)
+		if !ok {
+			return expr.Empty{}, nil
+		}
+		notes, err := ml.ListMetadata(prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(notes))
+		for k := range notes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var lines []string
+		for _, k := range keys {
+			lines = append(lines, strings.TrimPrefix(k, prefix)+": "+notes[k])
+		}
+		return expr.NewText(strings.Join(lines, "\n")), nil
+
+	case 2:
+		value, err := ms.GetMetadata(prefix + args[1])
+		if err != nil {
+			return nil, err
+		}
+		return expr.NewText(value), nil
+
+	default:
+		if err := ms.SetMetadata(prefix+args[1], args[2]); err != nil {
+			return nil, err
+		}
+		return expr.Empty{}, nil
+	}
+}
@@ -5,6 +5,7 @@ package eval
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 
@@ -19,6 +20,9 @@ type Corpus struct {
 	embeddings map[string][]float32
 	ftsReady   bool
 	vecReady   bool
+
+	memberSet map[string]bool // members, for lookups (built on first use)
+	stale     map[string]bool // members changed since they were indexed
 }
 
 // CorpusRegistry manages corpus handles across evaluators.
@@ -92,12 +96,38 @@ func (r *CorpusRegistry) SetCorpus(name string, c *Corpus) {
 
 // AddMember adds an expression name to the corpus membership list.
 func (c *Corpus) AddMember(name string) {
-	for _, m := range c.members {
-		if m == name {
-			return // already a member
-		}
+	if c.hasMember(name) {
+		return
 	}
 	c.members = append(c.members, name)
+	c.memberSet[name] = true
+}
+
+// hasMember reports whether name is a member of the corpus.
+func (c *Corpus) hasMember(name string) bool {
+	if c.memberSet == nil {
+		c.memberSet = make(map[string]bool, len(c.members))
+		for _, m := range c.members {
+			c.memberSet[m] = true
+		}
+	}
+	return c.memberSet[name]
+}
+
+// MarkChanged records that the expression name was redefined, so every
+// corpus it belongs to holds stale index content for it until REFRESH.
+func (r *CorpusRegistry) MarkChanged(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.corpora {
+		if !c.hasMember(name) {
+			continue
+		}
+		if c.stale == nil {
+			c.stale = make(map[string]bool)
+		}
+		c.stale[name] = true
+	}
 }
 
 // Members returns the corpus member list.
@@ -150,3 +180,11 @@ func (c *Corpus) SetHNSWGraph(g *hnsw.Graph[string]) {
 func (c *Corpus) HNSWGraph() *hnsw.Graph[string] {
 	return c.hnswGraph
 }
+
+// newVectorGraph returns an empty HNSW graph with a fixed level seed, so the
+// same embeddings added in the same order always build the same graph.
+func newVectorGraph() *hnsw.Graph[string] {
+	g := hnsw.NewGraph[string]()
+	g.Rng = rand.New(rand.NewSource(1))
+	return g
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import "errors"
+
+// ErrorKind classifies an EvalError.
+type ErrorKind int
+
+const (
+	// KindRuntime is any failure while executing: a builtin, provider or
+	// store error. These carry no source position.
+	KindRuntime ErrorKind = iota
+	// KindUnterminated is an operator whose ◆ was never found.
+	KindUnterminated
+	// KindInvalidName is a stored or placeholder name with characters the
+	// scanner can't read back, or, under ShadowError, a builtin's name.
+	KindInvalidName
+	// KindOutputLimit is a result that grew past the MAX_OUTPUT setting,
+	// usually from a runaway loop.
+	KindOutputLimit
+	// KindDepthLimit is evaluation nested deeper than the MAX_DEPTH
+	// setting, usually from runaway recursion.
+	KindDepthLimit
+)
+
+// String returns the kind name.
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUnterminated:
+		return "UNTERMINATED"
+	case KindInvalidName:
+		return "INVALID_NAME"
+	case KindOutputLimit:
+		return "OUTPUT_LIMIT"
+	case KindDepthLimit:
+		return "DEPTH_LIMIT"
+	default:
+		return "RUNTIME"
+	}
+}
+
+// EvalError is the error type returned by Eval, EvalReader and LoadReader.
+// Use errors.As to reach it; Error() keeps the plain message form.
+type EvalError struct {
+	Line    int // 1-based source line, or 0 if unknown
+	Column  int // 1-based column in runes, or 0 if unknown
+	Kind    ErrorKind
+	Message string
+	Err     error // Underlying error, if any
+}
+
+func (e *EvalError) Error() string {
+	switch {
+	case e.Message == "" && e.Err != nil:
+		return e.Err.Error()
+	case e.Err != nil:
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *EvalError) Unwrap() error { return e.Err }
+
+// asEvalError returns err unchanged if it already carries an EvalError,
+// otherwise wraps it as a KindRuntime error with no position.
+func asEvalError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ee *EvalError
+	if errors.As(err, &ee) {
+		return err
+	}
+	return &EvalError{Kind: KindRuntime, Err: err}
+}
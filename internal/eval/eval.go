@@ -4,13 +4,22 @@
 package eval
 
 import (
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"nickandperla.net/losp/internal/expr"
 	"nickandperla.net/losp/internal/provider"
 	"nickandperla.net/losp/internal/scanner"
+	"nickandperla.net/losp/internal/store"
 	"nickandperla.net/losp/internal/token"
 )
 
@@ -29,6 +38,14 @@ type MetadataStore interface {
 	SetMetadata(key, value string) error
 }
 
+// DefaultMaxOutput is the MAX_OUTPUT an evaluator starts with: large enough
+// for any real program, small enough to stop a runaway loop.
+const DefaultMaxOutput = 64 << 20
+
+// DefaultMaxDepth is the MAX_DEPTH an evaluator starts with: far deeper
+// than any real program nests, well short of overflowing the Go stack.
+const DefaultMaxDepth = 10000
+
 // PersistMode controls when expressions are persisted.
 type PersistMode int
 
@@ -69,6 +86,49 @@ func ParsePersistMode(s string) (PersistMode, bool) {
 	}
 }
 
+// ShadowMode decides what happens when a user expression is given the name
+// of a builtin.
+type ShadowMode int
+
+const (
+	// ShadowBuiltinWins is the default - the definition is allowed, but ▶
+	// still runs the builtin.
+	ShadowBuiltinWins ShadowMode = iota
+	// ShadowUserWins allows the definition, and ▶ runs it instead of the
+	// builtin.
+	ShadowUserWins
+	// ShadowError makes defining a builtin's name a KindInvalidName error.
+	ShadowError
+)
+
+// String returns the string representation of a ShadowMode.
+func (m ShadowMode) String() string {
+	switch m {
+	case ShadowBuiltinWins:
+		return "BUILTIN_WINS"
+	case ShadowUserWins:
+		return "USER_WINS"
+	case ShadowError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseShadowMode parses a string into a ShadowMode.
+func ParseShadowMode(s string) (ShadowMode, bool) {
+	switch strings.ToUpper(s) {
+	case "BUILTIN_WINS":
+		return ShadowBuiltinWins, true
+	case "USER_WINS":
+		return ShadowUserWins, true
+	case "ERROR":
+		return ShadowError, true
+	default:
+		return ShadowBuiltinWins, false
+	}
+}
+
 // Provider is the interface for LLM providers.
 type Provider interface {
 	Prompt(system, user string) (string, error)
@@ -89,6 +149,10 @@ type ProviderFactory func(streamCb StreamCallback) Provider
 // StreamCallback is called with streaming LLM output.
 type StreamCallback func(token string)
 
+// PromptLogger receives the full system and user prompts of calls that
+// SYSTEM DRY_RUN kept from reaching the provider.
+type PromptLogger func(system, user string)
+
 // InputReader reads user input.
 type InputReader func(prompt string) (string, error)
 
@@ -102,18 +166,50 @@ type Evaluator struct {
 	provider          Provider
 	embeddingProvider provider.EmbeddingProvider // Dedicated embedding provider (Ollama)
 	streamCb          StreamCallback
+	promptLogger      PromptLogger
 	inputReader       InputReader
 	outputWriter      OutputWriter
+	outputVar         string         // Variable capturing SAY output ("" = outputWriter)
+	stdoutWriter      OutputWriter   // Original outputWriter while SAY is redirected
+	logSink           *logSink       // Where LOG writes
 	deferDepth        int            // Tracks ◯ defer operator depth
 	persistMode       PersistMode    // Controls persistence behavior
+	sandbox           SandboxProfile // Restricts callable builtins
+	shadowing         ShadowMode     // Whether user expressions may take builtin names
+	keepImmediate     bool           // Bodies keep immediate operators after they fire
 	loadOnly          bool
 	asyncRegistry     *AsyncRegistry
 	corpusRegistry    *CorpusRegistry
+	promptLatency     *LatencyTracker
+	promptUsage       *UsageCounter
+	promptCache       *PromptCache
+	streamCapture     *streamCapture   // Partial response of the prompt in flight (nil = not captured)
+	clock             func() time.Time // Time source for BENCH and THROTTLE (nil = time.Now)
+	maxOutput         int              // Largest result evalStream may build, in bytes (0 = unlimited)
+	maxDepth          int              // Deepest evalStream may nest (0 = unlimited)
+	depth             int              // Current evalStream nesting
+	depthErr          error            // Set once maxDepth is passed; every level returns it
+	autoFlush         bool             // Top-level results go to outputWriter as they complete
+	ignoredRunes      []rune           // Extra runes the scanner skips
+	homeDir           string           // What EXPAND_PATH expands ~ to ("" = the user's home)
+	envAllowed        []string         // Environment variables EXPAND_PATH may read
+	providerLimit     *ProviderLimiter
 	providerFactories map[string]ProviderFactory
-	settings          map[string]string // Runtime settings (SEARCH_LIMIT, etc.)
-	historyLimit      int               // Limit for HISTORY queries (0 = all)
-	autoLoading       bool              // Guards against recursive autoLoad
-	autoLoadingName   string            // Name currently being auto-loaded (for targeted persist suppression)
+	settings          map[string]string               // Runtime settings (SEARCH_LIMIT, etc.)
+	settingsMu        *sync.RWMutex                   // Guards settings, which async forks share
+	historyLimit      int                             // Limit for HISTORY queries (0 = all)
+	autoLoading       bool                            // Guards against recursive autoLoad
+	autoLoadingName   string                          // Name currently being auto-loaded (for targeted persist suppression)
+	evalDepth         int                             // Nesting depth of Eval calls; pending writes flush at 0
+	pendingPersist    []store.Entry                   // Auto-persist writes buffered until the outermost Eval returns
+	pendingNames      map[string]string               // name -> latest buffered definition
+	pendingDeletes    map[string]bool                 // Names to remove from the store before the buffered writes
+	sandboxNames      map[string]bool                 // Names changed under SandboxSafe, kept out of the store and not reloaded from it
+	persistedHash     map[string]uint64               // name -> hash of the definition last written to/read from the store
+	memoized          map[string]bool                 // Names marked by MEMO
+	macros            map[string]string               // DEFMACRO name -> text the scanner puts in place of ⟦name⟧
+	memoCache         map[string]map[uint64]expr.Expr // name -> args hash -> cached result
+	throttled         map[string]throttleEntry        // Last THROTTLE run per name
 }
 
 // Option configures an Evaluator.
@@ -139,6 +235,75 @@ func WithStreamCallback(cb StreamCallback) Option {
 	return func(e *Evaluator) { e.streamCb = cb }
 }
 
+// WithBuiltinShadowing sets what happens when a user expression is given
+// the name of a builtin. The default is ShadowBuiltinWins.
+func WithBuiltinShadowing(mode ShadowMode) Option {
+	return func(e *Evaluator) { e.shadowing = mode }
+}
+
+// WithClock sets the time source BENCH and THROTTLE use, so tests can
+// control it. By default the real clock is used.
+func WithClock(now func() time.Time) Option {
+	return func(e *Evaluator) { e.clock = now }
+}
+
+// WithMaxOutput caps how large a result may grow, in bytes, before evaluation
+// aborts with a KindOutputLimit error. 0 removes the cap.
+func WithMaxOutput(n int) Option {
+	return func(e *Evaluator) { e.maxOutput = max(n, 0) }
+}
+
+// WithMaxDepth caps how deeply evaluation may nest, through recursive
+// calls or nested operators, before it aborts with a KindDepthLimit error.
+// 0 removes the cap.
+func WithMaxDepth(n int) Option {
+	return func(e *Evaluator) { e.maxDepth = max(n, 0) }
+}
+
+// WithAutoFlush makes the outermost Eval and EvalReader write each
+// top-level result to the output writer, followed by a newline, as soon as
+// it is evaluated, and return an empty result. Streaming UIs then see
+// output statement by statement instead of all at once.
+func WithAutoFlush() Option {
+	return func(e *Evaluator) { e.autoFlush = true }
+}
+
+// WithIgnoredRunes makes the scanner skip the given runes, in addition to
+// the zero-width spaces and byte order marks it always skips.
+func WithIgnoredRunes(runes ...rune) Option {
+	return func(e *Evaluator) { e.ignoredRunes = append(e.ignoredRunes, runes...) }
+}
+
+// WithHomeDir sets the directory EXPAND_PATH expands ~ to, instead of the
+// user's home directory.
+func WithHomeDir(dir string) Option {
+	return func(e *Evaluator) { e.homeDir = dir }
+}
+
+// WithEnvAllowList lets EXPAND_PATH expand the named environment
+// variables. Others are left as written; none are allowed by default.
+func WithEnvAllowList(names ...string) Option {
+	return func(e *Evaluator) { e.envAllowed = append(e.envAllowed, names...) }
+}
+
+// WithEphemeralBodies controls whether immediate operators in a stored body
+// are consumed when they fire (the default). With false, the body keeps them,
+// so a ▽ in a body fires on every execution.
+func WithEphemeralBodies(on bool) Option {
+	return func(e *Evaluator) { e.keepImmediate = !on }
+}
+
+// WithProviderConcurrency limits how many prompt and embedding calls may run
+// at once across the evaluator and its async forks. 0 means unlimited.
+func WithProviderConcurrency(n int) Option {
+	return func(e *Evaluator) { e.providerLimit.SetLimit(n) }
+}
+
+// WithPromptLogger sets the hook that receives prompts in dry-run mode.
+func WithPromptLogger(l PromptLogger) Option {
+	return func(e *Evaluator) { e.promptLogger = l }
+}
+
 // WithInputReader sets the input reader for READ builtin.
 func WithInputReader(r InputReader) Option {
 	return func(e *Evaluator) { e.inputReader = r }
@@ -154,33 +319,85 @@ func WithPersistMode(mode PersistMode) Option {
 	return func(e *Evaluator) { e.persistMode = mode }
 }
 
+// WithProviderRequired makes PROMPT and GENERATE return NO_PROVIDER instead
+// of EMPTY when no provider is configured.
+func WithProviderRequired() Option {
+	return func(e *Evaluator) { e.SetSetting("PROVIDER_REQUIRED", "TRUE") }
+}
+
+// WithSystemPreamble prepends text to the system prompt of every PROMPT,
+// PROMPT_SCHEMA and GENERATE call. An empty preamble changes nothing.
+func WithSystemPreamble(text string) Option {
+	return func(e *Evaluator) { e.SetSetting("PREAMBLE", text) }
+}
+
+// WithRetryOnEmpty sets how many times a provider retries a prompt that came
+// back empty (the RETRY_ON_EMPTY provider parameter). 0 disables retrying.
+func WithRetryOnEmpty(n int) Option {
+	return func(e *Evaluator) { e.SetSetting("RETRY_ON_EMPTY", strconv.Itoa(n)) }
+}
+
 // SetInputReader changes the input reader for READ builtin.
 func (e *Evaluator) SetInputReader(r InputReader) {
 	e.inputReader = r
 }
 
+// SetOutputWriter changes where SAY writes.
+func (e *Evaluator) SetOutputWriter(w OutputWriter) {
+	e.outputWriter = w
+}
+
 // New creates a new Evaluator with the given options.
 func New(opts ...Option) *Evaluator {
 	e := &Evaluator{
 		namespace:         NewNamespace(),
 		asyncRegistry:     NewAsyncRegistry(),
 		corpusRegistry:    NewCorpusRegistry(),
+		promptLatency:     NewLatencyTracker(),
+		promptUsage:       &UsageCounter{},
+		promptCache:       NewPromptCache(),
+		logSink:           &logSink{},
+		providerLimit:     NewProviderLimiter(),
+		maxOutput:         DefaultMaxOutput,
+		maxDepth:          DefaultMaxDepth,
 		providerFactories: make(map[string]ProviderFactory),
 		settings:          make(map[string]string),
+		settingsMu:        &sync.RWMutex{},
 		outputWriter: func(text string) error {
 			fmt.Print(text)
 			return nil
 		},
 	}
+	// Built-in offline provider: echoes the user prompt, or returns
+	// SYSTEM MOCK_RESPONSE when set.
+	e.providerFactories["MOCK"] = func(StreamCallback) Provider {
+		return provider.NewMockHandler(func(system, user string) string {
+			return e.GetSetting("MOCK_RESPONSE", user)
+		})
+	}
+	e.namespace.OnSet(e.nameChanged)
+	e.namespace.OnDelete(e.nameDeleted)
 	for _, opt := range opts {
 		opt(e)
 	}
+	if e.streamCapture != nil {
+		e.streamCapture.install(e.provider, e.namespace, e.streamCb)
+	}
+	// Applied after all options so it doesn't depend on WithProvider's position
+	if n := e.GetSetting("RETRY_ON_EMPTY", ""); n != "" {
+		if cfg, ok := e.provider.(Configurable); ok {
+			cfg.SetParam("RETRY_ON_EMPTY", n)
+		}
+	}
 	return e
 }
 
 // SetProvider sets the LLM provider at runtime.
 func (e *Evaluator) SetProvider(p Provider) {
 	e.provider = p
+	if e.streamCapture != nil {
+		e.streamCapture.install(p, e.namespace, e.streamCb)
+	}
 }
 
 // RegisterProviderFactory registers a factory for creating providers by name.
@@ -199,44 +416,223 @@ func (e *Evaluator) forkForAsync() *Evaluator {
 		embeddingProvider: e.embeddingProvider,
 		asyncRegistry:     e.asyncRegistry,
 		corpusRegistry:    e.corpusRegistry,
+		promptLatency:     e.promptLatency,
+		promptUsage:       e.promptUsage,
+		promptCache:       e.promptCache,
+		logSink:           e.logSink,
+		streamCapture:     e.streamCapture,
+		clock:             e.clock,
+		maxOutput:         e.maxOutput,
+		maxDepth:          e.maxDepth,
+		ignoredRunes:      e.ignoredRunes,
+		homeDir:           e.homeDir,
+		envAllowed:        e.envAllowed,
+		providerLimit:     e.providerLimit,
+		promptLogger:      e.promptLogger,
 		persistMode:       e.persistMode,
+		sandbox:           e.sandbox,
+		shadowing:         e.shadowing,
+		keepImmediate:     e.keepImmediate,
 		providerFactories: e.providerFactories,
 		settings:          e.settings,
+		settingsMu:        e.settingsMu,
 		historyLimit:      e.historyLimit,
+		macros:            maps.Clone(e.macros),
 		// inputReader, outputWriter, streamCb are nil (SAY silenced, READ returns EMPTY)
 	}
 }
 
+// Clone creates an Evaluator that can run on another goroutine alongside
+// this one. An Evaluator must not be used by two goroutines at once; give
+// each its own Clone instead. The clone starts with a copy of the
+// namespace and settings and its own async and corpus registries. It shares
+// the store, the providers, the provider concurrency limit and the I/O
+// hooks. Settings that reconfigure the shared provider, such as MODEL,
+// change it for every clone, and in ALWAYS mode names read through to the
+// shared store. With stream capture on, the clone captures its own prompts
+// in its own _stream_buffer, for providers that take a stream callback per
+// prompt.
+func (e *Evaluator) Clone() *Evaluator {
+	c := e.forkForAsync()
+	c.asyncRegistry = NewAsyncRegistry()
+	c.corpusRegistry = NewCorpusRegistry()
+	e.settingsMu.RLock()
+	c.settings = maps.Clone(e.settings)
+	e.settingsMu.RUnlock()
+	c.settingsMu = &sync.RWMutex{}
+	c.providerFactories = maps.Clone(e.providerFactories)
+	c.memoized = maps.Clone(e.memoized)
+	c.namespace.OnSet(c.nameChanged)
+	c.namespace.OnDelete(c.nameDeleted)
+	c.inputReader = e.inputReader
+	c.outputWriter = e.outputWriter
+	c.streamCb = e.streamCb
+	c.autoFlush = e.autoFlush
+	if e.streamCapture != nil {
+		// Not installed on the shared provider, which would take the stream
+		// from this evaluator; prompt passes it with each call instead
+		c.streamCapture = &streamCapture{ns: c.namespace, forward: c.streamCb}
+	}
+	return c
+}
+
 // Eval evaluates a losp string and returns the result.
 func (e *Evaluator) Eval(input string) (string, error) {
 	return e.EvalReader(strings.NewReader(input))
 }
 
-// EvalReader evaluates losp from a reader.
+// EvalReader evaluates losp from a reader. With WithAutoFlush, the outermost
+// call writes results as it goes instead of returning them.
+// Auto-persisted writes are buffered and flushed when the outermost
+// EvalReader returns.
 func (e *Evaluator) EvalReader(r io.Reader) (string, error) {
-	scan := scanner.New(r)
-	result, err := e.evalStream(scan, false)
+	if e.autoFlush && e.evalDepth == 0 {
+		return "", e.evalFlushing(r)
+	}
+	scan := e.newScanner(r)
+	e.evalDepth++
+	result, err := e.evalStream(scan, false, nil)
+	if ferr := e.endEval(); err == nil {
+		err = ferr
+	}
 	if err != nil {
-		return "", err
+		return "", asEvalError(err)
 	}
 	return strings.TrimSpace(result.String()), nil
 }
 
+// evalFlushing evaluates r, writing each top-level result to outputWriter
+// as soon as it is evaluated. The output reads as EvalReader's result
+// would: whitespace is held back until content follows it, so none leads
+// or trails, and whitespace-only results spanning lines collapse to one
+// newline.
+func (e *Evaluator) evalFlushing(r io.Reader) error {
+	scan := e.newScanner(r)
+	e.evalDepth++
+	started := false
+	tail, sep := "", "" // Trailing whitespace of the last content, whitespace results since
+	_, err := e.evalStream(scan, false, func(result string) error {
+		if strings.TrimSpace(result) == "" {
+			if started {
+				sep += result
+			}
+			return nil
+		}
+		if !started {
+			result = strings.TrimLeftFunc(result, unicode.IsSpace)
+			started = true
+		}
+		if strings.Contains(sep, "\n") {
+			sep = "\n"
+		}
+		content := strings.TrimRightFunc(result, unicode.IsSpace)
+		out := tail + sep + content
+		tail, sep = result[len(content):], ""
+		return e.outputWriter(out)
+	})
+	if ferr := e.endEval(); err == nil {
+		err = ferr
+	}
+	return asEvalError(err)
+}
+
+// EvalEach evaluates losp from a reader as it arrives, calling emit with
+// each top-level result (trimmed, skipping empty ones) as soon as it is
+// evaluated instead of collecting them. Use it for large or unbounded
+// input such as a socket. An error from emit stops evaluation.
+func (e *Evaluator) EvalEach(r io.Reader, emit func(result string) error) error {
+	scan := e.newScanner(r)
+	e.evalDepth++
+	_, err := e.evalStream(scan, false, func(result string) error {
+		if result = strings.TrimSpace(result); result != "" {
+			return emit(result)
+		}
+		return nil
+	})
+	if ferr := e.endEval(); err == nil {
+		err = ferr
+	}
+	return asEvalError(err)
+}
+
 // LoadReader loads definitions from a reader without executing top-level code.
 // Only ▼ (store) operators are processed; ▶ (execute) at top level is ignored.
 func (e *Evaluator) LoadReader(r io.Reader) error {
 	e.loadOnly = true
 	defer func() { e.loadOnly = false }()
-	scan := scanner.New(r)
-	_, err := e.evalStream(scan, false)
-	return err
+	scan := e.newScanner(r)
+	e.evalDepth++
+	_, err := e.evalStream(scan, false, discardResult)
+	if ferr := e.endEval(); err == nil {
+		err = ferr
+	}
+	return asEvalError(err)
+}
+
+// newScanner creates a scanner that also skips the evaluator's ignored runes
+// and expands its macros.
+func (e *Evaluator) newScanner(r io.Reader) *scanner.Scanner {
+	return scanner.New(r).Ignore(e.ignoredRunes...).Macros(e.macro)
+}
+
+// macro returns the text of the macro name, for the scanner.
+func (e *Evaluator) macro(name string) (string, bool) {
+	text, ok := e.macros[name]
+	return text, ok
 }
 
+// endEval closes one level of Eval nesting, flushing buffered writes once
+// the outermost level returns.
+func (e *Evaluator) endEval() error {
+	e.evalDepth--
+	if e.evalDepth > 0 {
+		return nil
+	}
+	e.depthErr = nil
+	return e.Flush()
+}
+
+// discardResult is an evalStream emit function that drops results.
+func discardResult(string) error { return nil }
+
 // evalStream processes the input stream, returning the last non-empty result.
-func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (expr.Expr, error) {
+// With a non-nil emit, each result is passed to emit once evaluated rather
+// than collected, and the returned result is empty.
+func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool, emit func(string) error) (expr.Expr, error) {
+	e.depth++
+	defer func() { e.depth-- }()
+	if e.maxDepth > 0 && e.depth > e.maxDepth && e.depthErr == nil {
+		e.depthErr = e.depthLimitError()
+	}
+
 	var results []expr.Expr
+	size, counted := 0, 0
 
 	for {
+		// Builtins that drop their body's errors would otherwise let the
+		// recursion carry on, so the error stays set until Eval returns
+		if e.depthErr != nil {
+			return nil, e.depthErr
+		}
+		if e.maxOutput > 0 {
+			for _, r := range results[counted:] {
+				size += len(r.String())
+			}
+			counted = len(results)
+			if size > e.maxOutput {
+				return nil, e.outputLimitError()
+			}
+		}
+		if emit != nil && len(results) > 0 {
+			for _, r := range results {
+				if err := emit(r.String()); err != nil {
+					return nil, err
+				}
+			}
+			results = results[:0]
+			size, counted = 0, 0
+		}
+
 		item, err := scan.Next()
 		if err != nil {
 			return nil, err
@@ -244,6 +640,9 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 
 		switch item.Token {
 		case token.EOF:
+			if stopAtTerminator {
+				return nil, errDeferEOF
+			}
 			return e.concatResults(results), nil
 
 		case token.TERMINATOR:
@@ -261,8 +660,11 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 			if e.deferDepth > 0 {
 				// Already inside a ◯: preserve this ◯ as text for later consumption
 				e.deferDepth++
-				result, err := e.evalStream(scan, true)
+				result, err := e.evalStream(scan, true, nil)
 				e.deferDepth--
+				if errors.Is(err, errDeferEOF) {
+					return nil, unterminatedError(scan, "◯ (defer)", item.Line, item.Col, 0)
+				}
 				if err != nil {
 					return nil, err
 				}
@@ -271,8 +673,11 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 			} else {
 				// At top level: ◯ is CONSUMED - only the deferred content is returned
 				e.deferDepth++
-				result, err := e.evalStream(scan, true)
+				result, err := e.evalStream(scan, true, nil)
 				e.deferDepth--
+				if errors.Is(err, errDeferEOF) {
+					return nil, unterminatedError(scan, "◯ (defer)", item.Line, item.Col, 0)
+				}
 				if err != nil {
 					return nil, err
 				}
@@ -284,6 +689,9 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 			if err != nil {
 				return nil, err
 			}
+			if err := e.checkName("□", name, item.Line, item.Col); err != nil {
+				return nil, err
+			}
 			results = append(results, expr.Placeholder{Name: name})
 
 		case token.STORE, token.IMM_STORE:
@@ -292,6 +700,9 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 			if err != nil {
 				return nil, err
 			}
+			if err := e.checkName(item.Value, name, item.Line, item.Col); err != nil {
+				return nil, err
+			}
 
 			if item.Token == token.IMM_STORE {
 				// ▽ - Immediate store: scan body preserving ◯ for Eval to handle
@@ -324,7 +735,7 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 				if err := scan.SkipWhitespace(); err != nil {
 					return nil, err
 				}
-				body, params, err := e.evalBodyForDeferredStore(scan, "▼"+name, item.Line)
+				body, params, err := e.evalBodyForDeferredStore(scan, "▼"+name, item.Line, item.Col)
 				if err != nil {
 					return nil, err
 				}
@@ -350,34 +761,46 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 				// Only immediate operators fire; deferred operators are preserved
 				e.autoLoad(name)
 				val := e.namespace.Get(name)
+				if b, ok := val.(expr.Blob); ok {
+					// Binary content is neither parsed nor consumed
+					results = append(results, b)
+					continue
+				}
+				if text := val.String(); !val.IsEmpty() && !token.ContainsOperator(text) {
+					// Plain text: nothing would fire or be consumed
+					results = append(results, expr.Stored{Body: text})
+					continue
+				}
 				result, err := e.parseBodyImmediateOnly(val.String())
 				if err != nil {
 					return nil, err
 				}
 
 				// Update stored body with parsed result (ephemeral semantic)
-				if s, ok := val.(expr.Stored); ok {
-					e.namespace.Set(name, expr.Stored{Params: s.Params, Body: result})
-				} else {
-					e.namespace.Set(name, expr.Stored{Body: result})
-				}
+				e.consumeBody(name, val, result)
 
 				results = append(results, expr.Stored{Body: result})
 			} else if e.deferDepth == 0 {
 				// △ - IMMEDIATE retrieve at parse time: only immediate ops fire
 				e.autoLoad(name)
 				val := e.namespace.Get(name)
+				if b, ok := val.(expr.Blob); ok {
+					// Binary content is neither parsed nor consumed
+					results = append(results, b)
+					continue
+				}
+				if text := val.String(); !val.IsEmpty() && !token.ContainsOperator(text) {
+					// Plain text: nothing would fire or be consumed
+					results = append(results, expr.Stored{Body: text})
+					continue
+				}
 				result, err := e.parseBodyImmediateOnly(val.String())
 				if err != nil {
 					return nil, err
 				}
 
 				// Update stored body - any immediate ops that fired are now replaced
-				if s, ok := val.(expr.Stored); ok {
-					e.namespace.Set(name, expr.Stored{Params: s.Params, Body: result})
-				} else {
-					e.namespace.Set(name, expr.Stored{Body: result})
-				}
+				e.consumeBody(name, val, result)
 
 				results = append(results, expr.Stored{Body: result})
 			} else {
@@ -428,7 +851,7 @@ func (e *Evaluator) evalStream(scan *scanner.Scanner, stopAtTerminator bool) (ex
 // parseBody parses a body string for immediate store (▽), extracting placeholders.
 // All operators are preserved as text since the body will be evaluated immediately.
 func (e *Evaluator) parseBody(body string) (expr.Expr, []string, error) {
-	scan := scanner.NewFromString(body)
+	scan := e.newScanner(strings.NewReader(body))
 	var exprs []expr.Expr
 	var params []string
 
@@ -481,14 +904,52 @@ func (e *Evaluator) parseBody(body string) (expr.Expr, []string, error) {
 	return expr.NewCompound(exprs...), params, nil
 }
 
+// errDeferEOF signals that a ◯ block reached EOF before its own terminator.
+var errDeferEOF = errors.New("unterminated ◯ (defer)")
+
+// unterminatedError reports an operator body that reached EOF. Because ◯
+// needs its own ◆, a missing one silently consumes the enclosing operator's
+// terminator instead; deferLine (the last ◯ closed in the body, or 0) lets
+// the message point at the likely culprit.
+func unterminatedError(scan *scanner.Scanner, opName string, startLine, startCol, deferLine int) error {
+	msg := fmt.Sprintf("unexpected EOF at line %d: unterminated %s starting at line %d", scan.Line(), opName, startLine)
+	switch {
+	case opName == "◯ (defer)":
+		msg += " (◯ needs its own ◆, separate from the enclosing operator's)"
+	case deferLine > 0:
+		msg += fmt.Sprintf(" (the ◯ (defer) at line %d may have consumed this ◆ — ◯ needs its own ◆)", deferLine)
+	}
+	return &EvalError{Line: startLine, Column: startCol, Kind: KindUnterminated, Message: msg}
+}
+
+// checkName rejects a name that opName (▼, ▽ or □) can't define: one with
+// characters outside letters, digits and _ (reachable through dynamic
+// naming), or, under ShadowError, a builtin's name. An empty dynamic name
+// is allowed and stores under "".
+func (e *Evaluator) checkName(opName, name string, line, col int) error {
+	var msg string
+	switch {
+	case name == "":
+		return nil
+	case !scanner.IsName(name):
+		msg = fmt.Sprintf("invalid name %q after %s at line %d: only letters, digits and _ are allowed", name, opName, line)
+	case e.shadowing == ShadowError && getBuiltin(name) != nil:
+		msg = fmt.Sprintf("name %s after %s at line %d is reserved for the builtin", name, opName, line)
+	default:
+		return nil
+	}
+	return &EvalError{Line: line, Column: col, Kind: KindInvalidName, Message: msg}
+}
+
 // evalBodyForDeferredStore processes the body of a ▼ (deferred store) operation.
 // CRITICAL: Immediate operators (△, ▷, ▽) are evaluated immediately as they are encountered.
 // Deferred operators (▲, ▶, ▼) are preserved as text for later execution.
 // This implements the core losp semantic: immediate operators ALWAYS evaluate immediately.
-// The opName and startLine parameters are used for error reporting.
-func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName string, startLine int) (string, []string, error) {
+// The opName, startLine and startCol parameters are used for error reporting.
+func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName string, startLine, startCol int) (string, []string, error) {
 	var parts []string
 	var params []string
+	deferLine := 0 // line of the last ◯ block closed in this body, for error hints
 
 	for {
 		item, err := scan.Next()
@@ -498,7 +959,7 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 
 		switch item.Token {
 		case token.EOF:
-			return "", nil, fmt.Errorf("unexpected EOF at line %d: unterminated %s starting at line %d", scan.Line(), opName, startLine)
+			return "", nil, unterminatedError(scan, opName, startLine, startCol, deferLine)
 
 		case token.TERMINATOR:
 			return strings.Join(parts, ""), params, nil
@@ -511,6 +972,9 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 			if err != nil {
 				return "", nil, err
 			}
+			if err := e.checkName("□", name, item.Line, item.Col); err != nil {
+				return "", nil, err
+			}
 			params = append(params, name)
 			// Skip separator whitespace after placeholder name
 			if err := scan.SkipWhitespace(); err != nil {
@@ -522,21 +986,23 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 			if e.deferDepth == 0 {
 				// At top level: ◯ is CONSUMED - only the deferred content is stored
 				e.deferDepth++
-				deferredPart, deferredParams, err := e.evalBodyForDeferredStore(scan, "◯ (defer)", item.Line)
+				deferredPart, deferredParams, err := e.evalBodyForDeferredStore(scan, "◯ (defer)", item.Line, item.Col)
 				e.deferDepth--
 				if err != nil {
 					return "", nil, fmt.Errorf("in %s starting at line %d: %w", opName, startLine, err)
 				}
+				deferLine = item.Line
 				params = append(params, deferredParams...)
 				parts = append(parts, deferredPart)
 			} else {
 				// Already inside a ◯: preserve this ◯ as text for later consumption
 				e.deferDepth++
-				deferredPart, deferredParams, err := e.evalBodyForDeferredStore(scan, "◯ (defer)", item.Line)
+				deferredPart, deferredParams, err := e.evalBodyForDeferredStore(scan, "◯ (defer)", item.Line, item.Col)
 				e.deferDepth--
 				if err != nil {
 					return "", nil, fmt.Errorf("in %s starting at line %d: %w", opName, startLine, err)
 				}
+				deferLine = item.Line
 				params = append(params, deferredParams...)
 				// Preserve ◯ and its terminator
 				parts = append(parts, string(token.RuneDefer)+deferredPart+string(token.RuneTerminator))
@@ -618,7 +1084,7 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 				return "", nil, err
 			}
 			// Recursively process args — fires immediate operators, preserves deferred ones
-			execBody, execParams, err := e.evalBodyForDeferredStore(scan, "▶"+nameText, item.Line)
+			execBody, execParams, err := e.evalBodyForDeferredStore(scan, "▶"+nameText, item.Line, item.Col)
 			if err != nil {
 				return "", nil, fmt.Errorf("in %s starting at line %d: %w", opName, startLine, err)
 			}
@@ -633,6 +1099,9 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 				if err != nil {
 					return "", nil, err
 				}
+				if err := e.checkName("▽", name, item.Line, item.Col); err != nil {
+					return "", nil, err
+				}
 				body, err := scan.ScanUntilTerminator()
 				if err != nil {
 					return "", nil, err
@@ -665,7 +1134,7 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 			// ▼ - deferred store (nested), preserve as text including dynamic name
 			// Dynamic naming (▼▲name value ◆) should be resolved at execution time,
 			// not at definition time, so we preserve the ▲ operator.
-			nestedLine := item.Line
+			nestedLine, nestedCol := item.Line, item.Col
 			nameText, err := e.scanNamePreservingOperators(scan)
 			if err != nil {
 				return "", nil, err
@@ -674,7 +1143,7 @@ func (e *Evaluator) evalBodyForDeferredStore(scan *scanner.Scanner, opName strin
 			if err := scan.SkipWhitespace(); err != nil {
 				return "", nil, err
 			}
-			nestedBody, nestedParams, err := e.evalBodyForDeferredStore(scan, "▼"+nameText, nestedLine)
+			nestedBody, nestedParams, err := e.evalBodyForDeferredStore(scan, "▼"+nameText, nestedLine, nestedCol)
 			if err != nil {
 				return "", nil, fmt.Errorf("in %s starting at line %d: %w", opName, startLine, err)
 			}
@@ -702,7 +1171,7 @@ func (e *Evaluator) scanBodyPreservingDefer(scan *scanner.Scanner) (string, erro
 
 		switch item.Token {
 		case token.EOF:
-			return "", fmt.Errorf("unexpected EOF while scanning body")
+			return "", &EvalError{Line: item.Line, Column: item.Col, Kind: KindUnterminated, Message: "unexpected EOF while scanning body"}
 
 		case token.TERMINATOR:
 			if depth == 0 {
@@ -741,7 +1210,7 @@ func (e *Evaluator) scanBodyWithNestedTerminators(scan *scanner.Scanner) (string
 
 		switch item.Token {
 		case token.EOF:
-			return "", fmt.Errorf("unexpected EOF while scanning body")
+			return "", &EvalError{Line: item.Line, Column: item.Col, Kind: KindUnterminated, Message: "unexpected EOF while scanning body"}
 
 		case token.TERMINATOR:
 			if depth == 0 {
@@ -776,8 +1245,12 @@ func (e *Evaluator) scanBodyWithNestedTerminators(scan *scanner.Scanner) (string
 // 3. POPULATE - placeholders are bound to arguments
 // 4. EXECUTE - deferred expressions run
 func (e *Evaluator) execute(name string, argsRaw string) (expr.Expr, error) {
-	// Check for builtin first (exact case match — builtins are ALL CAPS)
-	if builtin := getBuiltin(name); builtin != nil {
+	// Check for builtin first (exact case match — builtins are ALL CAPS),
+	// unless a user expression of the same name takes precedence
+	if builtin := getBuiltin(name); builtin != nil && !e.userShadows(name) {
+		if !e.allowsBuiltin(name) {
+			return forbidden(), nil
+		}
 		return builtin(e, argsRaw)
 	}
 
@@ -789,12 +1262,34 @@ func (e *Evaluator) execute(name string, argsRaw string) (expr.Expr, error) {
 	}
 
 	// Parse arguments (needed for POPULATE phase)
-	args, err := e.parseArgs(argsRaw)
+	args, err := e.parseArgList(argsRaw)
 	if err != nil {
 		return nil, err
 	}
 
+	if e.memoized[name] {
+		return e.executeMemoized(name, stored, args.args, args.literal)
+	}
+	return e.executeStored(name, stored, args.args, args.literal)
+}
+
+// userShadows reports whether ▶name should run a user expression rather
+// than the builtin of that name.
+func (e *Evaluator) userShadows(name string) bool {
+	return e.shadowing == ShadowUserWins && !e.namespace.Get(name).IsEmpty()
+}
+
+// executeStored runs the PARSE, POPULATE, and EXECUTE phases for an already
+// loaded expression, binding args to its placeholders. literal marks the
+// args written as text in the call, which may bind by name; with nil, every
+// arg binds positionally.
+func (e *Evaluator) executeStored(name string, stored expr.Expr, args []string, literal []bool) (expr.Expr, error) {
 	// Extract params and body — all expression types go through the same 4-phase pipeline.
+	if b, ok := stored.(expr.Blob); ok {
+		// Binary content has no operators to run
+		return b, nil
+	}
+
 	var params []string
 	var bodyStr string
 	if s, ok := stored.(expr.Stored); ok {
@@ -810,13 +1305,17 @@ func (e *Evaluator) execute(name string, argsRaw string) (expr.Expr, error) {
 		return nil, err
 	}
 
-	// EPHEMERAL: Update stored body - immediate operators are consumed
-	e.namespace.Set(name, expr.Stored{Params: params, Body: parsedBody})
+	// EPHEMERAL: Update stored body - immediate operators are consumed.
+	// Skipped when nothing was consumed, so memoized results stay valid.
+	if _, ok := stored.(expr.Stored); !ok || parsedBody != bodyStr {
+		e.consumeBody(name, stored, parsedBody)
+	}
 
 	// 3. POPULATE - bind arguments to placeholders
-	for i, param := range params {
-		if i < len(args) {
-			e.namespace.Set(param, expr.Stored{Body: args[i]})
+	bound := bindArgs(params, args, literal)
+	for _, param := range params {
+		if val, ok := bound[param]; ok {
+			e.namespace.Set(param, expr.Stored{Body: val})
 		}
 	}
 
@@ -824,12 +1323,103 @@ func (e *Evaluator) execute(name string, argsRaw string) (expr.Expr, error) {
 	return expr.Stored{Body: mustEval(e, parsedBody)}, nil
 }
 
+// consumeBody replaces name's body with its parsed form once its immediate
+// operators have fired, so they fire only once. With WithEphemeralBodies(false)
+// the body is kept and they fire on every retrieve or execute.
+func (e *Evaluator) consumeBody(name string, val expr.Expr, parsed string) {
+	if e.keepImmediate {
+		return
+	}
+	if s, ok := val.(expr.Stored); ok {
+		e.namespace.Set(name, expr.Stored{Params: s.Params, Body: parsed})
+	} else {
+		e.namespace.Set(name, expr.Stored{Body: parsed})
+	}
+}
+
+// bindArgs maps arguments to placeholder names. A literal argument made up
+// of name=value pairs naming declared placeholders binds by name; the
+// remaining arguments bind positionally to the placeholders not bound by
+// name. Values from operators are never read as pairs, so data that happens
+// to contain name= can't rebind a placeholder.
+func bindArgs(params, args []string, literal []bool) map[string]string {
+	bound := make(map[string]string)
+	if len(params) == 0 {
+		return bound
+	}
+	declared := make(map[string]bool, len(params))
+	for _, p := range params {
+		declared[p] = true
+	}
+
+	var positional []string
+	for i, arg := range args {
+		if i >= len(literal) || !literal[i] {
+			positional = append(positional, arg)
+			continue
+		}
+		named, ok := namedArgs(arg, declared)
+		if !ok {
+			positional = append(positional, arg)
+			continue
+		}
+		for k, v := range named {
+			bound[k] = v
+		}
+	}
+
+	for _, param := range params {
+		if len(positional) == 0 {
+			break
+		}
+		if _, ok := bound[param]; ok {
+			continue
+		}
+		bound[param] = positional[0]
+		positional = positional[1:]
+	}
+	return bound
+}
+
+// namedArgs parses a line of call text as named bindings. Either every
+// whitespace-separated field is a name=value pair ("port=8080 host=localhost"),
+// or the whole argument is a single pair whose value may contain spaces
+// ("title=Hello world"). Only declared placeholder names qualify.
+func namedArgs(arg string, declared map[string]bool) (map[string]string, bool) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	named := make(map[string]string, len(fields))
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || !declared[k] {
+			named = nil
+			break
+		}
+		named[k] = v
+	}
+	if named != nil {
+		return named, true
+	}
+
+	k, v, ok := strings.Cut(strings.TrimSpace(arg), "=")
+	if !ok || !declared[k] {
+		return nil, false
+	}
+	return map[string]string{k: v}, true
+}
+
 // parseBodyImmediateOnly processes a body string, firing immediate operators
 // but preserving deferred operators as text.
 // This implements the PARSE phase per PRIMER.md, where immediate operators
 // fire BEFORE placeholders are bound.
 func (e *Evaluator) parseBodyImmediateOnly(body string) (string, error) {
-	scan := scanner.NewFromString(body)
+	if !token.ContainsOperator(body) {
+		return body, nil
+	}
+	scan := e.newScanner(strings.NewReader(body))
 	var parts []string
 
 	for {
@@ -935,6 +1525,9 @@ func (e *Evaluator) parseBodyImmediateOnly(body string) (string, error) {
 				if err != nil {
 					return "", err
 				}
+				if err := e.checkName("▽", name, item.Line, item.Col); err != nil {
+					return "", err
+				}
 				bodyText, _ := scan.ScanUntilTerminator()
 				evaluated, err := e.Eval(bodyText)
 				if err != nil {
@@ -969,12 +1562,69 @@ func mustEval(e *Evaluator, s string) string {
 	return result
 }
 
+// argList collects builtin arguments for parseArgs and splitArgs. Blank
+// lines are formatting and are skipped, unless SYSTEM KEEP_EMPTY_ARGS is
+// TRUE: then each blank line between two arguments is an empty argument,
+// so an argument can be left out without shifting the ones after it.
+type argList struct {
+	args      []string
+	literal   []bool // Whether each argument is text written in the call
+	keepEmpty bool
+	blanks    int // Blank lines since the last argument
+}
+
+func (e *Evaluator) newArgList() *argList {
+	return &argList{keepEmpty: e.GetSetting("KEEP_EMPTY_ARGS", "FALSE") == "TRUE"}
+}
+
+// add appends an operator's result as an argument, preceded by any blank
+// lines it follows.
+func (l *argList) add(arg string) {
+	l.push(arg, false)
+}
+
+func (l *argList) push(arg string, literal bool) {
+	if l.keepEmpty && len(l.args) > 0 {
+		for range l.blanks {
+			l.args = append(l.args, "")
+			l.literal = append(l.literal, true)
+		}
+	}
+	l.blanks = 0
+	l.args = append(l.args, arg)
+	l.literal = append(l.literal, literal)
+}
+
+// addText splits text into one argument per non-blank line. Only whole
+// lines count as blank: the first and last pieces share their line with
+// the operators around the text.
+func (l *argList) addText(text string) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if s := strings.TrimSpace(line); s != "" {
+			l.push(s, true)
+		} else if i > 0 && i < len(lines)-1 {
+			l.blanks++
+		}
+	}
+}
+
 // parseArgs parses the argument string into individual arguments.
 // Each expression is one argument. Text expressions are separated by newlines.
 // Operators evaluate to single arguments (preserving multi-word content).
 func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
-	scan := scanner.NewFromString(argsRaw)
-	var args []string
+	args, err := e.parseArgList(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	return args.args, nil
+}
+
+// parseArgList is parseArgs, also recording which arguments are literal
+// text of the call rather than operator results.
+func (e *Evaluator) parseArgList(argsRaw string) (*argList, error) {
+	scan := e.newScanner(strings.NewReader(argsRaw))
+	args := e.newArgList()
 
 	for {
 		item, err := scan.Next()
@@ -988,13 +1638,7 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 		switch item.Token {
 		case token.TEXT:
 			// Text is split by newlines - each line is a separate argument
-			// Empty lines are skipped (they're formatting, not arguments)
-			lines := strings.Split(item.Value, "\n")
-			for _, line := range lines {
-				if s := strings.TrimSpace(line); s != "" {
-					args = append(args, s)
-				}
-			}
+			args.addText(item.Value)
 		case token.IMM_RETRIEVE:
 			// Operators always produce an argument, even if empty
 			// Use scanNameOrDynamic to support dynamic naming (e.g., △▲ref)
@@ -1004,7 +1648,7 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 			}
 			e.autoLoad(name)
 			val := e.namespace.Get(name)
-			args = append(args, strings.TrimSpace(val.String()))
+			args.add(strings.TrimSpace(val.String()))
 		case token.IMM_EXECUTE:
 			// Operators always produce an argument, even if empty
 			// Use scanNameOrDynamic to support dynamic naming (e.g., ▷▲ref ◆)
@@ -1018,9 +1662,9 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 				return nil, err
 			}
 			if res != nil {
-				args = append(args, strings.TrimSpace(res.String()))
+				args.add(strings.TrimSpace(res.String()))
 			} else {
-				args = append(args, "")
+				args.add("")
 			}
 		case token.RETRIEVE:
 			// Operators always produce an argument, even if empty
@@ -1029,10 +1673,11 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 			if err != nil {
 				return nil, err
 			}
-			e.autoLoad(name)
-			val := e.namespace.Get(name)
-			result, _ := e.parseBodyImmediateOnly(val.String())
-			args = append(args, strings.TrimSpace(result))
+			result, err := e.peek(name)
+			if err != nil {
+				return nil, err
+			}
+			args.add(strings.TrimSpace(result))
 		case token.EXECUTE:
 			// Operators always produce an argument, even if empty
 			// Use scanNameOrDynamic to support dynamic naming (e.g., ▶▲ref ◆)
@@ -1046,9 +1691,9 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 				return nil, err
 			}
 			if res != nil {
-				args = append(args, strings.TrimSpace(res.String()))
+				args.add(strings.TrimSpace(res.String()))
 			} else {
-				args = append(args, "")
+				args.add("")
 			}
 		}
 	}
@@ -1056,6 +1701,78 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 	return args, nil
 }
 
+// splitArgs splits the argument string the same way parseArgs does, but
+// returns each argument's source text unevaluated. Builtins that must not run
+// every argument (IF) evaluate only the ones they need with evalArg.
+func (e *Evaluator) splitArgs(argsRaw string) ([]string, error) {
+	scan := e.newScanner(strings.NewReader(argsRaw))
+	args := e.newArgList()
+
+	for {
+		item, err := scan.Next()
+		if err != nil {
+			return nil, err
+		}
+		if item.Token == token.EOF {
+			break
+		}
+
+		switch item.Token {
+		case token.TEXT:
+			args.addText(item.Value)
+		case token.RETRIEVE, token.IMM_RETRIEVE:
+			name, err := e.scanNamePreservingOperators(scan)
+			if err != nil {
+				return nil, err
+			}
+			args.add(item.Value + name)
+		case token.EXECUTE, token.IMM_EXECUTE:
+			name, err := e.scanNamePreservingOperators(scan)
+			if err != nil {
+				return nil, err
+			}
+			body, _ := scan.ScanUntilTerminator()
+			args.add(item.Value + name + body + string(token.RuneTerminator))
+		}
+	}
+
+	return args.args, nil
+}
+
+// evalArg evaluates one argument returned by splitArgs, exactly as
+// parseArgs would have.
+func (e *Evaluator) evalArg(raw string) (string, error) {
+	args, err := e.parseArgs(raw)
+	if err != nil || len(args) == 0 {
+		return "", err
+	}
+	return args[0], nil
+}
+
+// peek is the read-only form of ▲ used for builtin arguments: immediate
+// operators in the body fire, but the result is NOT written back, so reading
+// an expression as an argument (e.g. ▶COMPARE ▲Expr x ◆) leaves it intact.
+func (e *Evaluator) peek(name string) (string, error) {
+	e.autoLoad(name)
+	val := e.namespace.Get(name)
+	if b, ok := val.(expr.Blob); ok {
+		return b.String(), nil
+	}
+	return e.parseBodyImmediateOnly(val.String())
+}
+
+// outputLimitError reports a result that grew past MAX_OUTPUT.
+func (e *Evaluator) outputLimitError() error {
+	msg := fmt.Sprintf("output exceeds MAX_OUTPUT (%d bytes)", e.maxOutput)
+	return &EvalError{Kind: KindOutputLimit, Message: msg}
+}
+
+// depthLimitError reports evaluation nested past MAX_DEPTH.
+func (e *Evaluator) depthLimitError() error {
+	msg := fmt.Sprintf("evaluation nested deeper than MAX_DEPTH (%d)", e.maxDepth)
+	return &EvalError{Kind: KindDepthLimit, Message: msg}
+}
+
 // concatResults concatenates all non-empty expressions into a single result.
 // Whitespace-only results containing newlines (source formatting between statements)
 // are collapsed into a single newline separator. Other whitespace (spaces on same
@@ -1063,6 +1780,10 @@ func (e *Evaluator) parseArgs(argsRaw string) ([]string, error) {
 func (e *Evaluator) concatResults(exprs []expr.Expr) expr.Expr {
 	var parts []string
 	needsNewline := false
+	// A builtin error that is the only content is passed up as is, so
+	// callers can tell it from text.
+	var errResult expr.Expr
+	contentCount := 0
 
 	for _, ex := range exprs {
 		if !ex.IsEmpty() {
@@ -1083,6 +1804,10 @@ func (e *Evaluator) concatResults(exprs []expr.Expr) expr.Expr {
 					parts = append(parts, s)
 				}
 			} else {
+				contentCount++
+				if _, ok := ex.(expr.Error); ok {
+					errResult = ex
+				}
 				// Content: add newline separator if needed, then content
 				if needsNewline {
 					parts = append(parts, "\n")
@@ -1096,6 +1821,9 @@ func (e *Evaluator) concatResults(exprs []expr.Expr) expr.Expr {
 	if len(parts) == 0 {
 		return expr.Empty{}
 	}
+	if contentCount == 1 && errResult != nil {
+		return errResult
+	}
 	return expr.Stored{Body: strings.Join(parts, "")}
 }
 
@@ -1240,6 +1968,8 @@ func (e *Evaluator) CorpusRegistry() *CorpusRegistry {
 
 // GetSetting returns a runtime setting value, or the default if unset.
 func (e *Evaluator) GetSetting(key, defaultVal string) string {
+	e.settingsMu.RLock()
+	defer e.settingsMu.RUnlock()
 	if v, ok := e.settings[key]; ok {
 		return v
 	}
@@ -1248,6 +1978,8 @@ func (e *Evaluator) GetSetting(key, defaultVal string) string {
 
 // SetSetting sets a runtime setting value.
 func (e *Evaluator) SetSetting(key, value string) {
+	e.settingsMu.Lock()
+	defer e.settingsMu.Unlock()
 	e.settings[key] = value
 }
 
@@ -1261,8 +1993,22 @@ func (e *Evaluator) SetPersistMode(mode PersistMode) {
 	e.persistMode = mode
 }
 
-// autoPersist persists a value to the store (used in ALWAYS mode).
+// keepInNamespace records a change sandboxed code made instead of queuing
+// it for the store, so autoLoad doesn't overwrite it with the stored value.
+func (e *Evaluator) keepInNamespace(name string) {
+	if e.sandboxNames == nil {
+		e.sandboxNames = make(map[string]bool)
+	}
+	e.sandboxNames[name] = true
+}
+
+// autoPersist queues a value for the store (used in ALWAYS mode).
 func (e *Evaluator) autoPersist(name string) {
+	// Sandboxed code changes only the namespace
+	if e.sandbox == SandboxSafe {
+		e.keepInNamespace(name)
+		return
+	}
 	// Don't re-persist the expression currently being auto-loaded.
 	// This prevents a feedback loop: autoLoad → Eval("▼X body ◆") → ▼X fires →
 	// autoPersist("X") → formatAsDefinition adds padding → next autoLoad inflates body.
@@ -1271,7 +2017,95 @@ func (e *Evaluator) autoPersist(name string) {
 	}
 	val := e.namespace.Get(name)
 	fullDef := formatAsDefinition(name, val)
-	e.store.Put(name, expr.Stored{Body: fullDef})
+
+	// Buffer the write; consecutive identical writes collapse into one.
+	last, pending := e.pendingNames[name]
+	if pending && last == fullDef {
+		return
+	}
+	// Nothing buffered: skip if the store already holds this exact definition.
+	if h, ok := e.persistedHash[name]; ok && !pending && h == definitionHash(fullDef) {
+		return
+	}
+	if e.pendingNames == nil {
+		e.pendingNames = make(map[string]string)
+	}
+	e.pendingNames[name] = fullDef
+	e.pendingPersist = append(e.pendingPersist, store.Entry{Name: name, Expr: persistValue(val, fullDef)})
+}
+
+// autoDelete buffers the removal of name from the store, dropping any
+// write of it still buffered. Deleting is a hard delete: the name's
+// history goes too, so HISTORY and CHECKOUT no longer find it.
+func (e *Evaluator) autoDelete(name string) {
+	if e.sandbox == SandboxSafe {
+		e.keepInNamespace(name)
+		return
+	}
+	if _, ok := e.pendingNames[name]; ok {
+		delete(e.pendingNames, name)
+		e.pendingPersist = slices.DeleteFunc(e.pendingPersist, func(entry store.Entry) bool {
+			return entry.Name == name
+		})
+	}
+	if e.pendingDeletes == nil {
+		e.pendingDeletes = make(map[string]bool)
+	}
+	e.pendingDeletes[name] = true
+}
+
+// Flush writes buffered auto-persist changes to the store, in a single
+// batch when the store supports it. Deletions go first, so a name deleted
+// and then redefined starts a fresh history. It is called automatically
+// when the outermost Eval returns.
+func (e *Evaluator) Flush() error {
+	if (len(e.pendingPersist) == 0 && len(e.pendingDeletes) == 0) || e.store == nil {
+		return nil
+	}
+	entries := e.pendingPersist
+	deletes := slices.Sorted(maps.Keys(e.pendingDeletes))
+	e.pendingPersist = nil
+	e.pendingNames = nil
+	e.pendingDeletes = nil
+
+	for _, name := range deletes {
+		if err := e.store.Delete(name); err != nil {
+			return err
+		}
+		delete(e.persistedHash, name)
+	}
+
+	if bs, ok := e.store.(store.BatchStore); ok {
+		if err := bs.PutBatch(entries); err != nil {
+			return err
+		}
+	} else {
+		for _, entry := range entries {
+			if err := e.store.Put(entry.Name, entry.Expr); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		e.rememberPersisted(entry.Name, entry.Expr.String())
+	}
+	return nil
+}
+
+// rememberPersisted records the definition the store now holds for name.
+func (e *Evaluator) rememberPersisted(name, def string) {
+	if e.persistedHash == nil {
+		e.persistedHash = make(map[string]uint64)
+	}
+	e.persistedHash[name] = definitionHash(def)
+}
+
+// definitionHash hashes a formatted definition for change detection.
+func definitionHash(def string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(def))
+	return h.Sum64()
 }
 
 // autoLoad loads a value from the store into the namespace when PersistAlways is active.
@@ -1280,6 +2114,10 @@ func (e *Evaluator) autoLoad(name string) {
 	if e.persistMode != PersistAlways || e.store == nil || e.autoLoading {
 		return
 	}
+	// A buffered write or deletion means the namespace is newer than the store.
+	if _, ok := e.pendingNames[name]; ok || e.pendingDeletes[name] || e.sandboxNames[name] {
+		return
+	}
 
 	e.autoLoading = true
 	e.autoLoadingName = name
@@ -1294,6 +2132,12 @@ func (e *Evaluator) autoLoad(name string) {
 	}
 
 	text := val.String()
+	if _, ok := val.(expr.Blob); ok {
+		e.rememberPersisted(name, text)
+		e.namespace.Set(name, val)
+		return
+	}
+	e.rememberPersisted(name, text)
 	trimmed := strings.TrimSpace(text)
 	runes := []rune(trimmed)
 	if len(runes) > 0 && runes[0] == token.RuneStore {
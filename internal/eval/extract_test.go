@@ -4,6 +4,7 @@
 package eval
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -172,3 +173,100 @@ func TestExtractInsufficientArgs(t *testing.T) {
 		t.Errorf("expected empty string for insufficient args, got '%s'", result)
 	}
 }
+
+func TestExtractAll(t *testing.T) {
+	e := New()
+
+	// The character response used by TestTraceExtractionPrompts
+	e.Eval(`▽Response NAME: Test Character
+TRAITS: curious, thoughtful, calm
+BELIEFS: CORE: honesty matters, kindness is strength
+GOALS: find meaning, help others
+MOOD: contemplative
+BACKSTORY_SUMMARY: A thoughtful individual seeking purpose.
+BACKSTORY_FULL: Full backstory here. ◆`)
+
+	result, err := e.Eval("▶EXTRACTALL ▲Response ◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `NAME: Test Character
+TRAITS: curious, thoughtful, calm
+BELIEFS: CORE: honesty matters, kindness is strength
+GOALS: find meaning, help others
+MOOD: contemplative
+BACKSTORY_SUMMARY: A thoughtful individual seeking purpose.
+BACKSTORY_FULL: Full backstory here.`
+	if result != expected {
+		t.Errorf("expected '%s', got '%s'", expected, result)
+	}
+
+	// Each field agrees with EXTRACT
+	for _, label := range []string{"NAME", "BELIEFS", "BACKSTORY_FULL"} {
+		single, _ := e.Eval("▶EXTRACT " + label + "\n▲Response ◆")
+		if !strings.Contains(result, label+": "+single+"\n") && !strings.HasSuffix(result, label+": "+single) {
+			t.Errorf("EXTRACTALL disagrees with EXTRACT for %s ('%s')", label, single)
+		}
+	}
+}
+
+func TestExtractAllMultiLine(t *testing.T) {
+	e := New()
+
+	e.Eval("▽Source Sure, here you go:\n\nNAME: Alice\nBIO: She is a programmer.\n  She loves coding.\nNOTES:\nfirst\nsecond\nAGE: 30 ◆")
+
+	result, err := e.Eval("▶EXTRACTALL ▲Source ◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "NAME: Alice\nBIO: She is a programmer. ↵ She loves coding.\nNOTES: first ↵ second\nAGE: 30"
+	if result != expected {
+		t.Errorf("expected '%s', got '%s'", expected, result)
+	}
+
+	// No labels at all
+	result, _ = e.Eval("▶EXTRACTALL just some prose ◆")
+	if result != "" {
+		t.Errorf("expected EMPTY without labels, got '%s'", result)
+	}
+}
+
+func TestExtractBlock(t *testing.T) {
+	e := New()
+
+	e.Eval(`▽Response NAME: Ada
+BELIEFS:
+  CORE: honesty matters
+    - even when it costs
+  - kindness is strength
+
+  EDGE: curiosity
+GOALS: help others
+  OUTER: not a new field
+MOOD: calm ◆`)
+
+	// EXTRACT stops at CORE; EXTRACT_BLOCK keeps the nested labels
+	if result, _ := e.Eval("▶EXTRACT BELIEFS ▲Response ◆"); result != "" {
+		t.Errorf("expected EXTRACT to stop at CORE, got '%s'", result)
+	}
+	result, err := e.Eval("▶EXTRACT_BLOCK BELIEFS ▲Response ◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "CORE: honesty matters\n  - even when it costs\n- kindness is strength\n\nEDGE: curiosity"
+	if result != expected {
+		t.Errorf("expected '%s', got '%s'", expected, result)
+	}
+
+	// An inline value starts the block; the next label at the same indent ends it
+	result, _ = e.Eval("▶EXTRACT_BLOCK goals ▲Response ◆")
+	if result != "help others\nOUTER: not a new field" {
+		t.Errorf("expected GOALS with its indented line, got '%s'", result)
+	}
+	if result, _ := e.Eval("▶EXTRACT_BLOCK NAME ▲Response ◆"); result != "Ada" {
+		t.Errorf("expected 'Ada', got '%s'", result)
+	}
+	if result, _ := e.Eval("▶EXTRACT_BLOCK MISSING ▲Response ◆"); result != "" {
+		t.Errorf("expected EMPTY for a missing label, got '%s'", result)
+	}
+}
@@ -4,6 +4,7 @@
 package eval
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -60,3 +61,30 @@ func TestIfWithTrue(t *testing.T) {
 		t.Errorf("IF should execute then-branch, got: %q", result)
 	}
 }
+
+func TestIfSkipsUntakenBranch(t *testing.T) {
+	var output strings.Builder
+	e := New(WithOutputWriter(func(text string) error {
+		output.WriteString(text)
+		return nil
+	}))
+
+	e.Eval("▼Pick □flag ▶IF ▶COMPARE ▲flag TRUE ◆ ▶SAY yes ◆ ▶SAY no ◆ ◆ ◆")
+
+	e.Eval("▶Pick TRUE ◆")
+	if output.String() != "yes\n" {
+		t.Errorf("expected only the then-branch to run, got %q", output.String())
+	}
+
+	output.Reset()
+	e.Eval("▶Pick FALSE ◆")
+	if output.String() != "no\n" {
+		t.Errorf("expected only the else-branch to run, got %q", output.String())
+	}
+
+	// The selected branch's value is still returned
+	result, _ := e.Eval("▶IF TRUE\n▶UPPER yes ◆\n▶UPPER no ◆\n◆")
+	if result != "YES" {
+		t.Errorf("expected 'YES', got %q", result)
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"sync"
+
+	"nickandperla.net/losp/internal/provider"
+)
+
+// ProviderLimiter bounds how many provider calls (prompts and embeddings)
+// run at once. It is shared between an evaluator and its async forks, which
+// share the provider.
+type ProviderLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int // 0 = unlimited
+	active int
+}
+
+// NewProviderLimiter creates a limiter with no limit.
+func NewProviderLimiter() *ProviderLimiter {
+	l := &ProviderLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// SetLimit changes the number of concurrent calls allowed; 0 removes the
+// limit. Calls already running are not interrupted.
+func (l *ProviderLimiter) SetLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = max(n, 0)
+	l.cond.Broadcast()
+}
+
+// Limit returns the current limit, 0 meaning unlimited.
+func (l *ProviderLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// acquire blocks until a call slot is free.
+func (l *ProviderLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.limit > 0 && l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+// release frees a slot taken by acquire.
+func (l *ProviderLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
+}
+
+// embed calls the embedding provider within the concurrency limit.
+func (e *Evaluator) embed(ep provider.EmbeddingProvider, texts []string) ([][]float32, error) {
+	e.providerLimit.acquire()
+	defer e.providerLimit.release()
+	return ep.Embed(texts)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"nickandperla.net/losp/internal/expr"
+)
+
+// logLevels ranks the LOG levels. SYSTEM LOG_LEVEL drops messages ranked
+// below it.
+var logLevels = map[string]int{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3}
+
+// logSink is where LOG writes. Like PromptCache, it is shared between an
+// evaluator and its async forks, so its lock keeps their lines whole.
+type logSink struct {
+	mu sync.Mutex
+	w  io.Writer // nil = os.Stderr
+}
+
+func (s *logSink) write(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.w
+	if w == nil {
+		w = os.Stderr
+	}
+	io.WriteString(w, line)
+}
+
+// WithLogWriter sets where LOG writes. By default it writes to stderr,
+// apart from SAY output.
+func WithLogWriter(w io.Writer) Option {
+	return func(e *Evaluator) { e.logSink.w = w }
+}
+
+// builtinLog writes a message to the log writer as
+// "<RFC 3339 time> [LEVEL] message", unless LEVEL ranks below
+// SYSTEM LOG_LEVEL.
+func builtinLog(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		return expr.Error{Code: "INVALID", Message: "LOG needs a level and a message"}, nil
+	}
+	level := strings.ToUpper(args[0])
+	rank, ok := logLevels[level]
+	if !ok {
+		return expr.Error{Code: "INVALID", Message: fmt.Sprintf("unknown log level %s", args[0])}, nil
+	}
+	if rank < logLevels[e.GetSetting("LOG_LEVEL", "INFO")] {
+		return expr.Empty{}, nil
+	}
+	message := strings.Join(args[1:], "\n")
+	e.logSink.write(fmt.Sprintf("%s [%s] %s\n", e.now().Format(time.RFC3339), level, message))
+	return expr.Empty{}, nil
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"hash/fnv"
+	"time"
+
+	"nickandperla.net/losp/internal/expr"
+)
+
+// memoize marks a stored expression as memoized. Results are cached per
+// argument list until the expression is redefined.
+func (e *Evaluator) memoize(name string) {
+	if e.memoized == nil {
+		e.memoized = make(map[string]bool)
+	}
+	e.memoized[name] = true
+}
+
+// nameChanged runs on every Namespace.Set: cached results of name no longer
+// hold, and nor does corpus index content for it. ALWAYS mode's reads
+// through to the store don't count as changes to a corpus member.
+func (e *Evaluator) nameChanged(name string) {
+	e.invalidateMemo(name)
+	if !e.autoLoading {
+		e.corpusRegistry.MarkChanged(name)
+	}
+}
+
+// nameDeleted runs when a name leaves the namespace. Beyond what a change
+// invalidates, ALWAYS mode removes the name from the store too.
+func (e *Evaluator) nameDeleted(name string) {
+	e.nameChanged(name)
+	if e.persistMode == PersistAlways && e.store != nil {
+		e.autoDelete(name)
+	}
+}
+
+// invalidateMemo drops cached results for name, so redefining a memoized
+// expression clears its cache.
+func (e *Evaluator) invalidateMemo(name string) {
+	if e.memoCache[name] != nil {
+		delete(e.memoCache, name)
+	}
+}
+
+// executeMemoized returns the cached result for name and args, executing
+// the expression only on a miss.
+func (e *Evaluator) executeMemoized(name string, stored expr.Expr, args []string, literal []bool) (expr.Expr, error) {
+	key := argsHash(args, literal)
+	if result, ok := e.memoCache[name][key]; ok {
+		return result, nil
+	}
+
+	result, err := e.executeStored(name, stored, args, literal)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.memoCache == nil {
+		e.memoCache = make(map[string]map[uint64]expr.Expr)
+	}
+	if e.memoCache[name] == nil {
+		e.memoCache[name] = make(map[uint64]expr.Expr)
+	}
+	e.memoCache[name][key] = result
+	return result, nil
+}
+
+// argsHash hashes an argument list; the separator keeps ["a b"] and
+// ["a", "b"] distinct. Whether each argument is literal is hashed too,
+// since only literal name=value text binds by name.
+func argsHash(args []string, literal []bool) uint64 {
+	h := fnv.New64a()
+	for i, arg := range args {
+		h.Write([]byte(arg))
+		if i < len(literal) && literal[i] {
+			h.Write([]byte{1})
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// throttleEntry is the last run of a THROTTLEd expression.
+type throttleEntry struct {
+	at     time.Time
+	result expr.Expr
+}
+
+// executeThrottled runs name unless it last ran less than window ago, in
+// which case the previous result is returned instead.
+func (e *Evaluator) executeThrottled(name string, window time.Duration) (expr.Expr, error) {
+	now := e.now()
+	if last, ok := e.throttled[name]; ok && now.Sub(last.at) < window {
+		return last.result, nil
+	}
+
+	result, err := e.execute(name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if e.throttled == nil {
+		e.throttled = make(map[string]throttleEntry)
+	}
+	e.throttled[name] = throttleEntry{at: now, result: result}
+	return result, nil
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"nickandperla.net/losp/internal/expr"
+	"nickandperla.net/losp/internal/provider"
+)
+
+// latencyWindow is the number of recent prompt durations kept for reporting.
+const latencyWindow = 50
+
+// LatencyTracker records the most recent provider prompt durations.
+// It is shared between an evaluator and its async forks.
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+// NewLatencyTracker creates an empty latency tracker.
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{}
+}
+
+// Record adds a prompt duration, dropping the oldest once the window is full.
+func (t *LatencyTracker) Record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = append(t.samples, d)
+	if len(t.samples) > latencyWindow {
+		t.samples = t.samples[len(t.samples)-latencyWindow:]
+	}
+}
+
+// Reset discards all recorded durations.
+func (t *LatencyTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = nil
+}
+
+// Summary returns "AVG: n\nMIN: n\nMAX: n" in milliseconds, or "" if no
+// prompts have been recorded.
+func (t *LatencyTracker) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) == 0 {
+		return ""
+	}
+
+	var total time.Duration
+	lo, hi := t.samples[0], t.samples[0]
+	for _, d := range t.samples {
+		total += d
+		lo = min(lo, d)
+		hi = max(hi, d)
+	}
+	avg := total / time.Duration(len(t.samples))
+
+	return fmt.Sprintf("AVG: %d\nMIN: %d\nMAX: %d", avg.Milliseconds(), lo.Milliseconds(), hi.Milliseconds())
+}
+
+// Usage is a running total of provider prompts. Providers don't report
+// token counts, so tokens are estimated from the prompt and response text
+// the same way ESTIMATE_EMBED estimates them.
+type Usage struct {
+	Prompts      int
+	InputTokens  int
+	OutputTokens int
+}
+
+// UsageCounter accumulates Usage. Like LatencyTracker, it is shared between
+// an evaluator and its async forks.
+type UsageCounter struct {
+	mu    sync.Mutex
+	total Usage
+}
+
+// Record counts one prompt with the given input and response text.
+func (c *UsageCounter) Record(input, output string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total.Prompts++
+	c.total.InputTokens += estimateTokens(input)
+	c.total.OutputTokens += estimateTokens(output)
+}
+
+// Reset zeroes the running total.
+func (c *UsageCounter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total = Usage{}
+}
+
+// Total returns the usage recorded so far.
+func (c *UsageCounter) Total() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Usage returns the provider prompts made so far by this evaluator and its
+// forks, since it was created or last SYSTEM RESET. Subtract two readings
+// to get the usage of what ran in between.
+func (e *Evaluator) Usage() Usage {
+	return e.promptUsage.Total()
+}
+
+// prompt sends a prompt to the provider, recording how long it took. While
+// SYSTEM PROMPT_CACHE is TRUE, a prompt already answered is served from the
+// cache instead.
+func (e *Evaluator) prompt(system, user string) (string, error) {
+	return e.promptParams(system, user, nil)
+}
+
+// promptParams is prompt with params overriding the provider's inference
+// params for this request only. The provider's own params are left alone,
+// so clones and async tasks sharing it don't see the overrides. With stream
+// capture on, the tokens go to this evaluator's capture.
+func (e *Evaluator) promptParams(system, user string, params map[string]string) (string, error) {
+	call := func() (string, error) {
+		op, ok := e.provider.(provider.OptionsProvider)
+		if !ok {
+			return e.provider.Prompt(system, user)
+		}
+		opts := provider.Options{Params: params}
+		if e.streamCapture != nil {
+			opts.Stream = e.streamCapture.write
+		}
+		return op.PromptOptions(system, user, opts)
+	}
+	if e.GetSetting("PROMPT_CACHE", "FALSE") != "TRUE" {
+		return e.timePrompt(system+user, call)
+	}
+	key := e.promptCacheKey(system, user, params)
+	if response, ok := e.promptCache.Get(key); ok {
+		return response, nil
+	}
+	response, err := e.timePrompt(system+user, call)
+	if err == nil {
+		e.promptCache.Put(key, response)
+	}
+	return response, err
+}
+
+// timePrompt runs a provider call within the concurrency limit, recording
+// how long it took and its estimated usage for input, the text sent. Time
+// spent waiting for a slot is not counted.
+func (e *Evaluator) timePrompt(input string, call func() (string, error)) (string, error) {
+	e.providerLimit.acquire()
+	defer e.providerLimit.release()
+	if e.streamCapture != nil {
+		e.streamCapture.reset()
+	}
+	start := time.Now()
+	response, err := call()
+	e.promptLatency.Record(time.Since(start))
+	e.promptUsage.Record(input, response)
+	return response, err
+}
+
+// now returns the current time from the configured clock.
+func (e *Evaluator) now() time.Time {
+	if e.clock != nil {
+		return e.clock()
+	}
+	return time.Now()
+}
+
+// builtinBench executes a stored body n times with SAY silenced and reports
+// the elapsed time as "total=Xms per=Yus".
+func builtinBench(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	args, err := e.parseArgs(argsRaw)
+	if err != nil {
+		return expr.Empty{}, nil
+	}
+	if len(args) < 2 {
+		return expr.Empty{}, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return expr.Empty{}, nil
+	}
+	name := args[1]
+
+	e.autoLoad(name)
+	if e.namespace.Get(name).IsEmpty() {
+		return expr.Empty{}, nil
+	}
+
+	saved := e.outputWriter
+	e.outputWriter = nil
+	defer func() { e.outputWriter = saved }()
+
+	// Each iteration is timed separately so that only execution is counted.
+	var total time.Duration
+	for range n {
+		start := e.now()
+		if _, err := e.execute(name, ""); err != nil {
+			return nil, err
+		}
+		total += e.now().Sub(start)
+	}
+
+	ms := float64(total) / float64(time.Millisecond)
+	us := float64(total) / float64(n) / float64(time.Microsecond)
+	return expr.Stored{Body: fmt.Sprintf("total=%sms per=%sus", formatDuration(ms), formatDuration(us))}, nil
+}
+
+// formatDuration formats a duration value to at most three decimal places.
+func formatDuration(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
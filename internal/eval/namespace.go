@@ -5,6 +5,8 @@
 package eval
 
 import (
+	"reflect"
+	"sort"
 	"sync"
 
 	"nickandperla.net/losp/internal/expr"
@@ -12,8 +14,11 @@ import (
 
 // Namespace is a thread-safe global namespace for losp variables.
 type Namespace struct {
-	mu    sync.RWMutex
-	store map[string]expr.Expr
+	mu       sync.RWMutex
+	store    map[string]expr.Expr
+	onSet    func(name string) // Called after every Set (not copied by Clone)
+	onDelete func(name string) // Called after a name is removed (not copied by Clone)
+	guard    func(name string) bool // Decides whether Set and Delete may change a name (nil = all may; not copied by Clone)
 }
 
 // NewNamespace creates a new empty namespace.
@@ -33,11 +38,43 @@ func (n *Namespace) Get(name string) expr.Expr {
 	return expr.Empty{}
 }
 
-// Set stores an expression by name.
+// Set stores an expression by name, unless the Guard function refuses it.
 func (n *Namespace) Set(name string, e expr.Expr) {
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	if n.guard != nil && !n.guard(name) {
+		n.mu.Unlock()
+		return
+	}
 	n.store[name] = e
+	onSet := n.onSet
+	n.mu.Unlock()
+	if onSet != nil {
+		onSet(name)
+	}
+}
+
+// OnSet registers a function called with the name after every Set.
+func (n *Namespace) OnSet(fn func(name string)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onSet = fn
+}
+
+// OnDelete registers a function called with the name after Delete removes
+// it, or Restore drops it.
+func (n *Namespace) OnDelete(fn func(name string)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onDelete = fn
+}
+
+// Guard registers a function that decides whether Set and Delete may change
+// a name; refused writes are dropped. It is called with the namespace
+// locked. A nil fn lifts the guard.
+func (n *Namespace) Guard(fn func(name string) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.guard = fn
 }
 
 // Has returns true if the name exists in the namespace.
@@ -48,11 +85,33 @@ func (n *Namespace) Has(name string) bool {
 	return ok
 }
 
-// Delete removes an expression from the namespace.
+// Delete removes an expression from the namespace, unless the Guard
+// function refuses it.
 func (n *Namespace) Delete(name string) {
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	if n.guard != nil && !n.guard(name) {
+		n.mu.Unlock()
+		return
+	}
+	_, ok := n.store[name]
 	delete(n.store, name)
+	onDelete := n.onDelete
+	n.mu.Unlock()
+	if ok && onDelete != nil {
+		onDelete(name)
+	}
+}
+
+// Names returns every defined name in sorted order.
+func (n *Namespace) Names() []string {
+	n.mu.RLock()
+	names := make([]string, 0, len(n.store))
+	for k := range n.store {
+		names = append(names, k)
+	}
+	n.mu.RUnlock()
+	sort.Strings(names)
+	return names
 }
 
 // Clone creates a shallow copy of the namespace.
@@ -65,3 +124,45 @@ func (n *Namespace) Clone() *Namespace {
 	}
 	return clone
 }
+
+// Restore replaces the contents of the namespace with those of snap,
+// usually an earlier Clone. The OnSet function is called for each name
+// whose value changed or that snap brings back, and OnDelete for each name
+// snap doesn't have.
+func (n *Namespace) Restore(snap *Namespace) {
+	snap.mu.RLock()
+	restored := make(map[string]expr.Expr, len(snap.store))
+	for k, v := range snap.store {
+		restored[k] = v
+	}
+	snap.mu.RUnlock()
+
+	n.mu.Lock()
+	var changed, deleted []string
+	for k, v := range n.store {
+		if old, ok := restored[k]; !ok {
+			deleted = append(deleted, k)
+		} else if !reflect.DeepEqual(old, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range restored {
+		if _, ok := n.store[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	n.store = restored
+	onSet, onDelete := n.onSet, n.onDelete
+	n.mu.Unlock()
+
+	if onSet != nil {
+		for _, name := range changed {
+			onSet(name)
+		}
+	}
+	if onDelete != nil {
+		for _, name := range deleted {
+			onDelete(name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"strings"
+
+	"nickandperla.net/losp/internal/scanner"
+	"nickandperla.net/losp/internal/token"
+)
+
+// Node is one element of a parsed losp program: an operator with its name
+// and body, or a run of text. Parse builds the tree without evaluating
+// anything, for tools such as formatters and linters.
+type Node struct {
+	Op       rune   // ▼ ▽ ▲ △ ▶ ▷ □ ◯, ◆ for a stray top-level terminator, or 0 for text
+	Name     string // Name after the operator; empty for text, ◯ and dynamic names
+	DynName  *Node  // Dynamic name (▲x, △x, ▶F ◆ or ▷F ◆) in place of Name, or nil
+	Text     string // Text content, whitespace included; text nodes only
+	Children []Node // Body of ▼, ▽, ▶, ▷ and ◯, up to but excluding its ◆
+	Line     int    // 1-based line where the node starts
+	Col      int    // 1-based column, in runes, where the node starts
+}
+
+// Parse returns the operator tree of src without evaluating it. Operator
+// bodies are parsed the same way at every depth, so a ▼ body's operators
+// appear as children even though evaluation would defer them. An operator
+// missing its ◆ is a KindUnterminated EvalError.
+func (e *Evaluator) Parse(src string) ([]Node, error) {
+	scan := e.newScanner(strings.NewReader(src))
+	nodes, _, err := parseNodes(scan, false)
+	return nodes, err
+}
+
+// parseNodes reads nodes until EOF or, when nested, the closing ◆. It
+// reports whether that ◆ was found.
+func parseNodes(scan *scanner.Scanner, nested bool) ([]Node, bool, error) {
+	var nodes []Node
+	for {
+		item, err := scan.Next()
+		if err != nil {
+			return nil, false, err
+		}
+		switch item.Token {
+		case token.EOF:
+			return nodes, false, nil
+		case token.TERMINATOR:
+			if nested {
+				return nodes, true, nil
+			}
+			nodes = append(nodes, Node{Op: token.RuneTerminator, Line: item.Line, Col: item.Col})
+		case token.TEXT:
+			nodes = append(nodes, Node{Text: item.Value, Line: item.Line, Col: item.Col})
+		default:
+			node, err := parseOperator(scan, item)
+			if err != nil {
+				return nil, false, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+}
+
+// parseOperator reads the name and, for operators that take one, the body
+// of the operator scanned as item.
+func parseOperator(scan *scanner.Scanner, item *scanner.Item) (Node, error) {
+	node := Node{Op: []rune(item.Value)[0], Line: item.Line, Col: item.Col}
+
+	switch item.Token {
+	case token.PLACEHOLDER:
+		name, err := scan.ScanName()
+		node.Name = name
+		return node, err
+	case token.DEFER:
+		// ◯ has a body but no name
+	default:
+		if err := parseName(scan, &node); err != nil {
+			return node, err
+		}
+		if !item.Token.NeedsTerminator() {
+			return node, nil
+		}
+	}
+
+	children, terminated, err := parseNodes(scan, true)
+	if err != nil {
+		return node, err
+	}
+	if !terminated {
+		opName := item.Value
+		if item.Token == token.DEFER {
+			opName = "◯ (defer)"
+		}
+		return node, unterminatedError(scan, opName, item.Line, item.Col, 0)
+	}
+	node.Children = children
+	return node, nil
+}
+
+// parseName reads a static name, or a dynamic one the way
+// scanNameOrDynamic would resolve it at run time.
+func parseName(scan *scanner.Scanner, node *Node) error {
+	r, err := scan.PeekRune()
+	if err != nil {
+		return err
+	}
+	switch r {
+	case token.RuneRetrieve, token.RuneImmRetrieve, token.RuneExecute, token.RuneImmExecute:
+		item, err := scan.Next()
+		if err != nil {
+			return err
+		}
+		dyn, err := parseOperator(scan, item)
+		if err != nil {
+			return err
+		}
+		node.DynName = &dyn
+		return nil
+	default:
+		node.Name, err = scan.ScanName()
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// outline renders nodes compactly: operators as op+name with their body in
+// brackets, text as its trimmed, quoted value.
+func outline(nodes []Node) string {
+	var parts []string
+	for _, n := range nodes {
+		if n.Op == 0 {
+			parts = append(parts, `"`+strings.TrimSpace(n.Text)+`"`)
+			continue
+		}
+		s := string(n.Op) + n.Name
+		if n.DynName != nil {
+			s += "(" + outline([]Node{*n.DynName}) + ")"
+		}
+		if n.Children != nil {
+			s += "[" + outline(n.Children) + "]"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseStoreWithExecute(t *testing.T) {
+	e := New()
+	src := "▼Greet\n    □name\n    ▶SAY Hello, ▲name ◆\n◆\n▶Greet World ◆"
+
+	nodes, err := e.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `▼Greet["" □name "" ▶SAY["Hello," ▲name ""] ""] "" ▶Greet["World"]`
+	if got := outline(nodes); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	say := nodes[0].Children[3]
+	if say.Line != 3 || say.Col != 5 {
+		t.Errorf("expected ▶SAY at 3:5, got %d:%d", say.Line, say.Col)
+	}
+
+	// Nothing ran: Greet was never defined
+	if !e.namespace.Get("Greet").IsEmpty() {
+		t.Error("expected Parse not to evaluate anything")
+	}
+}
+
+func TestParseDynamicNamesAndDefer(t *testing.T) {
+	e := New()
+	nodes, err := e.Parse("▽▲which ◯▲x◆ ◆ ▶▶Pick ◆ ◆ ◆")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `▽(▲which)["" ◯[▲x] ""] "" ▶(▶Pick[""])[""] "" ◆`
+	if got := outline(nodes); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestParseUnterminated(t *testing.T) {
+	e := New()
+	_, err := e.Parse("▶SAY ok ◆\n▼Broken ▶SAY hi ◆")
+
+	var ee *EvalError
+	if !errors.As(err, &ee) || ee.Kind != KindUnterminated || ee.Line != 2 {
+		t.Errorf("expected an unterminated error at line 2, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"container/list"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// promptCacheLimit is how many responses the prompt cache keeps before it
+// evicts the least recently used.
+const promptCacheLimit = 1000
+
+// PromptCache holds provider responses while SYSTEM PROMPT_CACHE is TRUE,
+// so repeating a prompt doesn't call the provider again. Like
+// LatencyTracker, it is shared between an evaluator and its async forks.
+type PromptCache struct {
+	mu           sync.Mutex
+	limit        int
+	entries      map[string]*list.Element // key -> element in lru
+	lru          *list.List               // front = most recently used
+	hits, misses int
+}
+
+// promptCacheEntry is one cached response in the LRU list.
+type promptCacheEntry struct {
+	key, response string
+}
+
+// NewPromptCache creates an empty prompt cache.
+func NewPromptCache() *PromptCache {
+	return &PromptCache{limit: promptCacheLimit, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Get returns the cached response for key, counting a hit or a miss.
+func (c *PromptCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*promptCacheEntry).response, true
+}
+
+// Put caches response for key, evicting the least recently used response
+// once the cache is full.
+func (c *PromptCache) Put(key, response string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*promptCacheEntry).response = response
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&promptCacheEntry{key: key, response: response})
+	for c.lru.Len() > c.limit {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*promptCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *PromptCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Clear drops every cached response and zeroes the hit and miss counts.
+func (c *PromptCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+	c.hits, c.misses = 0, 0
+}
+
+// Stats returns "HITS: n\nMISSES: n".
+func (c *PromptCache) Stats() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("HITS: %d\nMISSES: %d", c.hits, c.misses)
+}
+
+// promptCacheKey identifies a prompt to the current provider, model and
+// inference params, with PROMPT_WITH's params applied, so changing any of
+// them doesn't return an answer given under others.
+func (e *Evaluator) promptCacheKey(system, user string, params map[string]string) string {
+	var name, model string
+	effective := make(map[string]string)
+	if cfg, ok := e.provider.(Configurable); ok {
+		name, model = cfg.ProviderName(), cfg.GetModel()
+		for _, k := range inferenceParams {
+			if v := cfg.GetParam(k); v != "" {
+				effective[k] = v
+			}
+		}
+	}
+	maps.Copy(effective, params)
+	// Retries don't change the answer
+	delete(effective, "RETRY_ON_EMPTY")
+
+	parts := []string{name, model, system, user}
+	for _, k := range slices.Sorted(maps.Keys(effective)) {
+		parts = append(parts, k+"="+effective[k])
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import "nickandperla.net/losp/internal/expr"
+
+// SandboxProfile restricts which builtins an evaluator may call.
+type SandboxProfile int
+
+const (
+	// SandboxNone is the default - every builtin is available.
+	SandboxNone SandboxProfile = iota
+	// SandboxSafe allows only builtins that cannot write to the store, read
+	// input, or define macros, lets SYSTEM change only safeSettings, and
+	// doesn't auto-persist in ALWAYS mode.
+	// Intended for running untrusted losp such as GENERATE output.
+	SandboxSafe
+)
+
+// SafeMaxOutput and SafeMaxDepth are the MAX_OUTPUT and MAX_DEPTH that
+// SandboxSafe starts with, tighter than the defaults because the code it
+// runs is untrusted.
+const (
+	SafeMaxOutput = 1 << 20
+	SafeMaxDepth  = 500
+)
+
+// String returns the string representation of a SandboxProfile.
+func (p SandboxProfile) String() string {
+	switch p {
+	case SandboxNone:
+		return "NONE"
+	case SandboxSafe:
+		return "SAFE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// safeBuiltins is the SandboxSafe allow-list. PERSIST, LOAD, FLUSH, READ,
+// GENERATE, the corpus-building builtins and DEFMACRO, whose macros the
+// scanner would keep expanding after the sandbox, are deliberately absent.
+var safeBuiltins = map[string]bool{
+	"TRUE": true, "FALSE": true, "EMPTY": true,
+	"IF": true, "COMPARE": true, "COMPARE_DIFF": true, "EDIT_DISTANCE": true, "FUZZY_EQ": true, "CONTAINSLINE": true, "FOREACH": true, "GROUP": true,
+	"RENDER": true, "PARAMS": true, "WHICH": true, "MEMO": true, "THROTTLE": true, "SAY": true, "LOG": true, "COUNT": true, "APPEND": true, "COPY": true, "GETLINE": true, "SETLINE": true,
+	"PROMPT": true, "PROMPT_WITH": true, "STREAM_SO_FAR": true, "PROMPT_SCHEMA": true, "EXTRACT": true, "EXTRACTALL": true, "EXTRACT_BLOCK": true, "SYSTEM": true,
+	"UPPER": true, "LOWER": true, "TRIM": true, "SUBSTITUTE": true, "LIMIT": true, "SPLITN": true, "COALESCE": true, "CONCAT": true, "WRAP": true, "TABLE": true, "ESCAPE_PROMPT": true, "VALIDATE": true, "PARSE": true,
+	"BASE64_ENCODE": true, "BASE64_DECODE": true,
+	"ASYNC": true, "AWAIT": true, "ONDONE": true, "CHECK": true, "CHECKALL": true, "CHECKANY": true, "TIMER": true, "TICKS": true,
+	"TASKS": true, "SLEEP": true, "WAIT": true, "BENCH": true,
+	"SEARCH": true, "ESTIMATE_EMBED": true, "SIMILAR": true, "SEMANTIC_EQ": true, "EMBEDTEXT": true, "VECSIM": true,
+	"HISTORY": true, "FINDVALUE": true, "RANDOM": true,
+}
+
+// safeSettings are the only SYSTEM settings SandboxSafe lets code change.
+// Every other setting outlives the sandbox in the provider, the store, the
+// prompt cache or how later code is read and prompted, so it can only be
+// read.
+var safeSettings = map[string]bool{
+	"LOG_LEVEL":    true,
+	"SEARCH_LIMIT": true,
+}
+
+// settingActions are the SYSTEM keys that change state without a value.
+var settingActions = map[string]bool{
+	"RESET":       true,
+	"CACHE_CLEAR": true,
+}
+
+// WithSandbox restricts the evaluator to the builtins allowed by profile.
+// Forbidden builtins return FORBIDDEN instead of running. SandboxSafe also
+// lowers MAX_OUTPUT and MAX_DEPTH to SafeMaxOutput and SafeMaxDepth;
+// WithMaxOutput and WithMaxDepth given after it override them.
+func WithSandbox(profile SandboxProfile) Option {
+	return func(e *Evaluator) {
+		e.sandbox = profile
+		if profile == SandboxSafe {
+			e.maxOutput, e.maxDepth = SafeMaxOutput, SafeMaxDepth
+		}
+	}
+}
+
+// Sandbox returns the evaluator's sandbox profile.
+func (e *Evaluator) Sandbox() SandboxProfile {
+	return e.sandbox
+}
+
+// allowsBuiltin reports whether the sandbox permits calling the builtin.
+func (e *Evaluator) allowsBuiltin(name string) bool {
+	return e.sandbox != SandboxSafe || safeBuiltins[name]
+}
+
+// allowsSetting reports whether the sandbox permits changing a SYSTEM setting.
+func (e *Evaluator) allowsSetting(key string) bool {
+	return e.sandbox != SandboxSafe || safeSettings[key]
+}
+
+// tighterLimit returns the stricter of two caps, where 0 is no cap.
+func tighterLimit(current, limit int) int {
+	if current == 0 {
+		return limit
+	}
+	return min(current, limit)
+}
+
+// forbidden is the result of a call refused by the sandbox.
+func forbidden() expr.Expr {
+	return expr.Stored{Body: "FORBIDDEN"}
+}
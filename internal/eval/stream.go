@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package eval
+
+import (
+	"strings"
+	"sync"
+
+	"nickandperla.net/losp/internal/expr"
+)
+
+// streamBufferName is where WithStreamCapture keeps the partial response.
+const streamBufferName = "_stream_buffer"
+
+// StreamSettable is a provider whose stream callback can be replaced after
+// it is created. WithStreamCapture needs it to see the provider's tokens.
+type StreamSettable interface {
+	SetStreamCallback(cb func(token string))
+}
+
+// WithStreamCapture makes the evaluator collect the response of the prompt
+// in flight, token by token, in _stream_buffer and for STREAM_SO_FAR. The
+// provider streams even without a stream callback; one set with
+// WithStreamCallback still receives every token.
+func WithStreamCapture() Option {
+	return func(e *Evaluator) { e.streamCapture = &streamCapture{} }
+}
+
+// streamCapture holds the partial response of the current prompt. It is
+// shared between an evaluator and its async forks, and tokens are stored in
+// the namespace of the evaluator that installed it, where a watcher can see
+// an async prompt's progress. A Clone gets its own, which it passes with
+// each prompt rather than installing it on the shared provider.
+type streamCapture struct {
+	mu      sync.Mutex
+	buf     strings.Builder
+	ns      *Namespace
+	forward StreamCallback
+}
+
+// install routes the provider's tokens through the capture, forwarding
+// them to cb. Providers that can't be rewired are left alone.
+func (c *streamCapture) install(p Provider, ns *Namespace, cb StreamCallback) {
+	c.ns, c.forward = ns, cb
+	if s, ok := p.(StreamSettable); ok {
+		s.SetStreamCallback(c.write)
+	}
+}
+
+// reset empties the buffer at the start of a prompt.
+func (c *streamCapture) reset() {
+	c.mu.Lock()
+	c.buf.Reset()
+	c.mu.Unlock()
+	if c.ns != nil {
+		c.ns.Set(streamBufferName, expr.Empty{})
+	}
+}
+
+func (c *streamCapture) write(token string) {
+	c.mu.Lock()
+	c.buf.WriteString(token)
+	text := c.buf.String()
+	c.mu.Unlock()
+	if c.ns != nil {
+		c.ns.Set(streamBufferName, expr.NewText(text))
+	}
+	if c.forward != nil {
+		c.forward(token)
+	}
+}
+
+func (c *streamCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// streamCallback is the callback to give a newly created provider.
+func (e *Evaluator) streamCallback() StreamCallback {
+	if e.streamCapture != nil {
+		return e.streamCapture.write
+	}
+	return e.streamCb
+}
+
+func builtinStreamSoFar(e *Evaluator, argsRaw string) (expr.Expr, error) {
+	// STREAM_SO_FAR
+	// The response of the prompt in flight, as far as it has streamed, or of
+	// the last prompt once it is done. EMPTY without WithStreamCapture.
+	if e.streamCapture == nil {
+		return expr.Empty{}, nil
+	}
+	return expr.NewText(e.streamCapture.String()), nil
+}
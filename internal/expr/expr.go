@@ -82,6 +82,31 @@ type Stored struct {
 func (s Stored) String() string { return s.Body }
 func (s Stored) IsEmpty() bool  { return s.Body == "" }
 
+// Blob represents binary content. Unlike Stored, its bytes are never
+// scanned for operators or trimmed, and stores keep them byte-for-byte.
+type Blob struct {
+	Data []byte
+}
+
+func (b Blob) String() string { return string(b.Data) }
+func (b Blob) IsEmpty() bool  { return len(b.Data) == 0 }
+
+// Error is a failure reported by a builtin, such as an unknown handle,
+// kept distinct from an Empty result that legitimately found nothing.
+// It renders as "ERROR CODE: message".
+type Error struct {
+	Code    string // Machine-readable kind, e.g. NOT_FOUND
+	Message string // Human-readable detail
+}
+
+func (e Error) String() string {
+	if e.Message == "" {
+		return "ERROR " + e.Code
+	}
+	return "ERROR " + e.Code + ": " + e.Message
+}
+func (e Error) IsEmpty() bool { return false }
+
 // Compound represents a sequence of expressions.
 type Compound struct {
 	Exprs []Expr
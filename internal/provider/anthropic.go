@@ -11,23 +11,30 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Anthropic is a provider for Anthropic's Claude API.
 type Anthropic struct {
+	URL      string
 	APIKey   string
 	Model    string
 	Timeout  time.Duration
 	StreamCb StreamCallback
 	params   map[string]string
+	mu       sync.RWMutex // Guards Model, StreamCb and params, which evaluator clones and async tasks share
 }
 
 // AnthropicOption configures the Anthropic provider.
 type AnthropicOption func(*Anthropic)
 
+// WithAnthropicURL sets the API base URL.
+func WithAnthropicURL(url string) AnthropicOption {
+	return func(a *Anthropic) { a.URL = url }
+}
+
 // WithAnthropicAPIKey sets the API key.
 func WithAnthropicAPIKey(key string) AnthropicOption {
 	return func(a *Anthropic) { a.APIKey = key }
@@ -51,6 +58,7 @@ func WithAnthropicStreamCallback(cb StreamCallback) AnthropicOption {
 // NewAnthropic creates a new Anthropic provider.
 func NewAnthropic(opts ...AnthropicOption) *Anthropic {
 	a := &Anthropic{
+		URL:     "https://api.anthropic.com",
 		APIKey:  os.Getenv("ANTHROPIC_API_KEY"),
 		Model:   "claude-sonnet-4-20250514",
 		Timeout: 5 * time.Minute,
@@ -63,20 +71,50 @@ func NewAnthropic(opts ...AnthropicOption) *Anthropic {
 }
 
 // GetParam returns an inference parameter value.
-func (a *Anthropic) GetParam(key string) string { return a.params[key] }
+func (a *Anthropic) GetParam(key string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.params[key]
+}
 
 // SetParam sets an inference parameter value.
-func (a *Anthropic) SetParam(key, value string) { a.params[key] = value }
+func (a *Anthropic) SetParam(key, value string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.params[key] = value
+}
 
 // GetModel returns the current model name.
-func (a *Anthropic) GetModel() string { return a.Model }
+func (a *Anthropic) GetModel() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Model
+}
 
 // SetModel sets the model name.
-func (a *Anthropic) SetModel(model string) { a.Model = model }
+func (a *Anthropic) SetModel(model string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Model = model
+}
+
+// settings returns what one prompt runs with.
+func (a *Anthropic) settings(opts Options) callSettings {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return newCallSettings(a.params, a.Model, a.StreamCb, opts)
+}
 
 // ProviderName returns "ANTHROPIC".
 func (a *Anthropic) ProviderName() string { return "ANTHROPIC" }
 
+// SetStreamCallback replaces the streaming callback; nil stops streaming.
+func (a *Anthropic) SetStreamCallback(cb func(token string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.StreamCb = cb
+}
+
 type anthropicRequest struct {
 	Model       string             `json:"model"`
 	MaxTokens   int                `json:"max_tokens"`
@@ -118,42 +156,40 @@ type anthropicStreamEvent struct {
 
 // Prompt sends a prompt to Anthropic and returns the response.
 func (a *Anthropic) Prompt(system, user string) (string, error) {
+	return a.PromptOptions(system, user, Options{})
+}
+
+// PromptOptions sends a prompt adjusted by opts.
+func (a *Anthropic) PromptOptions(system, user string, opts Options) (string, error) {
 	if a.APIKey == "" {
 		return "", fmt.Errorf("ANTHROPIC_API_KEY not set")
 	}
+	cs := a.settings(opts)
+	return retryOnEmpty("anthropic", cs.params, false, func() (string, error) {
+		return a.promptOnce(system, user, cs)
+	})
+}
 
+func (a *Anthropic) promptOnce(system, user string, cs callSettings) (string, error) {
 	messages := []anthropicMessage{
 		{Role: "user", Content: user},
 	}
 
+	// max_tokens is required by the Messages API
 	maxTokens := 4096
-	if v, ok := a.params["MAX_TOKENS"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			maxTokens = n
-		}
+	if n := intParam(cs.params, "MAX_TOKENS"); n != nil {
+		maxTokens = *n
 	}
 
 	reqBody := anthropicRequest{
-		Model:     a.Model,
-		MaxTokens: maxTokens,
-		System:    system,
-		Messages:  messages,
-		Stream:    a.StreamCb != nil,
-	}
-	if v, ok := a.params["TEMPERATURE"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			reqBody.Temperature = &f
-		}
-	}
-	if v, ok := a.params["TOP_K"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			reqBody.TopK = &n
-		}
-	}
-	if v, ok := a.params["TOP_P"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			reqBody.TopP = &f
-		}
+		Model:       chatModel(cs.params, cs.model),
+		MaxTokens:   maxTokens,
+		System:      system,
+		Messages:    messages,
+		Stream:      cs.stream != nil,
+		Temperature: floatParam(cs.params, "TEMPERATURE"),
+		TopK:        intParam(cs.params, "TOP_K"),
+		TopP:        floatParam(cs.params, "TOP_P"),
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -161,7 +197,7 @@ func (a *Anthropic) Prompt(system, user string) (string, error) {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewReader(jsonBody))
+	req, err := http.NewRequest("POST", a.URL+"/v1/messages", bytes.NewReader(jsonBody))
 	if err != nil {
 		return "", err
 	}
@@ -182,8 +218,8 @@ func (a *Anthropic) Prompt(system, user string) (string, error) {
 		return "", fmt.Errorf("anthropic error (%d): %s", resp.StatusCode, string(body))
 	}
 
-	if a.StreamCb != nil {
-		return a.readStream(resp.Body)
+	if cs.stream != nil {
+		return a.readStream(resp.Body, cs.stream)
 	}
 
 	var result anthropicResponse
@@ -210,8 +246,9 @@ func (a *Anthropic) Prompt(system, user string) (string, error) {
 	return sb.String(), nil
 }
 
-func (a *Anthropic) readStream(body io.Reader) (string, error) {
+func (a *Anthropic) readStream(body io.Reader, cb StreamCallback) (string, error) {
 	scanner := bufio.NewScanner(body)
+	out := &runeBuffer{cb: cb}
 	var fullResponse strings.Builder
 
 	for scanner.Scan() {
@@ -235,11 +272,14 @@ func (a *Anthropic) readStream(body io.Reader) (string, error) {
 			text := event.Delta.Text
 			fullResponse.WriteString(text)
 
-			if a.StreamCb != nil {
-				a.StreamCb(text)
+			if cb != nil {
+				out.Write(text)
 			}
 		}
 	}
 
+	if cb != nil {
+		out.Flush()
+	}
 	return fullResponse.String(), scanner.Err()
 }
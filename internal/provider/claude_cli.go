@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,7 @@ type ClaudeCLI struct {
 	Timeout  time.Duration
 	StreamCb StreamCallback
 	params   map[string]string
+	mu       sync.RWMutex // Guards Model, StreamCb and params, which evaluator clones and async tasks share
 }
 
 // ClaudeCLIOption configures the ClaudeCLI provider.
@@ -54,24 +56,61 @@ func NewClaudeCLI(opts ...ClaudeCLIOption) *ClaudeCLI {
 }
 
 // GetParam returns an inference parameter value.
-func (c *ClaudeCLI) GetParam(key string) string { return c.params[key] }
+func (c *ClaudeCLI) GetParam(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.params[key]
+}
 
 // SetParam sets an inference parameter value.
-func (c *ClaudeCLI) SetParam(key, value string) { c.params[key] = value }
+func (c *ClaudeCLI) SetParam(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.params[key] = value
+}
 
 // GetModel returns the current model name.
-func (c *ClaudeCLI) GetModel() string { return c.Model }
+func (c *ClaudeCLI) GetModel() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Model
+}
 
 // SetModel sets the model name.
-func (c *ClaudeCLI) SetModel(model string) { c.Model = model }
+func (c *ClaudeCLI) SetModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Model = model
+}
+
+// settings returns what one prompt runs with.
+func (c *ClaudeCLI) settings(opts Options) callSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return newCallSettings(c.params, c.Model, c.StreamCb, opts)
+}
 
 // ProviderName returns "CLAUDE_CLI".
 func (c *ClaudeCLI) ProviderName() string { return "CLAUDE_CLI" }
 
+// SetStreamCallback replaces the streaming callback; nil stops streaming.
+func (c *ClaudeCLI) SetStreamCallback(cb func(token string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StreamCb = cb
+}
+
 // Prompt sends a prompt to the claude CLI and returns the response.
 // It fully detaches the claude process from the parent's process tree to avoid
 // Claude Code's nested-session detection.
 func (c *ClaudeCLI) Prompt(system, user string) (string, error) {
+	return c.PromptOptions(system, user, Options{})
+}
+
+// PromptOptions sends a prompt adjusted by opts. The CLI takes no
+// inference params, so only CHAT_MODEL and the stream callback apply.
+func (c *ClaudeCLI) PromptOptions(system, user string, opts Options) (string, error) {
+	cs := c.settings(opts)
 	claudePath, err := exec.LookPath("claude")
 	if err != nil {
 		return "", fmt.Errorf("claude CLI not found in PATH: %w", err)
@@ -98,7 +137,7 @@ func (c *ClaudeCLI) Prompt(system, user string) (string, error) {
 	scriptBuilder.WriteString("CLAUDECODE= MAX_THINKING_TOKENS=0 ")
 	scriptBuilder.WriteString(fmt.Sprintf("%s -p ", claudePath))
 	scriptBuilder.WriteString("--output-format text ")
-	scriptBuilder.WriteString(fmt.Sprintf("--model %s ", shellQuote(c.Model)))
+	scriptBuilder.WriteString(fmt.Sprintf("--model %s ", shellQuote(chatModel(cs.params, cs.model))))
 	scriptBuilder.WriteString("--max-turns 1 ")
 	scriptBuilder.WriteString("--tools '' ")
 	scriptBuilder.WriteString("--disable-slash-commands ")
@@ -164,8 +203,8 @@ func (c *ClaudeCLI) Prompt(system, user string) (string, error) {
 	result := strings.TrimSpace(string(output))
 
 	// Stream the result if callback is set (not true streaming, but delivers the output)
-	if c.StreamCb != nil && result != "" {
-		c.StreamCb(result)
+	if cs.stream != nil && result != "" {
+		cs.stream(result)
 	}
 
 	return result, nil
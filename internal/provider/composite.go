@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Fallback is a provider that tries each backend in order until one
+// succeeds, for resilience against a backend being down.
+type Fallback struct {
+	providers []Provider
+}
+
+// NewFallback creates a provider that tries providers in the given order.
+func NewFallback(providers ...Provider) *Fallback {
+	return &Fallback{providers: providers}
+}
+
+// Prompt returns the first successful response. If every backend fails,
+// the error carries each backend's error.
+func (f *Fallback) Prompt(system, user string) (string, error) {
+	var errs []error
+	for _, p := range f.providers {
+		result, err := p.Prompt(system, user)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", allFailed("fallback", errs)
+}
+
+// PromptOptions is Prompt with opts applied to whichever backend answers.
+func (f *Fallback) PromptOptions(system, user string, opts Options) (string, error) {
+	var errs []error
+	for _, p := range f.providers {
+		result, err := promptOptions(p, system, user, opts)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", allFailed("fallback", errs)
+}
+
+// Embed tries each backend that supports embeddings, in order.
+func (f *Fallback) Embed(texts []string) ([][]float32, error) {
+	var errs []error
+	for _, p := range f.providers {
+		ep, ok := p.(EmbeddingProvider)
+		if !ok {
+			continue
+		}
+		vectors, err := ep.Embed(texts)
+		if err == nil {
+			return vectors, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, allFailed("fallback", errs)
+}
+
+// RoundRobin is a provider that spreads calls across its backends in turn.
+// A failed call is not retried on another backend; wrap backends in a
+// Fallback for that.
+type RoundRobin struct {
+	providers []Provider
+	next      atomic.Uint64
+}
+
+// NewRoundRobin creates a provider that rotates through providers.
+func NewRoundRobin(providers ...Provider) *RoundRobin {
+	return &RoundRobin{providers: providers}
+}
+
+// Prompt sends the prompt to the next backend in turn.
+func (r *RoundRobin) Prompt(system, user string) (string, error) {
+	if len(r.providers) == 0 {
+		return "", allFailed("round robin", nil)
+	}
+	n := r.next.Add(1) - 1
+	return r.providers[n%uint64(len(r.providers))].Prompt(system, user)
+}
+
+// PromptOptions is Prompt with opts applied to the backend.
+func (r *RoundRobin) PromptOptions(system, user string, opts Options) (string, error) {
+	if len(r.providers) == 0 {
+		return "", allFailed("round robin", nil)
+	}
+	n := r.next.Add(1) - 1
+	return promptOptions(r.providers[n%uint64(len(r.providers))], system, user, opts)
+}
+
+// Embed sends the texts to the next backend in turn that supports
+// embeddings.
+func (r *RoundRobin) Embed(texts []string) ([][]float32, error) {
+	var embedders []EmbeddingProvider
+	for _, p := range r.providers {
+		if ep, ok := p.(EmbeddingProvider); ok {
+			embedders = append(embedders, ep)
+		}
+	}
+	if len(embedders) == 0 {
+		return nil, allFailed("round robin", nil)
+	}
+	n := r.next.Add(1) - 1
+	return embedders[n%uint64(len(embedders))].Embed(texts)
+}
+
+// promptOptions prompts p with opts if it takes them, and as a plain
+// Prompt otherwise.
+func promptOptions(p Provider, system, user string, opts Options) (string, error) {
+	if op, ok := p.(OptionsProvider); ok {
+		return op.PromptOptions(system, user, opts)
+	}
+	return p.Prompt(system, user)
+}
+
+// allFailed reports that no backend of a composite provider could serve a
+// call.
+func allFailed(name string, errs []error) error {
+	if len(errs) == 0 {
+		return fmt.Errorf("%s: no backend available", name)
+	}
+	return fmt.Errorf("%s: all %d backends failed: %w", name, len(errs), errors.Join(errs...))
+}
@@ -3,12 +3,15 @@
 
 package provider
 
+import "sync"
+
 // Mock is a mock provider for testing.
 type Mock struct {
 	Response string
 	Handler  func(system, user string) string
 	model    string
 	params   map[string]string
+	mu       sync.RWMutex // Guards model and params
 }
 
 // NewMock creates a new mock provider with a fixed response.
@@ -30,16 +33,32 @@ func (m *Mock) Prompt(system, user string) (string, error) {
 }
 
 // GetParam returns an inference parameter value.
-func (m *Mock) GetParam(key string) string { return m.params[key] }
+func (m *Mock) GetParam(key string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.params[key]
+}
 
 // SetParam sets an inference parameter value.
-func (m *Mock) SetParam(key, value string) { m.params[key] = value }
+func (m *Mock) SetParam(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.params[key] = value
+}
 
 // GetModel returns the current model name.
-func (m *Mock) GetModel() string { return m.model }
+func (m *Mock) GetModel() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.model
+}
 
 // SetModel sets the model name.
-func (m *Mock) SetModel(model string) { m.model = model }
+func (m *Mock) SetModel(model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.model = model
+}
 
 // ProviderName returns "MOCK".
 func (m *Mock) ProviderName() string { return "MOCK" }
@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"sync"
 	"time"
 )
 
@@ -20,6 +20,7 @@ type Ollama struct {
 	Timeout  time.Duration
 	StreamCb StreamCallback
 	params   map[string]string
+	mu       sync.RWMutex // Guards Model, StreamCb and params, which evaluator clones and async tasks share
 }
 
 // OllamaOption configures the Ollama provider.
@@ -60,26 +61,57 @@ func NewOllama(opts ...OllamaOption) *Ollama {
 }
 
 // GetParam returns an inference parameter value.
-func (o *Ollama) GetParam(key string) string { return o.params[key] }
+func (o *Ollama) GetParam(key string) string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.params[key]
+}
 
 // SetParam sets an inference parameter value.
-func (o *Ollama) SetParam(key, value string) { o.params[key] = value }
+func (o *Ollama) SetParam(key, value string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.params[key] = value
+}
 
 // GetModel returns the current model name.
-func (o *Ollama) GetModel() string { return o.Model }
+func (o *Ollama) GetModel() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.Model
+}
 
 // SetModel sets the model name.
-func (o *Ollama) SetModel(model string) { o.Model = model }
+func (o *Ollama) SetModel(model string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Model = model
+}
+
+// settings returns what one prompt runs with.
+func (o *Ollama) settings(opts Options) callSettings {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return newCallSettings(o.params, o.Model, o.StreamCb, opts)
+}
 
 // ProviderName returns "OLLAMA".
 func (o *Ollama) ProviderName() string { return "OLLAMA" }
 
+// SetStreamCallback replaces the streaming callback; nil stops streaming.
+func (o *Ollama) SetStreamCallback(cb func(token string)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.StreamCb = cb
+}
+
 type ollamaRequest struct {
 	Model     string                 `json:"model"`
 	Messages  []ollamaMessage        `json:"messages"`
 	Stream    bool                   `json:"stream"`
 	Think     *bool                  `json:"think,omitempty"`
 	Options   map[string]interface{} `json:"options,omitempty"`
+	Format    json.RawMessage        `json:"format,omitempty"`
 	KeepAlive string                 `json:"keep_alive,omitempty"`
 }
 
@@ -95,6 +127,31 @@ type ollamaResponse struct {
 
 // Prompt sends a prompt to Ollama and returns the response.
 func (o *Ollama) Prompt(system, user string) (string, error) {
+	return o.chat(system, user, nil, Options{})
+}
+
+// PromptOptions sends a prompt adjusted by opts.
+func (o *Ollama) PromptOptions(system, user string, opts Options) (string, error) {
+	return o.chat(system, user, nil, opts)
+}
+
+// PromptSchema sends a prompt whose response is constrained to the given
+// JSON schema via Ollama's format field.
+func (o *Ollama) PromptSchema(system, user, schema string) (string, error) {
+	if !json.Valid([]byte(schema)) {
+		return "", fmt.Errorf("ollama: invalid JSON schema")
+	}
+	return o.chat(system, user, json.RawMessage(schema), Options{})
+}
+
+func (o *Ollama) chat(system, user string, format json.RawMessage, opts Options) (string, error) {
+	cs := o.settings(opts)
+	return retryOnEmpty("ollama", cs.params, false, func() (string, error) {
+		return o.chatOnce(system, user, format, cs)
+	})
+}
+
+func (o *Ollama) chatOnce(system, user string, format json.RawMessage, cs callSettings) (string, error) {
 	messages := []ollamaMessage{}
 	if system != "" {
 		messages = append(messages, ollamaMessage{Role: "system", Content: system})
@@ -102,39 +159,30 @@ func (o *Ollama) Prompt(system, user string) (string, error) {
 	messages = append(messages, ollamaMessage{Role: "user", Content: user})
 
 	options := map[string]interface{}{"num_ctx": 16384}
-	if v, ok := o.params["NUM_CTX"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			options["num_ctx"] = n
-		}
+	if n := intParam(cs.params, "NUM_CTX"); n != nil {
+		options["num_ctx"] = *n
 	}
-	if v, ok := o.params["TEMPERATURE"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			options["temperature"] = f
-		}
+	if f := floatParam(cs.params, "TEMPERATURE"); f != nil {
+		options["temperature"] = *f
 	}
-	if v, ok := o.params["TOP_K"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			options["top_k"] = n
-		}
+	if n := intParam(cs.params, "TOP_K"); n != nil {
+		options["top_k"] = *n
 	}
-	if v, ok := o.params["TOP_P"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			options["top_p"] = f
-		}
+	if f := floatParam(cs.params, "TOP_P"); f != nil {
+		options["top_p"] = *f
 	}
-	if v, ok := o.params["MAX_TOKENS"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			options["num_predict"] = n
-		}
+	if n := intParam(cs.params, "MAX_TOKENS"); n != nil {
+		options["num_predict"] = *n
 	}
 
 	thinkFalse := false
 	reqBody := ollamaRequest{
-		Model:     o.Model,
+		Model:     chatModel(cs.params, cs.model),
 		Messages:  messages,
-		Stream:    o.StreamCb != nil,
+		Stream:    cs.stream != nil,
 		Think:     &thinkFalse,
 		Options:   options,
+		Format:    format,
 		KeepAlive: "5m",
 	}
 
@@ -155,8 +203,8 @@ func (o *Ollama) Prompt(system, user string) (string, error) {
 		return "", fmt.Errorf("ollama error: %s", string(body))
 	}
 
-	if o.StreamCb != nil {
-		return o.readStream(resp.Body)
+	if cs.stream != nil {
+		return o.readStream(resp.Body, cs.stream)
 	}
 
 	var result ollamaResponse
@@ -178,7 +226,7 @@ type ollamaEmbedResponse struct {
 
 // Embed generates embeddings for the given texts using Ollama's /api/embed endpoint.
 func (o *Ollama) Embed(texts []string) ([][]float32, error) {
-	model := o.params["EMBED_MODEL"]
+	model := o.GetParam("EMBED_MODEL")
 	if model == "" {
 		model = "qwen3-embedding:0.6b"
 	}
@@ -212,8 +260,9 @@ func (o *Ollama) Embed(texts []string) ([][]float32, error) {
 	return result.Embeddings, nil
 }
 
-func (o *Ollama) readStream(body io.Reader) (string, error) {
+func (o *Ollama) readStream(body io.Reader, cb StreamCallback) (string, error) {
 	decoder := json.NewDecoder(body)
+	out := &runeBuffer{cb: cb}
 	var fullResponse bytes.Buffer
 
 	for {
@@ -227,8 +276,8 @@ func (o *Ollama) readStream(body io.Reader) (string, error) {
 		content := chunk.Message.Content
 		fullResponse.WriteString(content)
 
-		if o.StreamCb != nil {
-			o.StreamCb(content)
+		if cb != nil {
+			out.Write(content)
 		}
 
 		if chunk.Done {
@@ -236,5 +285,8 @@ func (o *Ollama) readStream(body io.Reader) (string, error) {
 		}
 	}
 
+	if cb != nil {
+		out.Flush()
+	}
 	return fullResponse.String(), nil
 }
@@ -11,23 +11,30 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // OpenRouter is a provider for OpenRouter API.
 type OpenRouter struct {
+	URL      string
 	APIKey   string
 	Model    string
 	Timeout  time.Duration
 	StreamCb StreamCallback
 	params   map[string]string
+	mu       sync.RWMutex // Guards Model, StreamCb and params, which evaluator clones and async tasks share
 }
 
 // OpenRouterOption configures the OpenRouter provider.
 type OpenRouterOption func(*OpenRouter)
 
+// WithOpenRouterURL sets the API base URL.
+func WithOpenRouterURL(url string) OpenRouterOption {
+	return func(o *OpenRouter) { o.URL = url }
+}
+
 // WithOpenRouterAPIKey sets the API key.
 func WithOpenRouterAPIKey(key string) OpenRouterOption {
 	return func(o *OpenRouter) { o.APIKey = key }
@@ -51,6 +58,7 @@ func WithOpenRouterStreamCallback(cb StreamCallback) OpenRouterOption {
 // NewOpenRouter creates a new OpenRouter provider.
 func NewOpenRouter(opts ...OpenRouterOption) *OpenRouter {
 	o := &OpenRouter{
+		URL:     "https://openrouter.ai/api/v1",
 		APIKey:  os.Getenv("OPEN_ROUTER_API_KEY"),
 		Model:   "z-ai/glm-4.5-air:free",
 		Timeout: 5 * time.Minute,
@@ -63,20 +71,50 @@ func NewOpenRouter(opts ...OpenRouterOption) *OpenRouter {
 }
 
 // GetParam returns an inference parameter value.
-func (o *OpenRouter) GetParam(key string) string { return o.params[key] }
+func (o *OpenRouter) GetParam(key string) string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.params[key]
+}
 
 // SetParam sets an inference parameter value.
-func (o *OpenRouter) SetParam(key, value string) { o.params[key] = value }
+func (o *OpenRouter) SetParam(key, value string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.params[key] = value
+}
 
 // GetModel returns the current model name.
-func (o *OpenRouter) GetModel() string { return o.Model }
+func (o *OpenRouter) GetModel() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.Model
+}
 
 // SetModel sets the model name.
-func (o *OpenRouter) SetModel(model string) { o.Model = model }
+func (o *OpenRouter) SetModel(model string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Model = model
+}
+
+// settings returns what one prompt runs with.
+func (o *OpenRouter) settings(opts Options) callSettings {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return newCallSettings(o.params, o.Model, o.StreamCb, opts)
+}
 
 // ProviderName returns "OPENROUTER".
 func (o *OpenRouter) ProviderName() string { return "OPENROUTER" }
 
+// SetStreamCallback replaces the streaming callback; nil stops streaming.
+func (o *OpenRouter) SetStreamCallback(cb func(token string)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.StreamCb = cb
+}
+
 type openRouterRequest struct {
 	Model       string              `json:"model"`
 	Messages    []openRouterMessage `json:"messages"`
@@ -85,6 +123,17 @@ type openRouterRequest struct {
 	TopP        *float64            `json:"top_p,omitempty"`
 	TopK        *int                `json:"top_k,omitempty"`
 	MaxTokens   *int                `json:"max_tokens,omitempty"`
+
+	ResponseFormat *openRouterResponseFormat `json:"response_format,omitempty"`
+}
+
+type openRouterResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Strict bool            `json:"strict"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema"`
 }
 
 type openRouterMessage struct {
@@ -101,29 +150,40 @@ type openRouterResponse struct {
 
 // Prompt sends a prompt to OpenRouter and returns the response.
 func (o *OpenRouter) Prompt(system, user string) (string, error) {
+	return o.promptRetry(system, user, nil, Options{})
+}
+
+// PromptOptions sends a prompt adjusted by opts.
+func (o *OpenRouter) PromptOptions(system, user string, opts Options) (string, error) {
+	return o.promptRetry(system, user, nil, opts)
+}
+
+// PromptSchema sends a prompt whose response is constrained to the given
+// JSON schema via response_format.
+func (o *OpenRouter) PromptSchema(system, user, schema string) (string, error) {
+	if !json.Valid([]byte(schema)) {
+		return "", fmt.Errorf("openrouter: invalid JSON schema")
+	}
+	format := &openRouterResponseFormat{Type: "json_schema"}
+	format.JSONSchema.Name = "response"
+	format.JSONSchema.Strict = true
+	format.JSONSchema.Schema = json.RawMessage(schema)
+	return o.promptRetry(system, user, format, Options{})
+}
+
+func (o *OpenRouter) promptRetry(system, user string, format *openRouterResponseFormat, opts Options) (string, error) {
 	if o.APIKey == "" {
 		return "", fmt.Errorf("OPEN_ROUTER_API_KEY not set")
 	}
 
-	// Retry up to 3 times on empty responses (free tier rate limiting)
-	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		result, err := o.promptOnce(system, user)
-		if err != nil {
-			lastErr = err
-			time.Sleep(time.Duration(attempt+1) * time.Second)
-			continue
-		}
-		if result != "" {
-			return result, nil
-		}
-		lastErr = fmt.Errorf("empty response")
-		time.Sleep(time.Duration(attempt+1) * time.Second)
-	}
-	return "", fmt.Errorf("openrouter: failed after 3 attempts: %v", lastErr)
+	// Errors are retried too: the free tier signals rate limiting that way
+	cs := o.settings(opts)
+	return retryOnEmpty("openrouter", cs.params, true, func() (string, error) {
+		return o.promptOnce(system, user, format, cs)
+	})
 }
 
-func (o *OpenRouter) promptOnce(system, user string) (string, error) {
+func (o *OpenRouter) promptOnce(system, user string, format *openRouterResponseFormat, cs callSettings) (string, error) {
 	// Combine system and user into single user message
 	// Many free models don't support system prompts
 	combinedUser := user
@@ -135,29 +195,14 @@ func (o *OpenRouter) promptOnce(system, user string) (string, error) {
 	messages = append(messages, openRouterMessage{Role: "user", Content: combinedUser})
 
 	reqBody := openRouterRequest{
-		Model:    o.Model,
-		Messages: messages,
-		Stream:   o.StreamCb != nil,
-	}
-	if v, ok := o.params["TEMPERATURE"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			reqBody.Temperature = &f
-		}
-	}
-	if v, ok := o.params["TOP_P"]; ok {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			reqBody.TopP = &f
-		}
-	}
-	if v, ok := o.params["TOP_K"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			reqBody.TopK = &n
-		}
-	}
-	if v, ok := o.params["MAX_TOKENS"]; ok {
-		if n, err := strconv.Atoi(v); err == nil {
-			reqBody.MaxTokens = &n
-		}
+		Model:          chatModel(cs.params, cs.model),
+		Messages:       messages,
+		Stream:         cs.stream != nil,
+		Temperature:    floatParam(cs.params, "TEMPERATURE"),
+		TopP:           floatParam(cs.params, "TOP_P"),
+		TopK:           intParam(cs.params, "TOP_K"),
+		MaxTokens:      intParam(cs.params, "MAX_TOKENS"),
+		ResponseFormat: format,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -165,7 +210,7 @@ func (o *OpenRouter) promptOnce(system, user string) (string, error) {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewReader(jsonBody))
+	req, err := http.NewRequest("POST", o.URL+"/chat/completions", bytes.NewReader(jsonBody))
 	if err != nil {
 		return "", err
 	}
@@ -185,8 +230,8 @@ func (o *OpenRouter) promptOnce(system, user string) (string, error) {
 		return "", fmt.Errorf("openrouter error: %s", string(body))
 	}
 
-	if o.StreamCb != nil {
-		return o.readStream(resp.Body)
+	if cs.stream != nil {
+		return o.readStream(resp.Body, cs.stream)
 	}
 
 	var result openRouterResponse
@@ -219,9 +264,10 @@ func (o *OpenRouter) Embed(texts []string) ([][]float32, error) {
 		return nil, fmt.Errorf("OPEN_ROUTER_API_KEY not set")
 	}
 
-	model := o.params["EMBED_MODEL"]
-	if model == "" {
-		model = o.Model
+	cs := o.settings(Options{})
+	model := cs.model
+	if m := cs.params["EMBED_MODEL"]; m != "" {
+		model = m
 	}
 	reqBody := openRouterEmbedRequest{
 		Model: model,
@@ -233,7 +279,7 @@ func (o *OpenRouter) Embed(texts []string) ([][]float32, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/embeddings", bytes.NewReader(jsonBody))
+	req, err := http.NewRequest("POST", o.URL+"/embeddings", bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, err
 	}
@@ -269,8 +315,9 @@ func (o *OpenRouter) Embed(texts []string) ([][]float32, error) {
 	return embeddings, nil
 }
 
-func (o *OpenRouter) readStream(body io.Reader) (string, error) {
+func (o *OpenRouter) readStream(body io.Reader, cb StreamCallback) (string, error) {
 	scanner := bufio.NewScanner(body)
+	out := &runeBuffer{cb: cb}
 	var fullResponse strings.Builder
 
 	for scanner.Scan() {
@@ -293,11 +340,14 @@ func (o *OpenRouter) readStream(body io.Reader) (string, error) {
 			content := chunk.Choices[0].Delta.Content
 			fullResponse.WriteString(content)
 
-			if o.StreamCb != nil {
-				o.StreamCb(content)
+			if cb != nil {
+				out.Write(content)
 			}
 		}
 	}
 
+	if cb != nil {
+		out.Flush()
+	}
 	return fullResponse.String(), scanner.Err()
 }
@@ -4,6 +4,14 @@
 // Package provider defines LLM provider interfaces and implementations.
 package provider
 
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
 // Provider is the interface for LLM providers.
 type Provider interface {
 	// Prompt sends a prompt to the LLM and returns the response.
@@ -19,6 +27,134 @@ type Configurable interface {
 	ProviderName() string
 }
 
+// Inference params are set with SYSTEM and shared by every provider. Each
+// provider maps the ones its API supports onto its request and ignores the
+// rest, as well as any value that doesn't parse:
+//
+//	param        Ollama                Anthropic          OpenRouter
+//	TEMPERATURE  options.temperature   temperature        temperature
+//	TOP_K        options.top_k         top_k              top_k
+//	TOP_P        options.top_p         top_p              top_p
+//	MAX_TOKENS   options.num_predict   max_tokens (4096)  max_tokens
+//	NUM_CTX      options.num_ctx       -                  -
+//
+// ClaudeCLI supports none of them.
+
+// intParam returns params[key] as an int, or nil if it is unset or not an
+// integer.
+func intParam(params map[string]string, key string) *int {
+	n, err := strconv.Atoi(params[key])
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// floatParam returns params[key] as a float64, or nil if it is unset or not
+// a number.
+func floatParam(params map[string]string, key string) *float64 {
+	f, err := strconv.ParseFloat(params[key], 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// chatModel returns the model for PROMPT-style calls: the CHAT_MODEL param
+// if set, otherwise the provider's default model.
+func chatModel(params map[string]string, model string) string {
+	if m := params["CHAT_MODEL"]; m != "" {
+		return m
+	}
+	return model
+}
+
+// DefaultRetryOnEmpty is how many times a prompt is retried after an empty
+// response when the RETRY_ON_EMPTY param is not set.
+const DefaultRetryOnEmpty = 2
+
+// retryDelay is the backoff unit between attempts: retry n waits n units.
+var retryDelay = time.Second
+
+// retryOnEmpty calls prompt until it returns a non-empty response, retrying
+// up to RETRY_ON_EMPTY times. Errors end the loop at once unless
+// retryErrors is set. When the attempts run out, the last one's result is
+// returned: an empty response as-is, so the program sees EMPTY rather than
+// an error, or the error that ended it.
+func retryOnEmpty(name string, params map[string]string, retryErrors bool, prompt func() (string, error)) (string, error) {
+	retries := DefaultRetryOnEmpty
+	if v, ok := params["RETRY_ON_EMPTY"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			retries = n
+		}
+	}
+	if retries == 0 {
+		return prompt()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * retryDelay)
+		}
+		result, err := prompt()
+		if err != nil {
+			if !retryErrors {
+				return "", err
+			}
+			lastErr = err
+			continue
+		}
+		if result != "" {
+			return result, nil
+		}
+		lastErr = nil
+	}
+	if lastErr != nil {
+		return "", fmt.Errorf("%s: failed after %d attempts: %v", name, retries+1, lastErr)
+	}
+	return "", nil
+}
+
+// Options adjust a single prompt. The provider's own settings stay as they
+// were, so concurrent prompts sharing it don't see each other's.
+type Options struct {
+	Params map[string]string // Inference params applied over those set with SetParam
+	Stream StreamCallback    // Receives this prompt's tokens instead of the provider's callback
+}
+
+// OptionsProvider is implemented by providers that can take Options for a
+// single prompt.
+type OptionsProvider interface {
+	PromptOptions(system, user string, opts Options) (string, error)
+}
+
+// callSettings is what one prompt runs with: a copy of the provider's
+// settings with the prompt's Options applied, so a SYSTEM change made while
+// it runs doesn't affect it.
+type callSettings struct {
+	params map[string]string
+	model  string
+	stream StreamCallback
+}
+
+// newCallSettings applies opts over a provider's settings. The caller must
+// hold the provider's lock.
+func newCallSettings(params map[string]string, model string, stream StreamCallback, opts Options) callSettings {
+	cs := callSettings{params: maps.Clone(params), model: model, stream: stream}
+	maps.Copy(cs.params, opts.Params)
+	if opts.Stream != nil {
+		cs.stream = opts.Stream
+	}
+	return cs
+}
+
+// StructuredProvider is implemented by providers that can constrain a
+// response to a JSON schema.
+type StructuredProvider interface {
+	PromptSchema(system, user, schema string) (string, error)
+}
+
 // EmbeddingProvider generates vector embeddings from text.
 type EmbeddingProvider interface {
 	Embed(texts []string) ([][]float32, error)
@@ -26,3 +162,44 @@ type EmbeddingProvider interface {
 
 // StreamCallback is called with each token during streaming.
 type StreamCallback func(token string)
+
+// runeBuffer forwards streamed text to a StreamCallback, holding back an
+// incomplete trailing UTF-8 sequence until the next chunk completes it, so
+// the callback never sees a multibyte character split in two.
+type runeBuffer struct {
+	cb      StreamCallback
+	pending []byte
+}
+
+// Write forwards every complete character in pending+s.
+func (b *runeBuffer) Write(s string) {
+	data := append(b.pending, s...)
+	n := completeUTF8Prefix(data)
+	b.pending = append([]byte(nil), data[n:]...)
+	if n > 0 {
+		b.cb(string(data[:n]))
+	}
+}
+
+// Flush forwards whatever is still held back, complete or not.
+func (b *runeBuffer) Flush() {
+	if len(b.pending) > 0 {
+		b.cb(string(b.pending))
+		b.pending = nil
+	}
+}
+
+// completeUTF8Prefix returns the length of data without a trailing
+// incomplete UTF-8 sequence.
+func completeUTF8Prefix(data []byte) int {
+	// Find the start of the last character (at most UTFMax-1 continuation bytes back)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
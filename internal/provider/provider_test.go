@@ -0,0 +1,475 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRuneBufferSplitCharacter(t *testing.T) {
+	var got []string
+	out := &runeBuffer{cb: func(token string) { got = append(got, token) }}
+
+	// "▶" is three bytes (e2 96 b6); split it across two chunks
+	word := "go ▶ now"
+	split := len("go ") + 2
+	out.Write(word[:split])
+	out.Write(word[split:])
+	out.Flush()
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 callbacks, got %d: %q", len(got), got)
+	}
+	if got[0] != "go " || got[1] != "▶ now" {
+		t.Errorf("expected [\"go \" \"▶ now\"], got %q", got)
+	}
+	for _, token := range got {
+		if !utf8.ValidString(token) {
+			t.Errorf("callback received invalid UTF-8: %q", token)
+		}
+	}
+}
+
+func TestRuneBufferFlushIncomplete(t *testing.T) {
+	var got string
+	out := &runeBuffer{cb: func(token string) { got += token }}
+
+	// A stream that ends mid-character still delivers its bytes on Flush
+	out.Write("ok \xe2\x96")
+	if got != "ok " {
+		t.Errorf("expected incomplete rune held back, got %q", got)
+	}
+	out.Flush()
+	if got != "ok \xe2\x96" {
+		t.Errorf("expected held bytes on flush, got %q", got)
+	}
+}
+
+// flakyServer answers with an empty completion for the first `empties`
+// requests, then with "hello". body formats a completion in the provider's
+// response shape.
+func flakyServer(t *testing.T, empties int, body func(content string) string) (*httptest.Server, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		content := "hello"
+		if calls <= empties {
+			content = ""
+		}
+		fmt.Fprint(w, body(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetryOnEmpty(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+
+	providers := map[string]struct {
+		body func(content string) string
+		make func(url string) Provider
+	}{
+		"ollama": {
+			body: func(c string) string { return fmt.Sprintf(`{"message": {"role": "assistant", "content": %q}, "done": true}`, c) },
+			make: func(url string) Provider { return NewOllama(WithOllamaURL(url)) },
+		},
+		"anthropic": {
+			body: func(c string) string { return fmt.Sprintf(`{"content": [{"type": "text", "text": %q}]}`, c) },
+			make: func(url string) Provider { return NewAnthropic(WithAnthropicURL(url), WithAnthropicAPIKey("k")) },
+		},
+		"openrouter": {
+			body: func(c string) string { return fmt.Sprintf(`{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, c) },
+			make: func(url string) Provider { return NewOpenRouter(WithOpenRouterURL(url), WithOpenRouterAPIKey("k")) },
+		},
+	}
+
+	for name, p := range providers {
+		// Empty twice, then content: the default two retries are enough
+		srv, calls := flakyServer(t, 2, p.body)
+		got, err := p.make(srv.URL).Prompt("", "hi")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if got != "hello" || *calls != 3 {
+			t.Errorf("%s: expected 'hello' on the 3rd call, got %q after %d calls", name, got, *calls)
+		}
+
+		// One retry isn't: giving up returns the empty response, not an error
+		srv, calls = flakyServer(t, 2, p.body)
+		prov := p.make(srv.URL)
+		prov.(Configurable).SetParam("RETRY_ON_EMPTY", "1")
+		if got, err := prov.Prompt("", "hi"); err != nil || got != "" || *calls != 2 {
+			t.Errorf("%s: expected an empty response after 2 calls, got %q, err=%v after %d calls", name, got, err, *calls)
+		}
+
+		// 0 disables retrying and passes the empty response through
+		srv, calls = flakyServer(t, 2, p.body)
+		prov = p.make(srv.URL)
+		prov.(Configurable).SetParam("RETRY_ON_EMPTY", "0")
+		if got, err := prov.Prompt("", "hi"); err != nil || got != "" || *calls != 1 {
+			t.Errorf("%s: expected one empty response, got %q, err=%v after %d calls", name, got, err, *calls)
+		}
+	}
+}
+
+// captureServer answers every request with body("hello") and records the
+// JSON of the last request it received.
+func captureServer(t *testing.T, body func(content string) string) (*httptest.Server, *map[string]any) {
+	t.Helper()
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprint(w, body("hello"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func TestInferenceParams(t *testing.T) {
+	params := map[string]string{
+		"TEMPERATURE": "0.5",
+		"TOP_K":       "40",
+		"TOP_P":       "0.9",
+		"MAX_TOKENS":  "256",
+		"NUM_CTX":     "8192",
+	}
+
+	tests := []struct {
+		name   string
+		body   func(content string) string
+		make   func(url string) Provider
+		fields func(req map[string]any) map[string]any
+		want   map[string]any
+	}{
+		{
+			name:   "ollama",
+			body:   func(c string) string { return fmt.Sprintf(`{"message": {"role": "assistant", "content": %q}, "done": true}`, c) },
+			make:   func(url string) Provider { return NewOllama(WithOllamaURL(url)) },
+			fields: func(req map[string]any) map[string]any { m, _ := req["options"].(map[string]any); return m },
+			want:   map[string]any{"temperature": 0.5, "top_k": 40.0, "top_p": 0.9, "num_predict": 256.0, "num_ctx": 8192.0},
+		},
+		{
+			name:   "anthropic",
+			body:   func(c string) string { return fmt.Sprintf(`{"content": [{"type": "text", "text": %q}]}`, c) },
+			make:   func(url string) Provider { return NewAnthropic(WithAnthropicURL(url), WithAnthropicAPIKey("k")) },
+			fields: func(req map[string]any) map[string]any { return req },
+			want:   map[string]any{"temperature": 0.5, "top_k": 40.0, "top_p": 0.9, "max_tokens": 256.0},
+		},
+		{
+			name:   "openrouter",
+			body:   func(c string) string { return fmt.Sprintf(`{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, c) },
+			make:   func(url string) Provider { return NewOpenRouter(WithOpenRouterURL(url), WithOpenRouterAPIKey("k")) },
+			fields: func(req map[string]any) map[string]any { return req },
+			want:   map[string]any{"temperature": 0.5, "top_k": 40.0, "top_p": 0.9, "max_tokens": 256.0},
+		},
+	}
+
+	for _, tt := range tests {
+		srv, req := captureServer(t, tt.body)
+		prov := tt.make(srv.URL)
+		for k, v := range params {
+			prov.(Configurable).SetParam(k, v)
+		}
+		if _, err := prov.Prompt("", "hi"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		fields := tt.fields(*req)
+		for k, v := range tt.want {
+			if fields[k] != v {
+				t.Errorf("%s: expected %s=%v, got %v", tt.name, k, v, fields[k])
+			}
+		}
+		// NUM_CTX has no equivalent outside Ollama and is left out
+		if _, ok := (*req)["num_ctx"]; ok {
+			t.Errorf("%s: expected no top-level num_ctx", tt.name)
+		}
+
+		// Params passed with a prompt apply to that request only
+		if _, err := prov.(OptionsProvider).PromptOptions("", "hi", Options{Params: map[string]string{"TEMPERATURE": "0.1"}}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		fields = tt.fields(*req)
+		if fields["temperature"] != 0.1 || fields["top_k"] != 40.0 {
+			t.Errorf("%s: expected temperature 0.1 over the other params, got %v", tt.name, fields)
+		}
+		if got := prov.(Configurable).GetParam("TEMPERATURE"); got != "0.5" {
+			t.Errorf("%s: expected TEMPERATURE to stay 0.5, got %q", tt.name, got)
+		}
+
+		// Unparseable values are ignored, like unsupported params
+		srv, req = captureServer(t, tt.body)
+		prov = tt.make(srv.URL)
+		prov.(Configurable).SetParam("TEMPERATURE", "warm")
+		if _, err := prov.Prompt("", "hi"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if _, ok := tt.fields(*req)["temperature"]; ok {
+			t.Errorf("%s: expected an invalid TEMPERATURE to be left out", tt.name)
+		}
+	}
+
+	// Anthropic requires max_tokens, so it has a default
+	srv, req := captureServer(t, func(c string) string { return fmt.Sprintf(`{"content": [{"type": "text", "text": %q}]}`, c) })
+	if _, err := NewAnthropic(WithAnthropicURL(srv.URL), WithAnthropicAPIKey("k")).Prompt("", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if (*req)["max_tokens"] != 4096.0 {
+		t.Errorf("expected default max_tokens 4096, got %v", (*req)["max_tokens"])
+	}
+}
+
+func TestModelPerOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      func(content string) string
+		embedBody string // empty when the provider has no embeddings
+		make      func(url string) Provider
+	}{
+		{
+			name:      "ollama",
+			body:      func(c string) string { return fmt.Sprintf(`{"message": {"role": "assistant", "content": %q}, "done": true}`, c) },
+			embedBody: `{"embeddings": [[1]]}`,
+			make:      func(url string) Provider { return NewOllama(WithOllamaURL(url)) },
+		},
+		{
+			name: "anthropic",
+			body: func(c string) string { return fmt.Sprintf(`{"content": [{"type": "text", "text": %q}]}`, c) },
+			make: func(url string) Provider { return NewAnthropic(WithAnthropicURL(url), WithAnthropicAPIKey("k")) },
+		},
+		{
+			name:      "openrouter",
+			body:      func(c string) string { return fmt.Sprintf(`{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, c) },
+			embedBody: `{"data": [{"embedding": [1], "index": 0}]}`,
+			make:      func(url string) Provider { return NewOpenRouter(WithOpenRouterURL(url), WithOpenRouterAPIKey("k")) },
+		},
+	}
+
+	for _, tt := range tests {
+		srv, req := captureServer(t, tt.body)
+		prov := tt.make(srv.URL)
+		cfg := prov.(Configurable)
+		cfg.SetModel("default-model")
+
+		// Without CHAT_MODEL, prompts use the provider's model
+		if _, err := prov.Prompt("", "hi"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if (*req)["model"] != "default-model" {
+			t.Errorf("%s: expected model 'default-model', got %v", tt.name, (*req)["model"])
+		}
+
+		cfg.SetParam("CHAT_MODEL", "chat-model")
+		cfg.SetParam("EMBED_MODEL", "embed-model")
+		if _, err := prov.Prompt("", "hi"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if (*req)["model"] != "chat-model" {
+			t.Errorf("%s: expected CHAT_MODEL 'chat-model', got %v", tt.name, (*req)["model"])
+		}
+
+		// A CHAT_MODEL passed with a prompt applies to that request only
+		if _, err := prov.(OptionsProvider).PromptOptions("", "hi", Options{Params: map[string]string{"CHAT_MODEL": "call-model"}}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if (*req)["model"] != "call-model" {
+			t.Errorf("%s: expected per-call model 'call-model', got %v", tt.name, (*req)["model"])
+		}
+
+		if tt.embedBody == "" {
+			continue
+		}
+		srv, req = captureServer(t, func(string) string { return tt.embedBody })
+		prov = tt.make(srv.URL)
+		cfg = prov.(Configurable)
+		cfg.SetModel("default-model")
+		cfg.SetParam("CHAT_MODEL", "chat-model")
+		cfg.SetParam("EMBED_MODEL", "embed-model")
+		if _, err := prov.(EmbeddingProvider).Embed([]string{"hi"}); err != nil {
+			t.Fatalf("%s: unexpected embed error: %v", tt.name, err)
+		}
+		if (*req)["model"] != "embed-model" {
+			t.Errorf("%s: expected EMBED_MODEL 'embed-model', got %v", tt.name, (*req)["model"])
+		}
+	}
+}
+
+// stubBackend answers prompts and embeddings with its name, or fails.
+type stubBackend struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (s *stubBackend) Prompt(system, user string) (string, error) {
+	s.calls++
+	return s.name, s.err
+}
+
+func (s *stubBackend) Embed(texts []string) ([][]float32, error) {
+	s.calls++
+	return [][]float32{{float32(len(s.name))}}, s.err
+}
+
+func TestFallback(t *testing.T) {
+	down := &stubBackend{name: "a", err: fmt.Errorf("connection refused")}
+	up := &stubBackend{name: "b"}
+	f := NewFallback(down, up)
+
+	if got, err := f.Prompt("", "hi"); err != nil || got != "b" {
+		t.Errorf("expected the second backend's answer, got %q, err=%v", got, err)
+	}
+	if down.calls != 1 || up.calls != 1 {
+		t.Errorf("expected one call to each backend, got %d and %d", down.calls, up.calls)
+	}
+	if _, err := f.Embed([]string{"x"}); err != nil {
+		t.Errorf("expected Embed to fall back, got %v", err)
+	}
+
+	// A backend without embeddings is skipped rather than counted as a failure
+	if _, err := NewFallback(NewMock("ok"), up).Embed([]string{"x"}); err != nil {
+		t.Errorf("expected Embed to skip the mock, got %v", err)
+	}
+
+	// With every backend down, each error is kept
+	_, err := NewFallback(down, &stubBackend{err: fmt.Errorf("timeout")}).Prompt("", "hi")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected both backend errors, got %v", err)
+	}
+	if _, err := NewFallback().Prompt("", "hi"); err == nil {
+		t.Error("expected an error with no backends")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	backends := []*stubBackend{{name: "a"}, {name: "b"}, {name: "c"}}
+	r := NewRoundRobin(backends[0], backends[1], backends[2])
+
+	var got []string
+	for range 6 {
+		s, err := r.Prompt("", "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	if strings.Join(got, "") != "abcabc" {
+		t.Errorf("expected calls to rotate, got %v", got)
+	}
+	for _, b := range backends {
+		if b.calls != 2 {
+			t.Errorf("expected backend %s to get 2 calls, got %d", b.name, b.calls)
+		}
+	}
+
+	if _, err := NewRoundRobin(NewMock("ok")).Embed([]string{"x"}); err == nil {
+		t.Error("expected an error with no embedding backends")
+	}
+}
+
+// configuredStub is a stubBackend with a model and params, as a provider
+// set up with SYSTEM has.
+type configuredStub struct {
+	stubBackend
+	model  string
+	params map[string]string
+}
+
+func (c *configuredStub) GetParam(key string) string { return c.params[key] }
+func (c *configuredStub) SetParam(key, value string) { c.params[key] = value }
+func (c *configuredStub) GetModel() string           { return c.model }
+func (c *configuredStub) SetModel(model string)      { c.model = model }
+func (c *configuredStub) ProviderName() string       { return "STUB" }
+
+func TestVCR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	live := NewMockHandler(func(system, user string) string { return system + "|" + user })
+
+	rec := NewVCR(live, path)
+	var recorded []string
+	for _, user := range []string{"hello", "world", "hello"} {
+		got, err := rec.Prompt("sys", user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, got)
+	}
+	if strings.Join(recorded, ",") != "sys|hello,sys|world,sys|hello" {
+		t.Errorf("expected the live responses while recording, got %v", recorded)
+	}
+
+	// Replaying never reaches the inner provider
+	offline := &configuredStub{stubBackend: stubBackend{err: fmt.Errorf("network unreachable")}, model: live.GetModel(), params: map[string]string{}}
+	play := NewVCR(offline, path)
+	for i, user := range []string{"hello", "world", "hello"} {
+		got, err := play.Prompt("sys", user)
+		if err != nil || got != recorded[i] {
+			t.Errorf("expected %q replayed, got %q, err=%v", recorded[i], got, err)
+		}
+	}
+	if offline.calls != 0 {
+		t.Errorf("expected no calls to the inner provider, got %d", offline.calls)
+	}
+
+	// A prompt that wasn't recorded goes to the inner provider, and its error is not kept
+	if _, err := play.Prompt("other", "hello"); err == nil || offline.calls != 1 {
+		t.Errorf("expected the unrecorded prompt to fail through, got err=%v after %d calls", err, offline.calls)
+	}
+	if _, err := NewVCR(offline, path).Prompt("other", "hello"); err == nil {
+		t.Error("expected the failed prompt not to be recorded")
+	}
+
+	// Params are part of the prompt's identity
+	if _, err := play.PromptOptions("sys", "hello", Options{Params: map[string]string{"TEMPERATURE": "0"}}); err == nil {
+		t.Error("expected a prompt recorded without params not to replay with them")
+	}
+
+	// So are the model and params set on the inner provider
+	for _, set := range []func(){
+		func() { offline.SetModel("other-model") },
+		func() { offline.SetParam("CHAT_MODEL", "other-model") },
+		func() { offline.SetParam("TEMPERATURE", "0") },
+	} {
+		offline.model, offline.params = live.GetModel(), map[string]string{}
+		set()
+		if _, err := play.Prompt("sys", "hello"); err == nil {
+			t.Errorf("expected a prompt recorded under other settings not to replay, inner has model %q params %v", offline.model, offline.params)
+		}
+	}
+	offline.model, offline.params = live.GetModel(), map[string]string{"RETRY_ON_EMPTY": "0"}
+	if got, err := play.Prompt("sys", "hello"); err != nil || got != recorded[0] {
+		t.Errorf("expected RETRY_ON_EMPTY not to change the key, got %q, err=%v", got, err)
+	}
+
+	os.WriteFile(path, []byte("not json"), 0o644)
+	if _, err := NewVCR(live, path).Prompt("sys", "hello"); err == nil {
+		t.Error("expected an error for a corrupt cassette")
+	}
+}
+
+func TestVCRPassesSettingsThrough(t *testing.T) {
+	inner := NewOllama()
+	v := NewVCR(inner, filepath.Join(t.TempDir(), "cassette.json"))
+	v.SetModel("llama3.2")
+	v.SetParam("TEMPERATURE", "0.2")
+	if inner.GetModel() != "llama3.2" || inner.GetParam("TEMPERATURE") != "0.2" {
+		t.Errorf("expected settings to reach the wrapped provider, got model %q temperature %q", inner.GetModel(), inner.GetParam("TEMPERATURE"))
+	}
+	if v.ProviderName() != inner.ProviderName() {
+		t.Errorf("expected the wrapped provider's name, got %q", v.ProviderName())
+	}
+}
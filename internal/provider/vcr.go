@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// VCR is a provider that records another provider's responses in a
+// cassette file and replays them, so tests that prompt an LLM run offline
+// and give the same answers every time. A prompt found in the cassette
+// never reaches the wrapped provider; any other is sent to it and its
+// response is recorded. Errors are not recorded.
+type VCR struct {
+	inner Provider
+	path  string
+
+	mu       sync.Mutex
+	cassette map[string]vcrEntry // prompt key -> interaction; nil until loaded
+}
+
+// vcrEntry is one recorded interaction. The prompt is kept alongside the
+// response so cassettes can be read and reviewed.
+type vcrEntry struct {
+	System   string            `json:"system"`
+	User     string            `json:"user"`
+	Params   map[string]string `json:"params,omitempty"`
+	Response string            `json:"response"`
+}
+
+// NewVCR creates a provider that replays responses from the cassette at
+// path, recording those it doesn't have from inner. The cassette is created
+// on the first recording if it doesn't exist.
+func NewVCR(inner Provider, path string) *VCR {
+	return &VCR{inner: inner, path: path}
+}
+
+// Prompt replays the recorded response to the prompt, or records inner's.
+func (v *VCR) Prompt(system, user string) (string, error) {
+	return v.PromptOptions(system, user, Options{})
+}
+
+// PromptOptions is Prompt with opts applied to inner. The model and params
+// the prompt runs with are part of what identifies it in the cassette.
+func (v *VCR) PromptOptions(system, user string, opts Options) (string, error) {
+	params := v.keyParams(opts.Params)
+	key := vcrKey(system, user, params)
+	v.mu.Lock()
+	if err := v.load(); err != nil {
+		v.mu.Unlock()
+		return "", err
+	}
+	entry, ok := v.cassette[key]
+	v.mu.Unlock()
+	if ok {
+		return entry.Response, nil
+	}
+
+	response, err := promptOptions(v.inner, system, user, opts)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cassette[key] = vcrEntry{System: system, User: user, Params: params, Response: response}
+	if err := v.save(); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// GetParam, SetParam, GetModel, SetModel and ProviderName pass through to
+// the wrapped provider, so SYSTEM settings still reach it while recording.
+
+func (v *VCR) GetParam(key string) string {
+	if cfg, ok := v.inner.(Configurable); ok {
+		return cfg.GetParam(key)
+	}
+	return ""
+}
+
+func (v *VCR) SetParam(key, value string) {
+	if cfg, ok := v.inner.(Configurable); ok {
+		cfg.SetParam(key, value)
+	}
+}
+
+func (v *VCR) GetModel() string {
+	if cfg, ok := v.inner.(Configurable); ok {
+		return cfg.GetModel()
+	}
+	return ""
+}
+
+func (v *VCR) SetModel(model string) {
+	if cfg, ok := v.inner.(Configurable); ok {
+		cfg.SetModel(model)
+	}
+}
+
+func (v *VCR) ProviderName() string {
+	if cfg, ok := v.inner.(Configurable); ok {
+		return cfg.ProviderName()
+	}
+	return ""
+}
+
+// SetStreamCallback streams the wrapped provider's responses while
+// recording. Replayed responses are not streamed.
+func (v *VCR) SetStreamCallback(cb func(token string)) {
+	if s, ok := v.inner.(interface{ SetStreamCallback(func(string)) }); ok {
+		s.SetStreamCallback(cb)
+	}
+}
+
+// vcrParams are the inner provider's params that change its answers.
+// RETRY_ON_EMPTY only changes how often it is asked.
+var vcrParams = []string{"CHAT_MODEL", "TEMPERATURE", "NUM_CTX", "TOP_K", "TOP_P", "MAX_TOKENS"}
+
+// keyParams returns what a prompt runs with besides its text: inner's
+// MODEL and vcrParams, with the prompt's own params applied over them.
+func (v *VCR) keyParams(params map[string]string) map[string]string {
+	keyed := make(map[string]string)
+	if cfg, ok := v.inner.(Configurable); ok {
+		if m := cfg.GetModel(); m != "" {
+			keyed["MODEL"] = m
+		}
+		for _, k := range vcrParams {
+			if val := cfg.GetParam(k); val != "" {
+				keyed[k] = val
+			}
+		}
+	}
+	maps.Copy(keyed, params)
+	delete(keyed, "RETRY_ON_EMPTY")
+	if len(keyed) == 0 {
+		return nil
+	}
+	return keyed
+}
+
+// vcrKey identifies a prompt in the cassette. A prompt without a model or
+// params keeps the key it had before they were recorded.
+func vcrKey(system, user string, params map[string]string) string {
+	var b strings.Builder
+	b.WriteString(system + "\x00" + user)
+	for _, k := range slices.Sorted(maps.Keys(params)) {
+		b.WriteString("\x00" + k + "=" + params[k])
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// load reads the cassette the first time it is needed. v.mu must be held.
+func (v *VCR) load() error {
+	if v.cassette != nil {
+		return nil
+	}
+	data, err := os.ReadFile(v.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		v.cassette = make(map[string]vcrEntry)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	cassette := make(map[string]vcrEntry)
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return fmt.Errorf("vcr: reading cassette %s: %w", v.path, err)
+	}
+	v.cassette = cassette
+	return nil
+}
+
+// save writes the whole cassette, replacing the file in one step so an
+// interrupted write can't leave it truncated. v.mu must be held.
+func (v *VCR) save() error {
+	data, err := json.MarshalIndent(v.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(v.path), ".cassette-*")
+	if err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("vcr: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), v.path); err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package scanner
 import (
 	"bufio"
 	"io"
+	"slices"
 	"strings"
 	"unicode"
 
@@ -19,13 +20,31 @@ type Scanner struct {
 	buf    strings.Builder
 	peeked *Item
 	line   int // Current line number (1-based)
+	col    int // Runes consumed on the current line
+
+	// Position before the last readRune, restored by unreadRune
+	prevLine, prevCol int
+
+	ignored []rune // Runes skipped besides zero-width space and BOM
+
+	expand      func(name string) (string, bool) // Macro lookup for ⟦name⟧ (nil = no macros)
+	pending     []rune                           // Runes to read before the reader's: macro text, or what followed a ⟦ that wasn't a macro
+	last        rune                             // Last rune taken from pending, for unreadRune
+	fromPending bool                             // Whether the last readRune took from pending
 }
 
+// Macro markers: ⟦name⟧ expands to the text of the macro name.
+const (
+	MacroOpen  = '⟦' // U+27E6
+	MacroClose = '⟧' // U+27E7
+)
+
 // Item represents a scanned token with its value.
 type Item struct {
 	Token token.Token
 	Value string
 	Line  int // Line number where this token started
+	Col   int // Column (1-based, in runes) where this token started
 }
 
 // New creates a new Scanner from an io.Reader.
@@ -41,6 +60,117 @@ func (s *Scanner) Line() int {
 	return s.line
 }
 
+// Column returns the 1-based column, in runes, of the next unread rune.
+func (s *Scanner) Column() int {
+	return s.col + 1
+}
+
+// Ignore makes the scanner skip the given runes wherever they appear, as it
+// always does zero-width spaces (U+200B) and byte order marks (U+FEFF).
+// Operator runes are never ignored.
+func (s *Scanner) Ignore(runes ...rune) *Scanner {
+	for _, r := range runes {
+		if !token.IsOperator(r) {
+			s.ignored = append(s.ignored, r)
+		}
+	}
+	return s
+}
+
+// isIgnored reports whether r is invisible to the scanner.
+func (s *Scanner) isIgnored(r rune) bool {
+	switch r {
+	case '\u200B', '\uFEFF':
+		return true
+	}
+	return slices.Contains(s.ignored, r)
+}
+
+// Macros makes the scanner replace each ⟦name⟧ with the text expand
+// returns for name before tokenizing it. A marker expand doesn't know, or
+// one left unclosed, is kept as written. The replacement is read as is,
+// without expanding markers in it again, and takes up no line or column.
+func (s *Scanner) Macros(expand func(name string) (string, bool)) *Scanner {
+	s.expand = expand
+	return s
+}
+
+// readRune reads the next rune, expanding macros. Ignored runes are skipped
+// and take up no column.
+func (s *Scanner) readRune() (rune, error) {
+	if len(s.pending) > 0 {
+		r := s.pending[0]
+		s.pending = s.pending[1:]
+		s.prevLine, s.prevCol = s.line, s.col
+		s.last, s.fromPending = r, true
+		return r, nil
+	}
+
+	r, err := s.readSource()
+	if err != nil || r != MacroOpen || s.expand == nil {
+		return r, err
+	}
+
+	// Read the name up to ⟧, stopping early at anything a name can't hold
+	var name []rune
+	for {
+		c, err := s.readSource()
+		if err != nil {
+			break
+		}
+		if c == MacroClose && len(name) > 0 {
+			if text, ok := s.expand(string(name)); ok {
+				s.pending = []rune(text)
+				return s.readRune()
+			}
+		}
+		if !isIdentChar(c) {
+			// Left for the next read, where it may start a marker itself
+			s.reader.UnreadRune()
+			s.line, s.col = s.prevLine, s.prevCol
+			break
+		}
+		name = append(name, c)
+	}
+
+	// Not a macro: the ⟦ and the name after it are read as written
+	s.pending = name
+	s.prevLine, s.prevCol = s.line, s.col
+	s.last, s.fromPending = r, true
+	return r, nil
+}
+
+// readSource reads the next rune from the reader, advancing the line and
+// column.
+func (s *Scanner) readSource() (rune, error) {
+	r, _, err := s.reader.ReadRune()
+	for err == nil && s.isIgnored(r) {
+		r, _, err = s.reader.ReadRune()
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.prevLine, s.prevCol = s.line, s.col
+	s.fromPending = false
+	if r == '\n' {
+		s.line++
+		s.col = 0
+	} else {
+		s.col++
+	}
+	return r, nil
+}
+
+// unreadRune puts back the rune returned by the last readRune.
+func (s *Scanner) unreadRune() {
+	if s.fromPending {
+		s.pending = slices.Insert(s.pending, 0, s.last)
+	} else {
+		s.reader.UnreadRune()
+	}
+	s.line, s.col = s.prevLine, s.prevCol
+}
+
 // NewFromString creates a new Scanner from a string.
 func NewFromString(s string) *Scanner {
 	return New(strings.NewReader(s))
@@ -68,37 +198,29 @@ func (s *Scanner) Next() (*Item, error) {
 	}
 
 	s.buf.Reset()
-	startLine := s.line
+	startLine, startCol := s.line, s.col+1
 
 	for {
-		r, _, err := s.reader.ReadRune()
+		r, err := s.readRune()
 		if err == io.EOF {
 			if s.buf.Len() > 0 {
-				return &Item{Token: token.TEXT, Value: s.buf.String(), Line: startLine}, nil
+				return &Item{Token: token.TEXT, Value: s.buf.String(), Line: startLine, Col: startCol}, nil
 			}
-			return &Item{Token: token.EOF, Line: s.line}, nil
+			return &Item{Token: token.EOF, Line: s.line, Col: s.col + 1}, nil
 		}
 		if err != nil {
 			return nil, err
 		}
 
-		// Track newlines
-		if r == '\n' {
-			s.line++
-		}
-
 		if token.IsOperator(r) {
 			// If we have accumulated text, return it first
 			if s.buf.Len() > 0 {
-				// Put the operator back (and undo line count if it was newline)
-				s.reader.UnreadRune()
-				if r == '\n' {
-					s.line--
-				}
-				return &Item{Token: token.TEXT, Value: s.buf.String(), Line: startLine}, nil
+				// Put the operator back
+				s.unreadRune()
+				return &Item{Token: token.TEXT, Value: s.buf.String(), Line: startLine, Col: startCol}, nil
 			}
 			// Return the operator
-			return &Item{Token: token.TokenFromRune(r), Value: string(r), Line: s.line}, nil
+			return &Item{Token: token.TokenFromRune(r), Value: string(r), Line: s.line, Col: s.col}, nil
 		}
 
 		s.buf.WriteRune(r)
@@ -110,6 +232,19 @@ func isIdentChar(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
 }
 
+// IsName reports whether s is a whole identifier, as ScanName would read it.
+func IsName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isIdentChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
 // ScanName scans the next identifier name from the input.
 // Skips leading whitespace, returns the identifier.
 // Identifiers consist of letters, digits, and underscores.
@@ -118,28 +253,22 @@ func (s *Scanner) ScanName() (string, error) {
 
 	// Skip leading whitespace
 	for {
-		r, _, err := s.reader.ReadRune()
+		r, err := s.readRune()
 		if err == io.EOF {
 			return "", nil
 		}
 		if err != nil {
 			return "", err
 		}
-		if r == '\n' {
-			s.line++
-		}
 		if token.IsOperator(r) {
-			s.reader.UnreadRune()
-			if r == '\n' {
-				s.line--
-			}
+			s.unreadRune()
 			return "", nil
 		}
 		if !unicode.IsSpace(r) {
 			if isIdentChar(r) {
 				name.WriteRune(r)
 			} else {
-				s.reader.UnreadRune()
+				s.unreadRune()
 				return "", nil
 			}
 			break
@@ -148,7 +277,7 @@ func (s *Scanner) ScanName() (string, error) {
 
 	// Read identifier characters
 	for {
-		r, _, err := s.reader.ReadRune()
+		r, err := s.readRune()
 		if err == io.EOF {
 			break
 		}
@@ -156,7 +285,7 @@ func (s *Scanner) ScanName() (string, error) {
 			return "", err
 		}
 		if !isIdentChar(r) {
-			s.reader.UnreadRune()
+			s.unreadRune()
 			break
 		}
 		name.WriteRune(r)
@@ -173,7 +302,7 @@ func (s *Scanner) ScanUntilTerminator() (string, error) {
 	depth := 1 // We start inside one operator
 
 	for {
-		r, _, err := s.reader.ReadRune()
+		r, err := s.readRune()
 		if err == io.EOF {
 			// Unterminated - return what we have
 			return content.String(), nil
@@ -182,11 +311,6 @@ func (s *Scanner) ScanUntilTerminator() (string, error) {
 			return "", err
 		}
 
-		// Track newlines for accurate line numbers
-		if r == '\n' {
-			s.line++
-		}
-
 		if r == token.RuneTerminator {
 			depth--
 			if depth == 0 {
@@ -204,21 +328,15 @@ func (s *Scanner) ScanUntilTerminator() (string, error) {
 // SkipWhitespace consumes and discards whitespace.
 func (s *Scanner) SkipWhitespace() error {
 	for {
-		r, _, err := s.reader.ReadRune()
+		r, err := s.readRune()
 		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
-		if r == '\n' {
-			s.line++
-		}
 		if !unicode.IsSpace(r) {
-			s.reader.UnreadRune()
-			if r == '\n' {
-				s.line--
-			}
+			s.unreadRune()
 			return nil
 		}
 	}
@@ -232,7 +350,7 @@ func (s *Scanner) PeekRune() (rune, error) {
 		return 0, err
 	}
 
-	r, _, err := s.reader.ReadRune()
+	r, err := s.readRune()
 	if err == io.EOF {
 		return 0, nil
 	}
@@ -241,6 +359,6 @@ func (s *Scanner) PeekRune() (rune, error) {
 	}
 
 	// Put it back
-	s.reader.UnreadRune()
+	s.unreadRune()
 	return r, nil
 }
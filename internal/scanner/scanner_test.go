@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package scanner
+
+import (
+	"reflect"
+	"testing"
+
+	"nickandperla.net/losp/internal/token"
+)
+
+// tokenize scans to EOF, reading the name after each store operator.
+func tokenize(t *testing.T, s *Scanner) []Item {
+	t.Helper()
+	var items []Item
+	for {
+		item, err := s.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		items = append(items, *item)
+		if item.Token == token.EOF {
+			return items
+		}
+		if item.Token == token.STORE {
+			name, err := s.ScanName()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			items = append(items, Item{Token: token.TEXT, Value: "name=" + name})
+		}
+	}
+}
+
+func TestIgnoredRunes(t *testing.T) {
+	clean := "▼Greeting Hello, world ◆\n▶SAY ▲Greeting ◆"
+	want := tokenize(t, NewFromString(clean))
+
+	dirty := map[string]string{
+		"BOM prefix":       "\uFEFF" + clean,
+		"zero-width space": "▼Gree\u200Bting Hello,\u200B world ◆\n▶SAY ▲\u200BGreeting ◆",
+	}
+	for label, src := range dirty {
+		if got := tokenize(t, NewFromString(src)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", label, want, got)
+		}
+	}
+
+	// Extra runes are skipped only when asked for
+	softHyphen := "▼Greet\u00ADing Hello, world ◆\n▶SAY ▲Greeting ◆"
+	if got := tokenize(t, NewFromString(softHyphen)); reflect.DeepEqual(got, want) {
+		t.Error("expected a soft hyphen to be kept by default")
+	}
+	if got := tokenize(t, NewFromString(softHyphen).Ignore('\u00AD')); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the soft hyphen to be ignored, got %+v", got)
+	}
+
+	// Operators can't be ignored
+	if got := tokenize(t, NewFromString(clean).Ignore(token.RuneTerminator)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ◆ to stay an operator, got %+v", got)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	macros := map[string]string{
+		"Greet": "▶SAY hi ◆",
+		"Loop":  "⟦Loop⟧",
+	}
+	expand := func(name string) (string, bool) {
+		text, ok := macros[name]
+		return text, ok
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"⟦Greet⟧ done", "▶SAY hi ◆ done"},
+		{"a⟦Greet⟧⟦Greet⟧", "a▶SAY hi ◆▶SAY hi ◆"},
+		// Unknown, malformed and unclosed markers are kept as written
+		{"⟦Nope⟧ ⟦two words⟧ ⟦Greet", "⟦Nope⟧ ⟦two words⟧ ⟦Greet"},
+		{"⟦⟦Greet⟧", "⟦▶SAY hi ◆"},
+		// Replacements aren't expanded again
+		{"⟦Loop⟧", "⟦Loop⟧"},
+	}
+	for _, tt := range tests {
+		want := tokenize(t, NewFromString(tt.want))
+		got := tokenize(t, NewFromString(tt.src).Macros(expand))
+		for i := range got {
+			got[i].Line, got[i].Col = 0, 0
+		}
+		for i := range want {
+			want[i].Line, want[i].Col = 0, 0
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %+v, got %+v", tt.src, want, got)
+		}
+	}
+
+	// Positions after a macro still count the source as written
+	s := NewFromString("⟦Greet⟧\n▲X").Macros(expand)
+	items := tokenize(t, s)
+	last := items[len(items)-3]
+	if last.Token != token.RETRIEVE || last.Line != 2 || last.Col != 1 {
+		t.Errorf("expected ▲ at 2:1, got %+v", last)
+	}
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"nickandperla.net/losp/internal/expr"
+)
+
+// corpusBundleFormat identifies a corpus bundle stream.
+const (
+	corpusBundleFormat  = "losp-corpus"
+	corpusBundleVersion = 1
+)
+
+// CorpusBundle is a portable snapshot of a corpus: its members, their
+// definitions, full-text content, embeddings and serialized HNSW index.
+type CorpusBundle struct {
+	Name        string
+	Members     []string
+	Definitions map[string]string // member name -> "▼name body ◆"
+	Content     map[string]string // member name -> FTS content (nil if not indexed)
+	Embeddings  map[string][]float32
+	VectorIndex []byte // serialized HNSW graph (nil if not embedded)
+}
+
+// bundleFile is the on-disk form of a CorpusBundle. Vectors are packed with
+// Float32sToBytes, the same encoding SQLite uses for embedding BLOBs.
+type bundleFile struct {
+	Format      string            `json:"format"`
+	Version     int               `json:"version"`
+	Name        string            `json:"name"`
+	Members     []string          `json:"members"`
+	Definitions map[string]string `json:"definitions,omitempty"`
+	Content     map[string]string `json:"content,omitempty"`
+	Embeddings  map[string][]byte `json:"embeddings,omitempty"`
+	VectorIndex []byte            `json:"vector_index,omitempty"`
+}
+
+// Encode writes the bundle to w.
+func (b *CorpusBundle) Encode(w io.Writer) error {
+	f := bundleFile{
+		Format:      corpusBundleFormat,
+		Version:     corpusBundleVersion,
+		Name:        b.Name,
+		Members:     b.Members,
+		Definitions: b.Definitions,
+		Content:     b.Content,
+		VectorIndex: b.VectorIndex,
+	}
+	if len(b.Embeddings) > 0 {
+		f.Embeddings = make(map[string][]byte, len(b.Embeddings))
+		for name, vec := range b.Embeddings {
+			f.Embeddings[name] = Float32sToBytes(vec)
+		}
+	}
+	return json.NewEncoder(w).Encode(f)
+}
+
+// DecodeCorpusBundle reads a bundle written by Encode.
+func DecodeCorpusBundle(r io.Reader) (*CorpusBundle, error) {
+	var f bundleFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("corpus bundle: %w", err)
+	}
+	if f.Format != corpusBundleFormat {
+		return nil, fmt.Errorf("corpus bundle: unrecognized format %q", f.Format)
+	}
+	if f.Version > corpusBundleVersion {
+		return nil, fmt.Errorf("corpus bundle: unsupported version %d", f.Version)
+	}
+	if f.Name == "" {
+		return nil, fmt.Errorf("corpus bundle: missing corpus name")
+	}
+
+	b := &CorpusBundle{
+		Name:        f.Name,
+		Members:     f.Members,
+		Definitions: f.Definitions,
+		Content:     f.Content,
+		VectorIndex: f.VectorIndex,
+	}
+	if len(f.Embeddings) > 0 {
+		b.Embeddings = make(map[string][]float32, len(f.Embeddings))
+		for name, blob := range f.Embeddings {
+			b.Embeddings[name] = BytesToFloat32s(blob)
+		}
+	}
+	return b, nil
+}
+
+// RestoreCorpus recreates a bundle's corpus in cs: membership, FTS content,
+// embeddings and vector index. Member definitions are not written; they
+// belong to the expression store.
+func RestoreCorpus(cs CorpusStore, b *CorpusBundle) error {
+	exists, err := cs.CorpusExists(b.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := cs.CreateCorpus(b.Name); err != nil {
+			return err
+		}
+	}
+	for _, member := range b.Members {
+		if err := cs.AddCorpusMember(b.Name, member); err != nil {
+			return err
+		}
+	}
+	if b.Content != nil {
+		if err := cs.CreateFTSTable(b.Name); err != nil {
+			return err
+		}
+		for _, member := range b.Members {
+			if err := cs.UpdateFTSContent(b.Name, member, b.Content[member]); err != nil {
+				return err
+			}
+		}
+	}
+	for name, vec := range b.Embeddings {
+		if err := cs.StoreEmbedding(b.Name, name, vec); err != nil {
+			return err
+		}
+	}
+	if b.VectorIndex != nil {
+		if err := cs.StoreVectorIndex(b.Name, b.VectorIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportFormat identifies a store export stream.
+const (
+	exportFormat  = "losp-export"
+	exportVersion = 1
+)
+
+// Export is a portable snapshot of a store's current expressions and
+// metadata, written by stores that have no native file format.
+type Export struct {
+	SchemaVersion string // SchemaVersion of the build that wrote it
+	Expressions   map[string]expr.Expr
+	Metadata      map[string]string
+}
+
+// exportFile is the on-disk form of an Export. Blob values are kept apart
+// so they round-trip byte-for-byte.
+type exportFile struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	SchemaVersion string            `json:"schema_version"`
+	Expressions   map[string]string `json:"expressions"`
+	Blobs         map[string][]byte `json:"blobs,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// Encode writes the export to w.
+func (x *Export) Encode(w io.Writer) error {
+	f := exportFile{
+		Format:        exportFormat,
+		Version:       exportVersion,
+		SchemaVersion: SchemaVersion,
+		Expressions:   make(map[string]string),
+		Metadata:      x.Metadata,
+	}
+	for name, e := range x.Expressions {
+		if b, ok := e.(expr.Blob); ok {
+			if f.Blobs == nil {
+				f.Blobs = make(map[string][]byte)
+			}
+			f.Blobs[name] = b.Data
+			continue
+		}
+		f.Expressions[name] = e.String()
+	}
+	return json.NewEncoder(w).Encode(f)
+}
+
+// DecodeExport reads an export written by Encode.
+func DecodeExport(r io.Reader) (*Export, error) {
+	var f exportFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("store export: %w", err)
+	}
+	if f.Format != exportFormat {
+		return nil, fmt.Errorf("store export: unrecognized format %q", f.Format)
+	}
+	if f.Version > exportVersion {
+		return nil, fmt.Errorf("store export: unsupported version %d", f.Version)
+	}
+
+	x := &Export{
+		SchemaVersion: f.SchemaVersion,
+		Expressions:   make(map[string]expr.Expr, len(f.Expressions)+len(f.Blobs)),
+		Metadata:      f.Metadata,
+	}
+	for name, value := range f.Expressions {
+		x.Expressions[name] = expr.Stored{Body: value}
+	}
+	for name, data := range f.Blobs {
+		x.Expressions[name] = expr.Blob{Data: data}
+	}
+	return x, nil
+}
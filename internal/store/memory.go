@@ -4,6 +4,12 @@
 package store
 
 import (
+	"container/list"
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 
@@ -23,11 +29,29 @@ type Memory struct {
 	ftsContent map[string]map[string]string // corpus name -> expr name -> content
 	embeddings map[string]map[string][]float32
 	vecIndexes map[string][]byte
+
+	// LRU eviction (disabled when limit <= 0)
+	limit   int
+	lru     *list.List               // front = most recently accessed
+	lruElem map[string]*list.Element // name -> element in lru
+}
+
+// MemoryOption configures a Memory store.
+type MemoryOption func(*Memory)
+
+// WithMemoryLimit caps the number of stored expressions. Once the cap is
+// exceeded, the least recently accessed expression is evicted along with its
+// versions. System names (prefixed with "__") are pinned: they are never
+// evicted and do not count toward the cap.
+func WithMemoryLimit(maxEntries int) MemoryOption {
+	return func(m *Memory) {
+		m.limit = maxEntries
+	}
 }
 
 // NewMemory creates a new in-memory store.
-func NewMemory() *Memory {
-	return &Memory{
+func NewMemory(opts ...MemoryOption) *Memory {
+	m := &Memory{
 		data:       make(map[string]expr.Expr),
 		metadata:   make(map[string]string),
 		versions:   make(map[string][]VersionEntry),
@@ -36,14 +60,21 @@ func NewMemory() *Memory {
 		ftsContent: make(map[string]map[string]string),
 		embeddings: make(map[string]map[string][]float32),
 		vecIndexes: make(map[string][]byte),
+		lru:        list.New(),
+		lruElem:    make(map[string]*list.Element),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // Get retrieves an expression by name.
 func (m *Memory) Get(name string) (expr.Expr, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if e, ok := m.data[name]; ok {
+		m.touch(name)
 		return e, nil
 	}
 	return nil, nil
@@ -53,17 +84,36 @@ func (m *Memory) Get(name string) (expr.Expr, error) {
 func (m *Memory) Put(name string, e expr.Expr) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.put(name, e)
+	return nil
+}
+
+// PutBatch applies all entries under a single lock.
+func (m *Memory) PutBatch(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		m.put(entry.Name, entry.Expr)
+	}
+	return nil
+}
 
+// put stores an expression, appending a new version if changed. Caller must
+// hold m.mu.
+func (m *Memory) put(name string, e expr.Expr) {
 	value := ""
 	if e != nil {
 		value = e.String()
 	}
 
+	m.touch(name)
+	defer m.evict()
+
 	// Dedup: skip if value unchanged
 	if vv := m.versions[name]; len(vv) > 0 {
 		if vv[len(vv)-1].Value == value {
 			m.data[name] = e
-			return nil
+			return
 		}
 	}
 
@@ -73,7 +123,34 @@ func (m *Memory) Put(name string, e expr.Expr) error {
 		Value:   value,
 	})
 	m.data[name] = e
-	return nil
+}
+
+// touch marks name as most recently accessed. Caller must hold m.mu.
+func (m *Memory) touch(name string) {
+	if m.limit <= 0 || strings.HasPrefix(name, "__") {
+		return
+	}
+	if el, ok := m.lruElem[name]; ok {
+		m.lru.MoveToFront(el)
+		return
+	}
+	m.lruElem[name] = m.lru.PushFront(name)
+}
+
+// evict drops least recently accessed entries until the store is within its
+// limit. Caller must hold m.mu.
+func (m *Memory) evict() {
+	if m.limit <= 0 {
+		return
+	}
+	for m.lru.Len() > m.limit {
+		el := m.lru.Back()
+		name := el.Value.(string)
+		m.lru.Remove(el)
+		delete(m.lruElem, name)
+		delete(m.data, name)
+		delete(m.versions, name)
+	}
 }
 
 // Delete removes an expression and all its versions by name.
@@ -82,6 +159,10 @@ func (m *Memory) Delete(name string) error {
 	defer m.mu.Unlock()
 	delete(m.data, name)
 	delete(m.versions, name)
+	if el, ok := m.lruElem[name]; ok {
+		m.lru.Remove(el)
+		delete(m.lruElem, name)
+	}
 	return nil
 }
 
@@ -90,6 +171,30 @@ func (m *Memory) Close() error {
 	return nil
 }
 
+// Backup writes the current expressions and metadata to path as an export
+// file (see Export). History and corpora are not included.
+func (m *Memory) Backup(path string) error {
+	m.mu.RLock()
+	x := &Export{
+		Expressions: make(map[string]expr.Expr, len(m.data)),
+		Metadata:    maps.Clone(m.metadata),
+	}
+	for name, e := range m.data {
+		x.Expressions[name] = e
+	}
+	m.mu.RUnlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := x.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // GetHistory returns version entries for a name, newest first.
 // If limit <= 0, all versions are returned.
 func (m *Memory) GetHistory(name string, limit int) ([]VersionEntry, error) {
@@ -113,6 +218,38 @@ func (m *Memory) GetHistory(name string, limit int) ([]VersionEntry, error) {
 	return result, nil
 }
 
+// FindValue returns the names whose latest version holds value.
+func (m *Memory) FindValue(value string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var names []string
+	for name, e := range m.data {
+		if _, ok := e.(expr.Blob); ok || e == nil {
+			continue
+		}
+		if holdsValue(name, e.String(), value) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// holdsValue reports whether the stored text of name is value, or its
+// definition with value as the body, ignoring surrounding whitespace.
+func holdsValue(name, stored, value string) bool {
+	if strings.TrimSpace(stored) == value {
+		return true
+	}
+	body, ok := strings.CutPrefix(stored, "▼"+name+" ")
+	if !ok {
+		return false
+	}
+	body, ok = strings.CutSuffix(body, "◆")
+	return ok && strings.TrimSpace(body) == value
+}
+
 // GetMetadata retrieves a metadata value by key.
 func (m *Memory) GetMetadata(key string) (string, error) {
 	m.mu.RLock()
@@ -128,6 +265,19 @@ func (m *Memory) SetMetadata(key, value string) error {
 	return nil
 }
 
+// ListMetadata returns every metadata entry whose key starts with prefix.
+func (m *Memory) ListMetadata(prefix string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string]string)
+	for k, v := range m.metadata {
+		if strings.HasPrefix(k, prefix) {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
 // CorpusExists checks if a corpus exists.
 func (m *Memory) CorpusExists(name string) (bool, error) {
 	m.mu.RLock()
@@ -258,6 +408,16 @@ type CorpusStore interface {
 	GetVectorIndex(corpus string) ([]byte, error)
 }
 
+// SearchStreamer extends CorpusStore with full-text search results that
+// are read as they are consumed instead of all at once.
+type SearchStreamer interface {
+	// SearchFTSSeq yields what SearchFTS would return, in the same order.
+	SearchFTSSeq(corpus, query string, limit int) iter.Seq2[string, error]
+}
+
+// Verify the SQLite store streams search results.
+var _ SearchStreamer = (*SQLite)(nil)
+
 // Verify both implementations satisfy CorpusStore.
 var (
 	_ CorpusStore = (*SQLite)(nil)
@@ -270,3 +430,27 @@ var (
 	_ HistoryStore = (*Memory)(nil)
 )
 
+// Verify both implementations satisfy ValueFinder.
+var (
+	_ ValueFinder = (*SQLite)(nil)
+	_ ValueFinder = (*Memory)(nil)
+)
+
+// Verify both implementations satisfy MetadataLister.
+var (
+	_ MetadataLister = (*SQLite)(nil)
+	_ MetadataLister = (*Memory)(nil)
+)
+
+// Verify both implementations satisfy BatchStore.
+var (
+	_ BatchStore = (*SQLite)(nil)
+	_ BatchStore = (*Memory)(nil)
+)
+
+// Verify both implementations satisfy BackupStore.
+var (
+	_ BackupStore = (*SQLite)(nil)
+	_ BackupStore = (*Memory)(nil)
+)
+
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package store
+
+import "nickandperla.net/losp/internal/expr"
+
+// Null is a store that keeps nothing: writes are discarded and every name
+// reads as missing. It leaves the namespace as a runtime's only state, for
+// hosts such as stateless servers that need no persistence. It has no
+// history, metadata or corpus support.
+type Null struct{}
+
+// NewNull creates a store that keeps nothing.
+func NewNull() Null {
+	return Null{}
+}
+
+// Get always reports name as not found.
+func (Null) Get(name string) (expr.Expr, error) { return nil, nil }
+
+// Put discards the expression.
+func (Null) Put(name string, e expr.Expr) error { return nil }
+
+// Delete does nothing.
+func (Null) Delete(name string) error { return nil }
+
+// Close does nothing.
+func (Null) Close() error { return nil }
@@ -4,10 +4,13 @@
 package store
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/binary"
 	"fmt"
+	"iter"
 	"math"
+	"os"
 	"strings"
 	"sync"
 
@@ -15,7 +18,7 @@ import (
 )
 
 // Current schema version
-const SchemaVersion = "3"
+const SchemaVersion = "4"
 
 // SQLite is a SQLite-backed store.
 type SQLite struct {
@@ -71,6 +74,14 @@ func NewSQLite(path string) (*SQLite, error) {
 		}
 		version = "3"
 	}
+	if version == "3" {
+		// Migrate to v4: binary values
+		if err := s.migrateToV4(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		version = "4"
+	}
 	if version != SchemaVersion {
 		db.Close()
 		return nil, fmt.Errorf("unsupported schema version: %s (expected %s)", version, SchemaVersion)
@@ -144,13 +155,29 @@ func (s *SQLite) migrateToV3() error {
 	return err
 }
 
+// migrateToV4 adds a BLOB column holding the bytes of expr.Blob values.
+// Text values leave it NULL.
+func (s *SQLite) migrateToV4() error {
+	var cnt int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('expressions') WHERE name = 'data'`).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return nil
+	}
+	_, err = s.db.Exec(`ALTER TABLE expressions ADD COLUMN data BLOB`)
+	return err
+}
+
 // Get retrieves the latest version of an expression by name.
 func (s *SQLite) Get(name string) (expr.Expr, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	var value string
-	err := s.db.QueryRow("SELECT value FROM expressions WHERE name = ? ORDER BY version DESC LIMIT 1", name).Scan(&value)
+	var data []byte
+	err := s.db.QueryRow("SELECT value, data FROM expressions WHERE name = ? ORDER BY version DESC LIMIT 1", name).Scan(&value, &data)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -158,6 +185,9 @@ func (s *SQLite) Get(name string) (expr.Expr, error) {
 		return nil, err
 	}
 
+	if data != nil {
+		return expr.Blob{Data: data}, nil
+	}
 	return expr.Stored{Body: value}, nil
 }
 
@@ -165,22 +195,58 @@ func (s *SQLite) Get(name string) (expr.Expr, error) {
 func (s *SQLite) Put(name string, e expr.Expr) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return putVersion(s.db, name, e)
+}
+
+// PutBatch appends versions for all entries in a single transaction.
+func (s *SQLite) PutBatch(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := putVersion(tx, entry.Name, entry.Expr); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx.
+type queryExecer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// putVersion appends a new version of an expression unless the latest
+// version already holds the same value. Blob values go in the data column.
+func putVersion(q queryExecer, name string, e expr.Expr) error {
 	value := ""
-	if e != nil {
+	var data []byte
+	if b, ok := e.(expr.Blob); ok {
+		data = b.Data
+		if data == nil {
+			data = []byte{}
+		}
+	} else if e != nil {
 		value = e.String()
 	}
 
 	// Check latest version for dedup
 	var latestValue string
+	var latestData []byte
 	var latestVersion int
-	err := s.db.QueryRow(
-		"SELECT version, value FROM expressions WHERE name = ? ORDER BY version DESC LIMIT 1", name,
-	).Scan(&latestVersion, &latestValue)
+	err := q.QueryRow(
+		"SELECT version, value, data FROM expressions WHERE name = ? ORDER BY version DESC LIMIT 1", name,
+	).Scan(&latestVersion, &latestValue, &latestData)
 	if err == sql.ErrNoRows {
 		// First version
-		_, err = s.db.Exec(
-			"INSERT INTO expressions (name, version, value) VALUES (?, 1, ?)", name, value,
+		_, err = q.Exec(
+			"INSERT INTO expressions (name, version, value, data) VALUES (?, 1, ?, ?)", name, value, data,
 		)
 		return err
 	}
@@ -189,13 +255,13 @@ func (s *SQLite) Put(name string, e expr.Expr) error {
 	}
 
 	// No-op if value unchanged
-	if latestValue == value {
+	if latestValue == value && (latestData == nil) == (data == nil) && bytes.Equal(latestData, data) {
 		return nil
 	}
 
-	_, err = s.db.Exec(
-		"INSERT INTO expressions (name, version, value) VALUES (?, ?, ?)",
-		name, latestVersion+1, value,
+	_, err = q.Exec(
+		"INSERT INTO expressions (name, version, value, data) VALUES (?, ?, ?, ?)",
+		name, latestVersion+1, value, data,
 	)
 	return err
 }
@@ -209,6 +275,37 @@ func (s *SQLite) Delete(name string) error {
 	return err
 }
 
+// FindValue returns the names whose latest version holds value.
+func (s *SQLite) FindValue(value string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query(`
+		SELECT e.name FROM expressions e
+		WHERE e.version = (SELECT MAX(version) FROM expressions WHERE name = e.name)
+			AND e.data IS NULL
+			AND (TRIM(e.value, ?1) = ?2 OR (
+				SUBSTR(e.value, 1, LENGTH(e.name) + 2) = '▼' || e.name || ' '
+				AND SUBSTR(e.value, -1) = '◆'
+				AND TRIM(SUBSTR(e.value, LENGTH(e.name) + 3, LENGTH(e.value) - LENGTH(e.name) - 3), ?1) = ?2))
+		ORDER BY e.name
+	`, " \t\r\n", value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
 // GetHistory returns version entries for a name, newest first.
 // If limit <= 0, all versions are returned.
 func (s *SQLite) GetHistory(name string, limit int) ([]VersionEntry, error) {
@@ -219,12 +316,12 @@ func (s *SQLite) GetHistory(name string, limit int) ([]VersionEntry, error) {
 	var err error
 	if limit > 0 {
 		rows, err = s.db.Query(
-			"SELECT version, value, ts FROM expressions WHERE name = ? ORDER BY version DESC LIMIT ?",
+			"SELECT version, COALESCE(data, value), ts FROM expressions WHERE name = ? ORDER BY version DESC LIMIT ?",
 			name, limit,
 		)
 	} else {
 		rows, err = s.db.Query(
-			"SELECT version, value, ts FROM expressions WHERE name = ? ORDER BY version DESC",
+			"SELECT version, COALESCE(data, value), ts FROM expressions WHERE name = ? ORDER BY version DESC",
 			name,
 		)
 	}
@@ -244,6 +341,19 @@ func (s *SQLite) GetHistory(name string, limit int) ([]VersionEntry, error) {
 	return entries, rows.Err()
 }
 
+// Backup copies the database to path with VACUUM INTO, which reads a
+// consistent snapshot without blocking other connections. The copy is a
+// complete database that NewSQLite opens like the original.
+func (s *SQLite) Backup(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup: %s already exists", path)
+	}
+	_, err := s.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
 // Close closes the database connection.
 func (s *SQLite) Close() error {
 	return s.db.Close()
@@ -276,6 +386,27 @@ func (s *SQLite) SetMetadata(key, value string) error {
 	return s.setMetadataUnlocked(key, value)
 }
 
+// ListMetadata returns every metadata entry whose key starts with prefix.
+func (s *SQLite) ListMetadata(prefix string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// substr rather than LIKE, so '%' and '_' in the prefix match literally
+	rows, err := s.db.Query("SELECT key, value FROM metadata WHERE substr(key, 1, length(?1)) = ?1", prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
 // setMetadataUnlocked stores metadata without locking (caller must hold lock).
 func (s *SQLite) setMetadataUnlocked(key, value string) error {
 	_, err := s.db.Exec(`
@@ -364,36 +495,87 @@ func (s *SQLite) UpdateFTSContent(corpus, exprName, content string) error {
 
 // SearchFTS performs a full-text search on a corpus.
 func (s *SQLite) SearchFTS(corpus, query string, limit int) ([]string, error) {
+	page, _, err := s.searchFTSPage(corpus, query, nil, limit)
+	return page, err
+}
+
+// ftsPageSize is how many results SearchFTSSeq reads per query.
+const ftsPageSize = 16
+
+// ftsCursor is the last result of an FTS page. The next page starts after
+// it in (rank, name) order.
+type ftsCursor struct {
+	rank float64
+	name string
+}
+
+// SearchFTSSeq is SearchFTS as an iterator. Results are read ftsPageSize at
+// a time, and the store is unlocked between pages so the caller can use it
+// while iterating. Each page resumes after the last result of the one
+// before, so no result is yielded twice, but changes to the corpus's FTS
+// content mid-iteration can still change which results come later.
+func (s *SQLite) SearchFTSSeq(corpus, query string, limit int) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var after *ftsCursor
+		for n := 0; n < limit; {
+			page, last, err := s.searchFTSPage(corpus, query, after, min(ftsPageSize, limit-n))
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, name := range page {
+				if !yield(name, nil) {
+					return
+				}
+			}
+			if len(page) < ftsPageSize {
+				return
+			}
+			n += len(page)
+			after = last
+		}
+	}
+}
+
+// searchFTSPage returns up to limit results after the cursor (from the
+// start when after is nil), ordered by rank with ties broken by name, and
+// the cursor of the last one.
+func (s *SQLite) searchFTSPage(corpus, query string, after *ftsCursor, limit int) ([]string, *ftsCursor, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	table := fmt.Sprintf(`"corpus_fts_%s"`, corpus)
 	// Quote the query as an FTS5 phrase to prevent raw user text from being
 	// interpreted as FTS5 syntax (column filters, boolean operators, etc.).
 	safeQuery := `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
-	rows, err := s.db.Query(
-		fmt.Sprintf(`SELECT expr_name FROM %s WHERE %s MATCH ? ORDER BY rank LIMIT ?`, table, table),
-		safeQuery, limit,
-	)
+	q := fmt.Sprintf(`SELECT expr_name, rank FROM %s WHERE %s MATCH ?`, table, table)
+	args := []any{safeQuery}
+	if after != nil {
+		q += ` AND (rank > ? OR (rank = ? AND expr_name > ?))`
+		args = append(args, after.rank, after.rank, after.name)
+	}
+	q += ` ORDER BY rank, expr_name LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.db.Query(q, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 	var results []string
+	var last ftsCursor
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
+		if err := rows.Scan(&last.name, &last.rank); err != nil {
+			return nil, nil, err
 		}
-		results = append(results, name)
+		results = append(results, last.name)
 	}
-	return results, rows.Err()
+	return results, &last, rows.Err()
 }
 
 // StoreEmbedding stores a float32 vector as a BLOB for an expression in a corpus.
 func (s *SQLite) StoreEmbedding(corpus, exprName string, vector []float32) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	blob := float32sToBytes(vector)
+	blob := Float32sToBytes(vector)
 	_, err := s.db.Exec(`
 		INSERT INTO embeddings (corpus_name, expr_name, vector) VALUES (?, ?, ?)
 		ON CONFLICT(corpus_name, expr_name) DO UPDATE SET vector = excluded.vector
@@ -417,7 +599,7 @@ func (s *SQLite) GetEmbeddings(corpus string) (map[string][]float32, error) {
 		if err := rows.Scan(&name, &blob); err != nil {
 			return nil, err
 		}
-		result[name] = bytesToFloat32s(blob)
+		result[name] = BytesToFloat32s(blob)
 	}
 	return result, rows.Err()
 }
@@ -448,8 +630,9 @@ func (s *SQLite) GetVectorIndex(corpus string) ([]byte, error) {
 	return data, nil
 }
 
-// float32sToBytes converts a float32 slice to a byte slice.
-func float32sToBytes(fs []float32) []byte {
+// Float32sToBytes encodes a vector as little-endian float32s, the format of
+// embedding BLOBs and bundles.
+func Float32sToBytes(fs []float32) []byte {
 	buf := make([]byte, len(fs)*4)
 	for i, f := range fs {
 		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(f))
@@ -457,8 +640,8 @@ func float32sToBytes(fs []float32) []byte {
 	return buf
 }
 
-// bytesToFloat32s converts a byte slice to a float32 slice.
-func bytesToFloat32s(b []byte) []float32 {
+// BytesToFloat32s decodes a vector encoded by Float32sToBytes.
+func BytesToFloat32s(b []byte) []float32 {
 	fs := make([]float32, len(b)/4)
 	for i := range fs {
 		fs[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
@@ -29,3 +29,38 @@ type VersionEntry struct {
 type HistoryStore interface {
 	GetHistory(name string, limit int) ([]VersionEntry, error)
 }
+
+// ValueFinder extends Store with a reverse lookup from value to name.
+type ValueFinder interface {
+	// FindValue returns, in name order, the names whose latest version holds
+	// value, either as plain text or as the body of the definition
+	// "▼name value ◆" that is persisted for an expression without
+	// parameters. Whitespace around the value is ignored.
+	FindValue(value string) ([]string, error)
+}
+
+// MetadataLister extends Store with metadata listing.
+type MetadataLister interface {
+	// ListMetadata returns every metadata entry whose key starts with prefix.
+	ListMetadata(prefix string) (map[string]string, error)
+}
+
+// Entry is a single named expression write.
+type Entry struct {
+	Name string
+	Expr expr.Expr
+}
+
+// BatchStore extends Store with bulk writes.
+type BatchStore interface {
+	// PutBatch applies the entries in order, exactly as successive Put calls
+	// would, but as a single unit of work.
+	PutBatch(entries []Entry) error
+}
+
+// BackupStore extends Store with online backups.
+type BackupStore interface {
+	// Backup writes a consistent copy of the store to path, which must not
+	// already exist.
+	Backup(path string) error
+}
@@ -4,8 +4,11 @@
 package store
 
 import (
+	"bytes"
 	"database/sql"
+	"fmt"
 	"os"
+	"slices"
 	"testing"
 
 	"nickandperla.net/losp/internal/expr"
@@ -91,6 +94,98 @@ func TestSQLiteStore(t *testing.T) {
 	}
 }
 
+func TestListMetadata(t *testing.T) {
+	f, err := os.CreateTemp("", "losp-test-*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	sq, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sq.Close()
+
+	stores := map[string]interface {
+		SetMetadata(key, value string) error
+		MetadataLister
+	}{"memory": NewMemory(), "sqlite": sq}
+
+	for name, s := range stores {
+		s.SetMetadata("Café:why", "menu")
+		s.SetMetadata("Café:when", "2026")
+		s.SetMetadata("Cafe:why", "other")
+		s.SetMetadata("C%:why", "wildcard")
+
+		got, err := s.ListMetadata("Café:")
+		if err != nil {
+			t.Fatalf("%s: ListMetadata failed: %v", name, err)
+		}
+		if len(got) != 2 || got["Café:why"] != "menu" || got["Café:when"] != "2026" {
+			t.Errorf("%s: expected the two Café entries, got %v", name, got)
+		}
+
+		got, _ = s.ListMetadata("C%:")
+		if len(got) != 1 || got["C%:why"] != "wildcard" {
+			t.Errorf("%s: expected '%%' to match literally, got %v", name, got)
+		}
+	}
+}
+
+func TestBlobRoundTrip(t *testing.T) {
+	f, err := os.CreateTemp("", "losp-test-*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	sq, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sq.Close()
+
+	// Invalid UTF-8, NUL, operator glyph bytes and surrounding whitespace
+	data := []byte{0x00, 0xff, 0xfe, '\n', ' ', 0xe2, 0x97, 0x86, 0x80, '\t'}
+
+	for name, s := range map[string]Store{"memory": NewMemory(), "sqlite": sq} {
+		if err := s.Put("Bin", expr.Blob{Data: data}); err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+		got, err := s.Get("Bin")
+		if err != nil {
+			t.Fatalf("%s: Get failed: %v", name, err)
+		}
+		b, ok := got.(expr.Blob)
+		if !ok {
+			t.Fatalf("%s: expected expr.Blob, got %T", name, got)
+		}
+		if !bytes.Equal(b.Data, data) {
+			t.Errorf("%s: expected %v, got %v", name, data, b.Data)
+		}
+
+		// Switching back to text clears the blob
+		s.Put("Bin", expr.Stored{Body: "text"})
+		got, _ = s.Get("Bin")
+		if _, ok := got.(expr.Stored); !ok || got.String() != "text" {
+			t.Errorf("%s: expected Stored 'text', got %T '%v'", name, got, got)
+		}
+	}
+
+	// Identical blobs don't add a version
+	sq.Put("Same", expr.Blob{Data: data})
+	sq.Put("Same", expr.Blob{Data: data})
+	entries, _ := sq.GetHistory("Same", 0)
+	if len(entries) != 1 || entries[0].Value != string(data) {
+		t.Errorf("expected one version holding the blob, got %v", entries)
+	}
+}
+
 func TestMemoryVersioning(t *testing.T) {
 	s := NewMemory()
 
@@ -158,6 +253,53 @@ func TestMemoryVersioning(t *testing.T) {
 	}
 }
 
+func TestMemoryLimitEvictsLRU(t *testing.T) {
+	s := NewMemory(WithMemoryLimit(2))
+
+	s.Put("__stdlib__", expr.Stored{Body: "stdlib"})
+	s.Put("A", expr.Stored{Body: "a"})
+	s.Put("B", expr.Stored{Body: "b"})
+
+	// Touch A so B becomes the least recently accessed
+	s.Get("A")
+
+	s.Put("C", expr.Stored{Body: "c"})
+
+	if got, _ := s.Get("B"); got != nil {
+		t.Errorf("expected B evicted, got '%s'", got.String())
+	}
+	if entries, _ := s.GetHistory("B", 0); entries != nil {
+		t.Errorf("expected B history evicted, got %v", entries)
+	}
+	for name, want := range map[string]string{"A": "a", "C": "c", "__stdlib__": "stdlib"} {
+		got, _ := s.Get(name)
+		if got == nil || got.String() != want {
+			t.Errorf("expected %s='%s' to survive eviction, got %v", name, want, got)
+		}
+	}
+}
+
+func TestMemoryLimitPinsSystemNames(t *testing.T) {
+	s := NewMemory(WithMemoryLimit(1))
+
+	s.Put("__stdlib__", expr.Stored{Body: "stdlib"})
+	s.Put("__startup__", expr.Stored{Body: "startup"})
+	s.Put("A", expr.Stored{Body: "a"})
+	s.Put("B", expr.Stored{Body: "b"})
+
+	if got, _ := s.Get("A"); got != nil {
+		t.Errorf("expected A evicted, got '%s'", got.String())
+	}
+	if got, _ := s.Get("B"); got == nil || got.String() != "b" {
+		t.Errorf("expected B='b', got %v", got)
+	}
+	for _, name := range []string{"__stdlib__", "__startup__"} {
+		if got, _ := s.Get(name); got == nil {
+			t.Errorf("expected pinned %s to survive eviction", name)
+		}
+	}
+}
+
 func TestSQLiteVersioning(t *testing.T) {
 	f, err := os.CreateTemp("", "losp-ver-test-*.db")
 	if err != nil {
@@ -284,3 +426,179 @@ func TestSQLiteMigrationV2toV3(t *testing.T) {
 		t.Fatalf("expected 2 entries after update, got %d", len(entries))
 	}
 }
+
+func TestSQLiteBackup(t *testing.T) {
+	dir := t.TempDir()
+	sq, err := NewSQLite(dir + "/live.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sq.Close()
+
+	sq.Put("Greeting", expr.Stored{Body: "hello"})
+	sq.Put("Greeting", expr.Stored{Body: "hello again"})
+	sq.Put("Bin", expr.Blob{Data: []byte{0x00, 0xff}})
+	sq.SetMetadata("tag:Greeting:v1", "1")
+
+	backup := dir + "/backup.db"
+	if err := sq.Backup(backup); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := sq.Backup(backup); err == nil {
+		t.Error("expected backing up over an existing file to fail")
+	}
+
+	// The live store keeps working after the backup
+	sq.Put("Greeting", expr.Stored{Body: "after"})
+
+	restored, err := NewSQLite(backup)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer restored.Close()
+
+	if got, _ := restored.Get("Greeting"); got == nil || got.String() != "hello again" {
+		t.Errorf("expected 'hello again', got %v", got)
+	}
+	if entries, _ := restored.GetHistory("Greeting", 0); len(entries) != 2 {
+		t.Errorf("expected 2 versions in the backup, got %d", len(entries))
+	}
+	if got, _ := restored.Get("Bin"); got == nil || !bytes.Equal(got.(expr.Blob).Data, []byte{0x00, 0xff}) {
+		t.Errorf("expected the blob to survive, got %v", got)
+	}
+	if v, _ := restored.GetMetadata("tag:Greeting:v1"); v != "1" {
+		t.Errorf("expected metadata in the backup, got %q", v)
+	}
+}
+
+func TestSQLiteSearchFTSSeq(t *testing.T) {
+	sq, err := NewSQLite(t.TempDir() + "/fts.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sq.Close()
+
+	if err := sq.CreateFTSTable("Notes"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 40 {
+		sq.UpdateFTSContent("Notes", fmt.Sprintf("Note%02d", i), "a note about cats")
+	}
+
+	want, err := sq.SearchFTS("Notes", "cats", 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for name, err := range sq.SearchFTSSeq("Notes", "cats", 35) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+		// The store is usable between results
+		sq.Put("Seen", expr.Stored{Body: name})
+	}
+	if !slices.Equal(got, want) || len(got) != 35 {
+		t.Errorf("expected the %d SearchFTS results across pages, got %d: %v", len(want), len(got), got)
+	}
+
+	var first []string
+	for name := range sq.SearchFTSSeq("Notes", "cats", 35) {
+		first = append(first, name)
+		if len(first) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(first, want[:3]) {
+		t.Errorf("expected stopping early to give the first 3 results, got %v", first)
+	}
+
+	// Every note ranks the same, so ties are broken by name
+	if !slices.IsSorted(want) {
+		t.Errorf("expected equal-rank results in name order, got %v", want)
+	}
+
+	// Rewriting results mid-iteration doesn't shift later pages into
+	// repeats
+	var all []string
+	for name, err := range sq.SearchFTSSeq("Notes", "cats", 40) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, name)
+		sq.UpdateFTSContent("Notes", name, "a note about cats")
+	}
+	if len(all) != 40 || !slices.IsSorted(all) || len(slices.Compact(slices.Clone(all))) != 40 {
+		t.Errorf("expected all 40 notes once each, got %d: %v", len(all), all)
+	}
+}
+
+func TestFindValue(t *testing.T) {
+	sq, err := NewSQLite(t.TempDir() + "/find.db")
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	defer sq.Close()
+
+	for _, s := range []interface {
+		Store
+		ValueFinder
+	}{NewMemory(), sq} {
+		s.Put("A", expr.Stored{Body: "▼A shared ◆"})
+		s.Put("B", expr.Stored{Body: "▼B \n  shared\n◆"})
+		s.Put("Plain", expr.Stored{Body: "shared"})
+		s.Put("Params", expr.Stored{Body: "▼Params □x shared◆"})
+		s.Put("Other", expr.Stored{Body: "▼Other other ◆"})
+		s.Put("Moved", expr.Stored{Body: "▼Moved shared ◆"})
+		s.Put("Moved", expr.Stored{Body: "▼Moved elsewhere ◆"})
+		s.Put("Bin", expr.Blob{Data: []byte("shared")})
+
+		got, err := s.FindValue("shared")
+		if err != nil {
+			t.Fatalf("%T: FindValue failed: %v", s, err)
+		}
+		if want := []string{"A", "B", "Plain"}; !slices.Equal(got, want) {
+			t.Errorf("%T: expected %v, got %v", s, want, got)
+		}
+		if got, _ := s.FindValue("missing"); len(got) != 0 {
+			t.Errorf("%T: expected no names, got %v", s, got)
+		}
+	}
+}
+
+func TestMemoryBackup(t *testing.T) {
+	m := NewMemory()
+	m.Put("Greeting", expr.Stored{Body: "hello"})
+	m.Put("Bin", expr.Blob{Data: []byte{0x00, 0xff}})
+	m.SetMetadata("k", "v")
+
+	path := t.TempDir() + "/backup.json"
+	if err := m.Backup(path); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := m.Backup(path); err == nil {
+		t.Error("expected backing up over an existing file to fail")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	x, err := DecodeExport(f)
+	if err != nil {
+		t.Fatalf("DecodeExport failed: %v", err)
+	}
+	if x.SchemaVersion != SchemaVersion {
+		t.Errorf("expected schema version %s, got %s", SchemaVersion, x.SchemaVersion)
+	}
+	if got := x.Expressions["Greeting"]; got == nil || got.String() != "hello" {
+		t.Errorf("expected 'hello', got %v", got)
+	}
+	if b, ok := x.Expressions["Bin"].(expr.Blob); !ok || !bytes.Equal(b.Data, []byte{0x00, 0xff}) {
+		t.Errorf("expected the blob to survive, got %v", x.Expressions["Bin"])
+	}
+	if x.Metadata["k"] != "v" {
+		t.Errorf("expected metadata, got %v", x.Metadata)
+	}
+}
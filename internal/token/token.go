@@ -4,6 +4,11 @@
 // Package token defines losp token types and Unicode operator constants.
 package token
 
+import (
+	"strings"
+	"unicode/utf8"
+)
+
 // Token represents a losp token type.
 type Token int
 
@@ -36,6 +41,23 @@ const (
 	RuneTerminator  = '◆' // U+25C6
 )
 
+// ContainsOperator reports whether s contains any losp operator. Text
+// without one scans as a single TEXT token.
+func ContainsOperator(s string) bool {
+	for {
+		// Every operator is in U+25xx, whose UTF-8 encoding starts with 0xE2
+		i := strings.IndexByte(s, 0xE2)
+		if i < 0 {
+			return false
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if IsOperator(r) {
+			return true
+		}
+		s = s[i+size:]
+	}
+}
+
 // IsOperator returns true if the rune is a losp operator.
 func IsOperator(r rune) bool {
 	switch r {
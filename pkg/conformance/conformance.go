@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+// Package conformance runs the losp conformance suite (tests/conformance)
+// against a runtime, the way tests/conformance/run_tests.sh runs it against
+// the losp command.
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	expectedDirective = "# EXPECTED:"
+	inputDirective    = "# INPUT:"
+)
+
+// Case is a parsed conformance test file.
+type Case struct {
+	Expected string // The # EXPECTED: lines, joined with newlines
+	Input    string // The # INPUT: text with \n and \t expanded, for READ; empty if none
+	Code     string // The program, with directive lines and trailing newlines removed
+}
+
+// HasInput reports whether the case gives input for READ. Such cases are
+// run the way the losp command runs a file: load-only, then __startup__.
+func (c Case) HasInput() bool {
+	return c.Input != ""
+}
+
+// Parse reads the directives at the top of a conformance test file. They
+// end at the first line that is neither # EXPECTED: nor # INPUT:, and one
+// space after the colon is not part of the value.
+func Parse(content string) Case {
+	lines := strings.Split(content, "\n")
+	var c Case
+	var expected []string
+	codeStart := len(lines)
+	for i, line := range lines {
+		if v, ok := directive(line, expectedDirective); ok {
+			expected = append(expected, v)
+		} else if v, ok := directive(line, inputDirective); ok {
+			c.Input = v
+		} else {
+			codeStart = i
+			break
+		}
+	}
+	c.Expected = strings.Join(expected, "\n")
+	// Expand escapes as the bash runner's echo -e does
+	c.Input = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(c.Input)
+
+	// Directive lines further down are removed too
+	var code []string
+	for _, line := range lines[codeStart:] {
+		if strings.HasPrefix(line, expectedDirective) || strings.HasPrefix(line, inputDirective) {
+			continue
+		}
+		code = append(code, line)
+	}
+	c.Code = strings.TrimRight(strings.Join(code, "\n"), "\n")
+	return c
+}
+
+func directive(line, prefix string) (string, bool) {
+	v, ok := strings.CutPrefix(line, prefix)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(v, " "), true
+}
+
+// Runtime is what RunFile needs of a losp runtime. *losp.Runtime has it.
+type Runtime interface {
+	Eval(code string) (string, error)
+	LoadReader(r io.Reader) error
+	Close() error
+}
+
+// RuntimeFactory creates a runtime for one test, with a fresh SQLite store
+// at db. As in the losp command, READ should write its prompt to output
+// and read a line from input, and SAY and the other output builtins should
+// write to output.
+type RuntimeFactory func(db string, input io.Reader, output io.Writer) Runtime
+
+// RunFile runs the conformance test at path on a runtime from newRuntime
+// and compares what it printed with the file's expected output. Like the
+// losp command, it runs __startup__ after the program, prints the final
+// result and reports evaluation errors in the output; err is only for
+// failing to run the test at all.
+func RunFile(newRuntime RuntimeFactory, path string) (pass bool, expected, actual string, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, "", "", err
+	}
+	c := Parse(string(content))
+
+	dir, err := os.MkdirTemp("", "losp-conformance-*")
+	if err != nil {
+		return false, c.Expected, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	var out strings.Builder
+	// The runner pipes input with a trailing newline, ending the last line
+	input := strings.NewReader(c.Input + "\n")
+	rt := newRuntime(filepath.Join(dir, "test.db"), input, &out)
+	run(rt, c, &out)
+	if err := rt.Close(); err != nil {
+		return false, c.Expected, out.String(), err
+	}
+
+	// Trailing newlines are dropped, as $(...) does in the bash runner
+	actual = strings.TrimRight(out.String(), "\n")
+	return actual == c.Expected, c.Expected, actual, nil
+}
+
+// run executes the case as the losp command would: a case with input as a
+// file (-f), any other as a program piped to stdin. Either way the code
+// ends in one newline, as echo writes it.
+func run(rt Runtime, c Case, out io.Writer) {
+	code := c.Code + "\n"
+	var result string
+	var err error
+	if c.HasInput() {
+		if err := rt.LoadReader(strings.NewReader(code)); err != nil {
+			fmt.Fprintf(out, "Error loading file: %v\n", err)
+			return
+		}
+		result, err = rt.Eval("▶__startup__ ◆")
+	} else {
+		result, err = rt.Eval(code)
+		if err == nil {
+			var startup string
+			if startup, err = rt.Eval("▶__startup__ ◆"); err == nil && startup != "" {
+				result = startup
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Error: %v\n", err)
+		return
+	}
+	if result != "" {
+		fmt.Fprintln(out, result)
+	}
+}
+
+// Files returns the .losp files under dir in sorted order, the order the
+// bash runner uses.
+func Files(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".losp") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	slices.Sort(files)
+	return files, err
+}
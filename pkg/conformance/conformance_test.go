@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package conformance_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nickandperla.net/losp/pkg/conformance"
+	"nickandperla.net/losp/pkg/losp"
+)
+
+const suiteDir = "../../tests/conformance"
+
+// native runs tests in-process, set up as the losp command sets itself up.
+func native(db string, input io.Reader, output io.Writer) conformance.Runtime {
+	in := bufio.NewReader(input)
+	return losp.New(
+		losp.WithSQLiteStore(db),
+		losp.WithOutput(output),
+		losp.WithInputReader(func(prompt string) (string, error) {
+			if prompt != "" {
+				fmt.Fprint(output, prompt)
+			}
+			return in.ReadString('\n')
+		}),
+	)
+}
+
+func TestParse(t *testing.T) {
+	c := conformance.Parse("# EXPECTED: one\n# EXPECTED:two\n# INPUT: a\\nb\n▶SAY one ◆\n# EXPECTED: late\n▶SAY two ◆")
+	if c.Expected != "one\ntwo" {
+		t.Errorf("expected the leading EXPECTED lines, got %q", c.Expected)
+	}
+	if c.Input != "a\nb" || !c.HasInput() {
+		t.Errorf("expected input with escapes expanded, got %q", c.Input)
+	}
+	if c.Code != "▶SAY one ◆\n▶SAY two ◆" {
+		t.Errorf("expected directive lines removed from the code, got %q", c.Code)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	for _, name := range []string{
+		"01_store/deferred_store_basic.losp",
+		"18_errors/missing_defer_terminator.losp",
+		"22_read/read_fields.losp",
+		"24_loadonly/01_toplevel_execute_skipped.losp",
+	} {
+		t.Run(name, func(t *testing.T) {
+			pass, expected, actual, err := conformance.RunFile(native, filepath.Join(suiteDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pass {
+				t.Errorf("expected %q, got %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunFileMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wrong.losp")
+	os.WriteFile(path, []byte("# EXPECTED: hello\n▶SAY goodbye ◆\n"), 0o644)
+
+	pass, expected, actual, err := conformance.RunFile(native, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass || expected != "hello" || actual != "goodbye" {
+		t.Errorf("expected a failure showing both outputs, got pass=%v expected=%q actual=%q", pass, expected, actual)
+	}
+
+	if _, _, _, err := conformance.RunFile(native, filepath.Join(t.TempDir(), "missing.losp")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	files, err := conformance.Files(suiteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 || filepath.Base(filepath.Dir(files[0])) != "01_store" {
+		t.Errorf("expected the suite's files in order, got %d starting with %v", len(files), files[:min(1, len(files))])
+	}
+}
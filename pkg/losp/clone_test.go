@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package losp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCloneConcurrentEval(t *testing.T) {
+	base := New(
+		WithSQLiteStore(filepath.Join(t.TempDir(), "shared.db")),
+		WithNoStdlib(),
+	)
+	defer base.Close()
+	if _, err := base.Eval("▼Greet Hello, □who ▲who ◆"); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+	outputs := make([]strings.Builder, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := base.Clone()
+			defer r.Close()
+			r.SetOutputWriter(func(text string) error {
+				outputs[i].WriteString(text)
+				return nil
+			})
+			src := fmt.Sprintf("▽Name worker%d ◆ ▽Seen_%d ▲Name ◆ ▶PERSIST Seen_%d ◆ ▶SAY ▶Greet ▲Name ◆ ◆", i, i, i)
+			for range 20 {
+				if _, err := r.Eval(src); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		want := strings.Repeat(fmt.Sprintf("Hello, worker%d\n", i), 20)
+		if got := outputs[i].String(); got != want {
+			t.Errorf("worker %d: expected its own greetings, got %q", i, got)
+		}
+	}
+
+	// Namespaces are independent, but every clone wrote to the shared store
+	if got, _ := base.Eval("▲Name"); got != "" {
+		t.Errorf("expected clones not to touch the original namespace, got %q", got)
+	}
+	got, err := base.Eval("▶LOAD Seen_3 ◆ ▲Seen_3")
+	if err != nil || got != "worker3" {
+		t.Errorf("expected Seen_3 in the shared store, got %q, err=%v", got, err)
+	}
+}
+
+// Run with -race: goroutines share one Runtime, and async tasks change
+// settings while the main evaluator reads them.
+func TestRuntimeSharedConcurrentEval(t *testing.T) {
+	r := New(
+		WithSQLiteStore(filepath.Join(t.TempDir(), "shared.db")),
+		WithNoStdlib(),
+		WithPersistMode(PersistAlways),
+	)
+	defer r.Close()
+	if _, err := r.Eval("▼Tune ▶SYSTEM\nSEARCH_LIMIT\n7\n◆ ◆"); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 16
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 25 {
+				src := fmt.Sprintf("▽Slot_%d %d ◆ ▶ASYNC Tune ◆ ▶SYSTEM SEARCH_LIMIT ◆ ▲Slot_%d", i, j, i)
+				got, err := r.Eval(src)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !strings.HasSuffix(got, fmt.Sprintf(" %d", j)) {
+					t.Errorf("worker %d: expected its own slot back, got %q", i, got)
+					return
+				}
+				r.Snapshot()
+				if err := r.Flush(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		if got, _ := r.Eval(fmt.Sprintf("▲Slot_%d", i)); got != "24" {
+			t.Errorf("expected Slot_%d to hold the last write, got %q", i, got)
+		}
+	}
+
+	// Clones share the provider, so its inference params see concurrent
+	// SYSTEM and PROMPT_WITH calls too
+	base := New(
+		WithMemoryStore(),
+		WithNoStdlib(),
+		WithMockProviderFunc(func(system, user string) string { return user }),
+	)
+	defer base.Close()
+	var pwg sync.WaitGroup
+	for i := range 4 {
+		pwg.Add(1)
+		go func() {
+			defer pwg.Done()
+			c := base.Clone()
+			defer c.Close()
+			for j := range 25 {
+				src := fmt.Sprintf("▶SYSTEM\nTEMPERATURE\n0.%d\n◆ ▶PROMPT_WITH temperature=0.1 worker%d ◆", (i+j)%8+2, i)
+				got, err := c.Eval(src)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got != fmt.Sprintf("worker%d", i) {
+					t.Errorf("clone %d: expected its own prompt back, got %q", i, got)
+					return
+				}
+			}
+		}()
+	}
+	pwg.Wait()
+	// PROMPT_WITH passes its params with the request, never setting them
+	if got, _ := base.Eval("▶SYSTEM TEMPERATURE ◆"); got == "0.1" {
+		t.Errorf("expected PROMPT_WITH not to set the shared TEMPERATURE, got %q", got)
+	}
+}
@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package losp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// keywordEmbedder maps text onto fixed axes by keyword.
+type keywordEmbedder struct{}
+
+func (keywordEmbedder) Embed(texts []string) ([][]float32, error) {
+	var out [][]float32
+	for _, text := range texts {
+		vec := make([]float32, 3)
+		for i, kw := range []string{"cat", "stock", "rain"} {
+			if strings.Contains(text, kw) {
+				vec[i] = 1
+			}
+		}
+		out = append(out, vec)
+	}
+	return out, nil
+}
+
+func withKeywordEmbedder() Option {
+	return func(r *Runtime) {
+		r.embeddingProvider = keywordEmbedder{}
+	}
+}
+
+func TestCorpusExportImportRoundTrip(t *testing.T) {
+	src := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+	defer src.Close()
+
+	_, err := src.Eval(`▼Pets the cat sleeps ◆
+▼Markets stock prices fell ◆
+▼Weather rain all week ◆
+▽kb ▶CORPUS kb ◆ ◆
+▶ADD ▲kb
+Pets ◆
+▶ADD ▲kb
+Markets ◆
+▶ADD ▲kb
+Weather ◆
+▶INDEX ▲kb ◆
+▶EMBED ▲kb ◆`)
+	if err != nil {
+		t.Fatalf("building corpus: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.ExportCorpus("kb", &buf); err != nil {
+		t.Fatalf("ExportCorpus: %v", err)
+	}
+
+	dst := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+	defer dst.Close()
+
+	name, err := dst.ImportCorpus(&buf)
+	if err != nil {
+		t.Fatalf("ImportCorpus: %v", err)
+	}
+	if name != "kb" {
+		t.Errorf("expected corpus name 'kb', got '%s'", name)
+	}
+
+	result, err := dst.Eval("▽kb ▶CORPUS kb ◆ ◆\n▶SIMILAR ▲kb\na stock question ◆")
+	if err != nil {
+		t.Fatalf("SIMILAR: %v", err)
+	}
+	if first := strings.Split(result, "\n")[0]; first != "Markets" {
+		t.Errorf("expected Markets as nearest match, got '%s'", result)
+	}
+
+	result, _ = dst.Eval("▶SEARCH ▲kb\nrain ◆")
+	if result != "Weather" {
+		t.Errorf("expected SEARCH to find Weather, got '%s'", result)
+	}
+
+	result, _ = dst.Eval("▶Pets ◆")
+	if result != "the cat sleeps" {
+		t.Errorf("expected imported member definition, got '%s'", result)
+	}
+}
+
+func TestCorpusExportUnknown(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib())
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if err := r.ExportCorpus("missing", &buf); err == nil {
+		t.Error("expected error exporting an unknown corpus")
+	}
+	if _, err := r.ImportCorpus(strings.NewReader("not a bundle")); err == nil {
+		t.Error("expected error importing garbage")
+	}
+}
+
+func TestCorpusErrorsVsEmpty(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib())
+	defer r.Close()
+
+	if _, err := r.Eval("▼Doc losp is great ◆\n▽kb ▶CORPUS kb ◆ ◆\n▶ADD ▲kb\nDoc\n◆\n▶INDEX ▲kb ◆\n▽raw ▶CORPUS raw ◆ ◆"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// Searching an indexed corpus that has no match is a legitimate EMPTY
+		{"▶SEARCH ▲kb\nxyznonexistent\n◆", ""},
+		{"▶SEARCH ▲kb\nlosp\n◆", "Doc"},
+		{"▶SEARCH\n_corpus_99\nlosp\n◆", `ERROR NOT_FOUND: no corpus for handle "_corpus_99"`},
+		{"▶SEARCH ▲raw\nlosp\n◆", `ERROR NOT_INDEXED: corpus "raw" has not been INDEXed`},
+		{"▶SEARCH ▲kb ◆", "ERROR INVALID: SEARCH needs a corpus handle and a query"},
+		{"▶ADD\n_corpus_99\nDoc\n◆", `ERROR NOT_FOUND: no corpus for handle "_corpus_99"`},
+		{"▶CORPUS ◆", "ERROR INVALID: CORPUS needs a corpus name"},
+	}
+	for _, tt := range tests {
+		result, err := r.Eval(tt.input)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.input, err)
+		}
+		if result != tt.want {
+			t.Errorf("%q: expected '%s', got '%s'", tt.input, tt.want, result)
+		}
+	}
+}
+
+func TestCorpusAutoEmbed(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+	defer r.Close()
+
+	_, err := r.Eval(`▶SYSTEM
+AUTO_EMBED
+TRUE
+◆
+▼Pets the cat sleeps ◆
+▼Markets stock prices fell ◆
+▽kb ▶CORPUS kb ◆ ◆
+▶ADD ▲kb
+Pets ◆`)
+	if err != nil {
+		t.Fatalf("building corpus: %v", err)
+	}
+
+	// The first member builds the index without an EMBED call
+	result, err := r.Eval("▶SIMILAR ▲kb\na cat question ◆")
+	if err != nil {
+		t.Fatalf("SIMILAR: %v", err)
+	}
+	if result != "Pets" {
+		t.Errorf("expected Pets, got '%s'", result)
+	}
+
+	// Later members are added to the existing index
+	r.Eval("▶ADD ▲kb\nMarkets ◆")
+	result, _ = r.Eval("▶SIMILAR ▲kb\na stock question ◆")
+	if first := strings.Split(result, "\n")[0]; first != "Markets" {
+		t.Errorf("expected Markets as nearest match, got '%s'", result)
+	}
+
+	// Without AUTO_EMBED, ADD leaves the member unembedded
+	r.Eval("▶SYSTEM\nAUTO_EMBED\nFALSE\n◆\n▼Weather rain all week ◆\n▶ADD ▲kb\nWeather ◆")
+	result, _ = r.Eval("▶SIMILAR ▲kb\nrain today ◆")
+	if strings.Contains(result, "Weather") {
+		t.Errorf("expected Weather not to be embedded, got '%s'", result)
+	}
+}
+
+func TestCorpusAutoEmbedNeedsProvider(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib())
+	defer r.Close()
+
+	result, err := r.Eval("▶SYSTEM\nAUTO_EMBED\nTRUE\n◆\n▼Pets the cat sleeps ◆\n▽kb ▶CORPUS kb ◆ ◆\n▶ADD ▲kb\nPets ◆")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != "ERROR NO_PROVIDER: AUTO_EMBED needs an embedding provider" {
+		t.Errorf("expected a NO_PROVIDER error, got '%s'", result)
+	}
+	if result, _ := r.Eval("▶SYSTEM\nAUTO_EMBED\nmaybe\n◆"); result != "INVALID" {
+		t.Errorf("expected INVALID, got '%s'", result)
+	}
+}
+
+func TestCorpusEstimateEmbed(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+	defer r.Close()
+
+	// 14, 17 and 13 characters: 4, 5 and 4 tokens at four characters each
+	_, err := r.Eval(`▼Pets the cat sleeps ◆
+▼Markets stock prices fell ◆
+▼Weather rain all week ◆
+▽kb ▶CORPUS kb ◆ ◆
+▶ADD ▲kb
+Pets ◆
+▶ADD ▲kb
+Markets ◆`)
+	if err != nil {
+		t.Fatalf("building corpus: %v", err)
+	}
+
+	if result, _ := r.Eval("▶ESTIMATE_EMBED ▲kb ◆"); result != "9" {
+		t.Errorf("expected 9 tokens for two members, got '%s'", result)
+	}
+
+	// Embedded members no longer count
+	r.Eval("▶EMBED ▲kb ◆\n▶ADD ▲kb\nWeather ◆")
+	if result, _ := r.Eval("▶ESTIMATE_EMBED ▲kb ◆"); result != "4" {
+		t.Errorf("expected 4 tokens for the unembedded member, got '%s'", result)
+	}
+
+	if result, _ := r.Eval("▶ESTIMATE_EMBED _corpus_99 ◆"); result != `ERROR NOT_FOUND: no corpus for handle "_corpus_99"` {
+		t.Errorf("expected a NOT_FOUND error, got '%s'", result)
+	}
+}
+
+func TestCorpusSimilarStableOrder(t *testing.T) {
+	// Four members share a vector; each run builds a fresh graph
+	for run := range 10 {
+		r := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+		_, err := r.Eval(`▼Zeta a cat ◆
+▼Alpha the cat ◆
+▼Mid my cat ◆
+▼Markets stock prices fell ◆
+▼Beta one cat ◆
+▽kb ▶CORPUS kb ◆ ◆
+▶ADD ▲kb
+Zeta ◆
+▶ADD ▲kb
+Alpha ◆
+▶ADD ▲kb
+Mid ◆
+▶ADD ▲kb
+Markets ◆
+▶ADD ▲kb
+Beta ◆
+▶EMBED ▲kb ◆`)
+		if err != nil {
+			t.Fatalf("building corpus: %v", err)
+		}
+
+		result, err := r.Eval("▶SIMILAR ▲kb\ncat ◆")
+		r.Close()
+		if err != nil {
+			t.Fatalf("SIMILAR: %v", err)
+		}
+		if want := "Alpha\nBeta\nMid\nZeta\nMarkets"; result != want {
+			t.Fatalf("run %d: expected ties sorted by name, got %q", run, result)
+		}
+	}
+}
+
+func TestCorpusRefresh(t *testing.T) {
+	r := New(WithMemoryStore(), WithNoStdlib(), withKeywordEmbedder())
+	defer r.Close()
+
+	_, err := r.Eval(`▼Pets the cat sleeps ◆
+▼Markets stock prices fell ◆
+▽kb ▶CORPUS kb ◆ ◆
+▶ADD ▲kb
+Pets ◆
+▶ADD ▲kb
+Markets ◆
+▶INDEX ▲kb ◆
+▶EMBED ▲kb ◆`)
+	if err != nil {
+		t.Fatalf("building corpus: %v", err)
+	}
+
+	// Editing a member leaves the index behind until REFRESH
+	r.Eval("▼Pets rain on the porch ◆")
+	if result, _ := r.Eval("▶SEARCH ▲kb\nrain ◆"); result != "" {
+		t.Errorf("expected the stale index not to match, got '%s'", result)
+	}
+	if result, _ := r.Eval("▶REFRESH ▲kb ◆"); result != "Pets" {
+		t.Errorf("expected REFRESH to report Pets, got '%s'", result)
+	}
+	if result, _ := r.Eval("▶SEARCH ▲kb\nrain ◆"); result != "Pets" {
+		t.Errorf("expected SEARCH to find the new content, got '%s'", result)
+	}
+	if result, _ := r.Eval("▶SIMILAR ▲kb\nrain ◆"); result != "Pets\nMarkets" {
+		t.Errorf("expected SIMILAR to rank the new embedding first, got '%s'", result)
+	}
+
+	// Nothing left to refresh
+	if result, _ := r.Eval("▶REFRESH ▲kb ◆"); result != "" {
+		t.Errorf("expected nothing to refresh, got '%s'", result)
+	}
+
+	// A named member is refreshed whether or not it changed
+	if result, _ := r.Eval("▶REFRESH ▲kb\nMarkets ◆"); result != "Markets" {
+		t.Errorf("expected REFRESH to report Markets, got '%s'", result)
+	}
+	if result, _ := r.Eval("▶REFRESH ▲kb\nWeather ◆"); result != `ERROR NOT_FOUND: Weather is not a member of corpus "kb"` {
+		t.Errorf("expected a NOT_FOUND error, got '%s'", result)
+	}
+}
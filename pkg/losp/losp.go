@@ -6,14 +6,20 @@ package losp
 import (
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"nickandperla.net/losp/internal/eval"
 	"nickandperla.net/losp/internal/provider"
 )
 
-// Runtime is the losp interpreter runtime.
+// Runtime is the losp interpreter runtime. Its methods are safe for
+// concurrent use, but calls run one at a time; for evaluations that run in
+// parallel, give each goroutine a Clone. Hooks the runtime calls during
+// evaluation, such as the output writer or EvalEach's emit, must not call
+// back into the same Runtime.
 type Runtime struct {
+	mu                *sync.Mutex // Serializes calls into the evaluator
 	evaluator         *eval.Evaluator
 	store             eval.Store
 	provider          eval.Provider
@@ -21,16 +27,36 @@ type Runtime struct {
 	streamCb          func(token string)
 	inputReader       func(prompt string) (string, error)
 	outputWriter      func(text string) error
+	logWriter         io.Writer // Where LOG writes (nil = stderr)
 	timeout           time.Duration
 	prelude           string          // Custom prelude source (if empty, uses DefaultPrelude)
 	noStdlib          bool            // If true, skip loading prelude
 	persistMode       eval.PersistMode // Controls persistence behavior
+	sandbox           eval.SandboxProfile
+	shadowing         eval.ShadowMode
+	providerRequired  bool   // PROMPT/GENERATE return NO_PROVIDER without a provider
+	systemPreamble    string // Prepended to every PROMPT/GENERATE system prompt
+	retryOnEmpty      *int   // Provider retries on empty responses (nil = provider default)
+	promptLogger      func(system, user string)
+	clock             func() time.Time
+	maxOutput         *int // Result size cap in bytes (nil = eval default)
+	maxDepth          *int // Evaluation nesting cap (nil = eval default)
+	autoFlush         bool // Write top-level results as they complete
+	streamCapture     bool // Collect streamed tokens in _stream_buffer
+	ignoredRunes      []rune
+	homeDir           string
+	envAllowed        []string
+	providerLimit     int  // Concurrent provider calls allowed (0 = unlimited)
+	keepImmediate     bool // Bodies keep immediate operators after they fire
 	providerFactories map[string]eval.ProviderFactory
+	cassette          string // VCR cassette wrapping the provider, if set
+	cloned            bool // Created by Clone; the store belongs to the original
 }
 
 // New creates a new losp runtime with the given options.
 func New(opts ...Option) *Runtime {
 	r := &Runtime{
+		mu:                &sync.Mutex{},
 		timeout:           5 * time.Minute,
 		providerFactories: make(map[string]eval.ProviderFactory),
 	}
@@ -39,6 +65,10 @@ func New(opts ...Option) *Runtime {
 		opt(r)
 	}
 
+	if r.cassette != "" && r.provider != nil {
+		r.provider = provider.NewVCR(r.provider, r.cassette)
+	}
+
 	// Build evaluator options
 	evalOpts := []eval.Option{}
 	if r.store != nil {
@@ -60,6 +90,53 @@ func New(opts ...Option) *Runtime {
 		evalOpts = append(evalOpts, eval.WithOutputWriter(r.outputWriter))
 	}
 	evalOpts = append(evalOpts, eval.WithPersistMode(r.persistMode))
+	evalOpts = append(evalOpts, eval.WithSandbox(r.sandbox))
+	evalOpts = append(evalOpts, eval.WithBuiltinShadowing(r.shadowing))
+	if r.providerRequired {
+		evalOpts = append(evalOpts, eval.WithProviderRequired())
+	}
+	if r.systemPreamble != "" {
+		evalOpts = append(evalOpts, eval.WithSystemPreamble(r.systemPreamble))
+	}
+	if r.retryOnEmpty != nil {
+		evalOpts = append(evalOpts, eval.WithRetryOnEmpty(*r.retryOnEmpty))
+	}
+	if r.keepImmediate {
+		evalOpts = append(evalOpts, eval.WithEphemeralBodies(false))
+	}
+	if r.providerLimit > 0 {
+		evalOpts = append(evalOpts, eval.WithProviderConcurrency(r.providerLimit))
+	}
+	if r.logWriter != nil {
+		evalOpts = append(evalOpts, eval.WithLogWriter(r.logWriter))
+	}
+	if r.promptLogger != nil {
+		evalOpts = append(evalOpts, eval.WithPromptLogger(r.promptLogger))
+	}
+	if r.clock != nil {
+		evalOpts = append(evalOpts, eval.WithClock(r.clock))
+	}
+	if r.autoFlush {
+		evalOpts = append(evalOpts, eval.WithAutoFlush())
+	}
+	if r.streamCapture {
+		evalOpts = append(evalOpts, eval.WithStreamCapture())
+	}
+	if r.maxOutput != nil {
+		evalOpts = append(evalOpts, eval.WithMaxOutput(*r.maxOutput))
+	}
+	if r.maxDepth != nil {
+		evalOpts = append(evalOpts, eval.WithMaxDepth(*r.maxDepth))
+	}
+	if r.homeDir != "" {
+		evalOpts = append(evalOpts, eval.WithHomeDir(r.homeDir))
+	}
+	if len(r.envAllowed) > 0 {
+		evalOpts = append(evalOpts, eval.WithEnvAllowList(r.envAllowed...))
+	}
+	if len(r.ignoredRunes) > 0 {
+		evalOpts = append(evalOpts, eval.WithIgnoredRunes(r.ignoredRunes...))
+	}
 
 	r.evaluator = eval.New(evalOpts...)
 
@@ -91,16 +168,45 @@ func New(opts ...Option) *Runtime {
 	return r
 }
 
+// EvalError is the error type returned by Eval, EvalReader and LoadReader.
+// Use errors.As to get its Line, Column and Kind.
+type EvalError = eval.EvalError
+
+// ErrorKind classifies an EvalError.
+type ErrorKind = eval.ErrorKind
+
+// Error kind constants.
+const (
+	KindRuntime      = eval.KindRuntime
+	KindUnterminated = eval.KindUnterminated
+	KindInvalidName  = eval.KindInvalidName
+	KindOutputLimit  = eval.KindOutputLimit
+	KindDepthLimit   = eval.KindDepthLimit
+)
+
 // Eval evaluates a losp string and returns the result.
 func (r *Runtime) Eval(input string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.evaluator.Eval(input)
 }
 
 // EvalReader evaluates losp from a reader.
 func (r *Runtime) EvalReader(reader io.Reader) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.evaluator.EvalReader(reader)
 }
 
+// EvalEach evaluates losp from a reader incrementally, calling emit with
+// each top-level result as soon as it is evaluated. Unlike EvalReader it
+// doesn't hold results in memory, so it suits large or unbounded input.
+func (r *Runtime) EvalEach(reader io.Reader, emit func(result string) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evaluator.EvalEach(reader, emit)
+}
+
 // EvalFile evaluates a losp file.
 func (r *Runtime) EvalFile(path string) (string, error) {
 	f, err := os.Open(path)
@@ -113,6 +219,8 @@ func (r *Runtime) EvalFile(path string) (string, error) {
 
 // LoadReader loads definitions from a reader in load-only mode.
 func (r *Runtime) LoadReader(reader io.Reader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.evaluator.LoadReader(reader)
 }
 
@@ -126,10 +234,103 @@ func (r *Runtime) LoadFile(path string) error {
 	return r.LoadReader(f)
 }
 
+// Node is one element of a parsed losp program; see Parse.
+type Node = eval.Node
+
+// Parse returns the operator tree of src without evaluating it, for tools
+// such as formatters and linters. Nothing in src runs, and the runtime's
+// namespace and store are untouched.
+func (r *Runtime) Parse(src string) ([]Node, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evaluator.Parse(src)
+}
+
+// ExportCorpus writes the named corpus, including its member definitions,
+// embeddings and vector index, to w as a portable bundle.
+func (r *Runtime) ExportCorpus(name string, w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evaluator.ExportCorpus(name, w)
+}
+
+// ImportCorpus loads a bundle written by ExportCorpus and returns the name of
+// the imported corpus.
+func (r *Runtime) ImportCorpus(reader io.Reader) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evaluator.ImportCorpus(reader)
+}
+
+// Flush writes any buffered auto-persist changes to the store.
+func (r *Runtime) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evaluator.Flush()
+}
+
+// Usage is a running total of LLM prompts with estimated token counts.
+type Usage = eval.Usage
+
+// Usage returns the prompts made so far, including by async tasks, since
+// the Runtime was created or a program last ran SYSTEM RESET. Token counts
+// are estimates at about four characters per token, since providers don't
+// report them.
+func (r *Runtime) Usage() Usage {
+	return r.evaluator.Usage()
+}
+
+// Snapshot is a saved copy of a Runtime's namespace; see Snapshot.
+type Snapshot struct {
+	ns *eval.Namespace
+}
+
+// Snapshot saves the current namespace for a later Restore. Only the
+// namespace is saved: the store, settings, corpora and macros are not.
+func (r *Runtime) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Snapshot{ns: r.evaluator.Namespace().Clone()}
+}
+
+// Restore returns the namespace to a Snapshot, undefining names defined
+// since it was taken. Anything already persisted stays in the store.
+func (r *Runtime) Restore(s Snapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evaluator.Namespace().Restore(s.ns)
+}
+
+// Clone returns a Runtime for use on another goroutine, for example one per
+// request in a server. Clones evaluate in parallel with each other and the
+// original: each has its own copy of the namespace and settings,
+// while sharing the store, providers and I/O hooks. Settings that
+// reconfigure the shared provider, such as MODEL, affect every clone. In
+// PERSIST_MODE ALWAYS every retrieve reads through to the shared store, so
+// clones see each other's writes to the same name. Closing a clone flushes its writes but leaves the shared store open.
+func (r *Runtime) Clone() *Runtime {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *r
+	c.mu = &sync.Mutex{}
+	c.evaluator = r.evaluator.Clone()
+	c.cloned = true
+	return &c
+}
+
 // Close releases resources.
 func (r *Runtime) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.evaluator.AsyncRegistry().Shutdown()
+	if r.cloned {
+		return r.evaluator.Flush()
+	}
 	if r.store != nil {
+		if err := r.evaluator.Flush(); err != nil {
+			r.store.Close()
+			return err
+		}
 		return r.store.Close()
 	}
 	return nil
@@ -137,6 +338,16 @@ func (r *Runtime) Close() error {
 
 // SetInputReader changes the input reader for READ builtin.
 func (r *Runtime) SetInputReader(reader func(prompt string) (string, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.inputReader = reader
 	r.evaluator.SetInputReader(reader)
 }
+
+// SetOutputWriter changes where SAY writes.
+func (r *Runtime) SetOutputWriter(writer func(text string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outputWriter = writer
+	r.evaluator.SetOutputWriter(writer)
+}
@@ -33,6 +33,27 @@ func WithMemoryStore() Option {
 	}
 }
 
+// WithMemoryStoreLimit configures an in-memory store that evicts the least
+// recently accessed expressions once more than maxEntries are stored.
+// System names (prefixed with "__") are never evicted.
+func WithMemoryStoreLimit(maxEntries int) Option {
+	return func(r *Runtime) {
+		r.store = store.NewMemory(store.WithMemoryLimit(maxEntries))
+	}
+}
+
+// WithNullStore configures a store that keeps nothing, so the namespace is
+// the runtime's only state. PERSIST does nothing, LOAD finds nothing and
+// falls back to its default, HISTORY is always empty and corpora live only
+// in memory. It is lighter than WithMemoryStore,
+// for hosts such as stateless servers that handle each request in a fresh
+// runtime.
+func WithNullStore() Option {
+	return func(r *Runtime) {
+		r.store = store.NewNull()
+	}
+}
+
 // WithMockProvider configures a mock LLM provider (for testing).
 func WithMockProvider(response string) Option {
 	return func(r *Runtime) {
@@ -55,6 +76,16 @@ func WithStreamCallback(cb func(token string)) Option {
 	}
 }
 
+// WithStreamCapture collects the response of the prompt in flight in the
+// _stream_buffer variable and for STREAM_SO_FAR, so a program can watch an
+// async prompt as it streams. The provider streams even without
+// WithStreamCallback.
+func WithStreamCapture() Option {
+	return func(r *Runtime) {
+		r.streamCapture = true
+	}
+}
+
 // WithInputReader sets the input reader for READ builtin.
 func WithInputReader(reader func(prompt string) (string, error)) Option {
 	return func(r *Runtime) {
@@ -93,6 +124,136 @@ func WithNoPrompt() Option {
 	}
 }
 
+// WithVCR records the provider's responses in the cassette file at path
+// and replays them on later runs, so programs that prompt an LLM can be
+// tested offline and deterministically. Prompts not in the cassette go to
+// the configured provider and are recorded. Responses are keyed by the
+// system and user prompts, the model and the inference params, so changing
+// any of them records a new response. Providers switched to at runtime
+// with PROVIDER are not recorded.
+func WithVCR(path string) Option {
+	return func(r *Runtime) {
+		r.cassette = path
+	}
+}
+
+// WithProviderRequired makes PROMPT and GENERATE return NO_PROVIDER instead
+// of EMPTY when no provider is configured, so programs can detect it.
+func WithProviderRequired() Option {
+	return func(r *Runtime) {
+		r.providerRequired = true
+	}
+}
+
+// WithSystemPreamble prepends text to the system prompt of every PROMPT,
+// PROMPT_SCHEMA and GENERATE call, for a consistent persona across a program.
+func WithSystemPreamble(text string) Option {
+	return func(r *Runtime) {
+		r.systemPreamble = text
+	}
+}
+
+// WithRetryOnEmpty sets how many times the provider retries a prompt whose
+// response came back empty. 0 disables retrying.
+func WithRetryOnEmpty(n int) Option {
+	return func(r *Runtime) {
+		r.retryOnEmpty = &n
+	}
+}
+
+// WithEphemeralBodies controls whether immediate operators in a stored body
+// are consumed when they fire (the default). Pass false to keep them, so a ▽
+// inside a body fires on every execution, as imperative code would expect.
+func WithEphemeralBodies(on bool) Option {
+	return func(r *Runtime) {
+		r.keepImmediate = !on
+	}
+}
+
+// WithProviderConcurrency limits how many prompt and embedding calls may run
+// at once, across async tasks as well. Use it to stay within provider rate
+// limits. 0 means unlimited.
+func WithProviderConcurrency(n int) Option {
+	return func(r *Runtime) {
+		r.providerLimit = n
+	}
+}
+
+// WithLogWriter sets where the LOG builtin writes. The default is stderr.
+func WithLogWriter(w io.Writer) Option {
+	return func(r *Runtime) {
+		r.logWriter = w
+	}
+}
+
+// WithPromptLogger sets a hook that receives the full system and user
+// prompts of calls skipped by SYSTEM DRY_RUN.
+func WithPromptLogger(fn func(system, user string)) Option {
+	return func(r *Runtime) {
+		r.promptLogger = fn
+	}
+}
+
+// WithClock sets the time source BENCH and THROTTLE use. Tests can pass a
+// fake clock to get deterministic timings; by default the real clock is used.
+func WithClock(now func() time.Time) Option {
+	return func(r *Runtime) {
+		r.clock = now
+	}
+}
+
+// WithMaxOutput caps how large a result may grow, in bytes, before Eval fails
+// with a KindOutputLimit error. Hosts running untrusted code should set it
+// well below the default; 0 removes the cap.
+func WithMaxOutput(n int) Option {
+	return func(r *Runtime) {
+		r.maxOutput = &n
+	}
+}
+
+// WithMaxDepth caps how deeply evaluation may nest, as in runaway recursion,
+// before Eval fails with a KindDepthLimit error. 0 removes the cap.
+func WithMaxDepth(n int) Option {
+	return func(r *Runtime) {
+		r.maxDepth = &n
+	}
+}
+
+// WithAutoFlush makes Eval write each top-level result to the output, as
+// soon as that statement completes, instead of collecting the results and
+// returning them at the end. Eval then returns an empty result.
+func WithAutoFlush() Option {
+	return func(r *Runtime) {
+		r.autoFlush = true
+	}
+}
+
+// WithHomeDir sets the directory EXPAND_PATH expands ~ to, instead of the
+// user's home directory.
+func WithHomeDir(dir string) Option {
+	return func(r *Runtime) {
+		r.homeDir = dir
+	}
+}
+
+// WithEnvAllowList lets EXPAND_PATH expand the named environment variables.
+// None are allowed by default, so programs can't read secrets such as API
+// keys from the environment.
+func WithEnvAllowList(names ...string) Option {
+	return func(r *Runtime) {
+		r.envAllowed = append(r.envAllowed, names...)
+	}
+}
+
+// WithIgnoredRunes makes the scanner skip the given invisible runes, such as
+// soft hyphens, so they can't end up in names or text. Zero-width spaces and
+// byte order marks are always skipped.
+func WithIgnoredRunes(runes ...rune) Option {
+	return func(r *Runtime) {
+		r.ignoredRunes = append(r.ignoredRunes, runes...)
+	}
+}
+
 // WithPrelude sets a custom prelude source to be loaded on startup.
 // If not set, DefaultPrelude is used.
 func WithPrelude(source string) Option {
@@ -136,6 +297,50 @@ func WithPersistMode(mode PersistMode) Option {
 	}
 }
 
+// SandboxProfile restricts which builtins a Runtime may call.
+type SandboxProfile = eval.SandboxProfile
+
+// Sandbox profile constants.
+const (
+	SandboxNone = eval.SandboxNone
+	SandboxSafe = eval.SandboxSafe
+)
+
+// WithSandbox restricts the runtime to the builtins allowed by profile.
+// Forbidden builtins return FORBIDDEN. Use SandboxSafe when evaluating
+// untrusted code such as GENERATE output; it also lowers the output and
+// depth caps, unless WithMaxOutput or WithMaxDepth sets them.
+func WithSandbox(profile SandboxProfile) Option {
+	return func(r *Runtime) {
+		r.sandbox = profile
+	}
+}
+
+// ShadowMode decides what happens when a user expression is given the name
+// of a builtin.
+type ShadowMode = eval.ShadowMode
+
+// Shadow mode constants.
+const (
+	ShadowBuiltinWins = eval.ShadowBuiltinWins
+	ShadowUserWins    = eval.ShadowUserWins
+	ShadowError       = eval.ShadowError
+)
+
+// ParseShadowMode parses a string into a ShadowMode.
+func ParseShadowMode(s string) (ShadowMode, bool) {
+	return eval.ParseShadowMode(s)
+}
+
+// WithBuiltinShadowing sets what happens when a user expression is given
+// the name of a builtin: a definition that ▶ never reaches (the default),
+// one that replaces the builtin, or an error.
+func WithBuiltinShadowing(mode ShadowMode) Option {
+	return func(r *Runtime) {
+		r.shadowing = mode
+	}
+}
+
 // ProviderFactory creates a new provider with the given stream callback.
 type ProviderFactory = eval.ProviderFactory
 
@@ -245,3 +450,50 @@ func WithOllamaEmbedding(url, model string) Option {
 		r.embeddingProvider = o
 	}
 }
+
+// WithFallbackProviders configures a provider that tries each of providers
+// in order until one succeeds. Backends that can embed also serve CORPUS
+// embeddings, unless a dedicated embedding provider is configured.
+func WithFallbackProviders(providers ...Provider) Option {
+	return func(r *Runtime) {
+		r.setComposite(provider.NewFallback(backends(providers)...), providers)
+	}
+}
+
+// WithRoundRobinProviders configures a provider that spreads calls across
+// providers in turn. A failed call is not retried on another backend.
+func WithRoundRobinProviders(providers ...Provider) Option {
+	return func(r *Runtime) {
+		r.setComposite(provider.NewRoundRobin(backends(providers)...), providers)
+	}
+}
+
+// compositeProvider is a composite provider that also embeds.
+type compositeProvider interface {
+	Provider
+	provider.EmbeddingProvider
+}
+
+// setComposite installs p as the LLM provider, and as the embedding
+// provider if none is set and any backend supports embeddings.
+func (r *Runtime) setComposite(p compositeProvider, providers []Provider) {
+	r.provider = p
+	if r.embeddingProvider != nil {
+		return
+	}
+	for _, b := range providers {
+		if _, ok := b.(provider.EmbeddingProvider); ok {
+			r.embeddingProvider = p
+			return
+		}
+	}
+}
+
+// backends converts providers to the provider package's interface.
+func backends(providers []Provider) []provider.Provider {
+	out := make([]provider.Provider, len(providers))
+	for i, p := range providers {
+		out[i] = p
+	}
+	return out
+}
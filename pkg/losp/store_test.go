@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// Copyright (c) 2023-2026 Nicholas R. Perez
+
+package losp
+
+import "testing"
+
+func TestNullStore(t *testing.T) {
+	for _, mode := range []PersistMode{PersistOnDemand, PersistAlways} {
+		t.Run(mode.String(), func(t *testing.T) {
+			r := New(WithNullStore(), WithNoStdlib(), WithPersistMode(mode))
+			defer r.Close()
+
+			// Namespace operations work as usual
+			result, err := r.Eval("▼Greet □who Hello, ▲who! ◆\n▽Count 1 ◆\n▽Count 2 ◆\n▶Greet World ◆")
+			if err != nil || result != "Hello, World!" {
+				t.Fatalf("expected the namespace to work, got %q, err=%v", result, err)
+			}
+			if result, _ := r.Eval("▲Count"); result != "2" {
+				t.Errorf("expected Count to hold its latest value, got %q", result)
+			}
+
+			// Persistence is inert
+			if result, err := r.Eval("▶PERSIST Count ◆"); err != nil || result != "" {
+				t.Errorf("expected PERSIST to do nothing, got %q, err=%v", result, err)
+			}
+			if result, _ := r.Eval("▶HISTORY Count ◆"); result != "" {
+				t.Errorf("expected no history, got %q", result)
+			}
+			r.Eval("▶LOAD\nSaved\nfallback\n◆")
+			if result, _ := r.Eval("▲Saved"); result != "fallback" {
+				t.Errorf("expected LOAD to find nothing and use its default, got %q", result)
+			}
+			if err := r.Flush(); err != nil {
+				t.Errorf("unexpected flush error: %v", err)
+			}
+
+			// A second runtime shares nothing
+			r2 := New(WithNullStore(), WithNoStdlib(), WithPersistMode(mode))
+			defer r2.Close()
+			r2.Eval("▶LOAD Count ◆")
+			if result, _ := r2.Eval("▲Count"); result != "" {
+				t.Errorf("expected nothing kept between runtimes, got %q", result)
+			}
+		})
+	}
+}
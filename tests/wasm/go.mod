@@ -2,7 +2,10 @@ module nickandperla.net/losp/tests/wasm
 
 go 1.24.2
 
-require nickandperla.net/gigwasm v0.0.0
+require (
+	nickandperla.net/gigwasm v0.0.0
+	nickandperla.net/losp v0.0.0
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -20,3 +23,5 @@ require (
 )
 
 replace nickandperla.net/gigwasm => /home/nicholas/code/wasm/gigwasm
+
+replace nickandperla.net/losp => ../..
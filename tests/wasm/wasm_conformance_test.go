@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	"nickandperla.net/gigwasm"
+	"nickandperla.net/losp/pkg/conformance"
 )
 
 var compiledModule *gigwasm.CompiledModule
@@ -47,50 +48,6 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-// parseDirectives extracts EXPECTED lines, optional INPUT, and the losp code
-// from a test file. Mirrors the bash test runner's directive parsing.
-func parseDirectives(content string) (expected, input, code string) {
-	lines := strings.Split(content, "\n")
-	var expectedLines []string
-	codeStart := 0
-
-	for i, line := range lines {
-		if strings.HasPrefix(line, "# EXPECTED: ") {
-			expectedLines = append(expectedLines, line[len("# EXPECTED: "):])
-		} else if strings.HasPrefix(line, "# EXPECTED:") {
-			expectedLines = append(expectedLines, line[len("# EXPECTED:"):])
-		} else if strings.HasPrefix(line, "# INPUT: ") {
-			input = line[len("# INPUT: "):]
-		} else if strings.HasPrefix(line, "# INPUT:") {
-			input = line[len("# INPUT:"):]
-		} else {
-			codeStart = i
-			break
-		}
-	}
-
-	expected = strings.Join(expectedLines, "\n")
-
-	// Expand \n escapes in input (matching bash echo -e behavior)
-	if input != "" {
-		input = strings.ReplaceAll(input, `\n`, "\n")
-		input = strings.ReplaceAll(input, `\t`, "\t")
-	}
-
-	// Code is everything after directive lines, with directive lines removed
-	// (matching the bash: sed '/^# EXPECTED:/d; /^# INPUT:/d')
-	var codeLines []string
-	for _, line := range lines[codeStart:] {
-		if strings.HasPrefix(line, "# EXPECTED:") || strings.HasPrefix(line, "# INPUT:") {
-			continue
-		}
-		codeLines = append(codeLines, line)
-	}
-	code = strings.Join(codeLines, "\n")
-
-	return expected, input, code
-}
-
 func TestWASMConformance(t *testing.T) {
 	if compiledModule == nil {
 		t.Fatal("WASM module not compiled")
@@ -102,16 +59,7 @@ func TestWASMConformance(t *testing.T) {
 		t.Fatalf("Failed to resolve conformance dir: %v", err)
 	}
 
-	var testFiles []string
-	err = filepath.WalkDir(absDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() && strings.HasSuffix(path, ".losp") {
-			testFiles = append(testFiles, path)
-		}
-		return nil
-	})
+	testFiles, err := conformance.Files(absDir)
 	if err != nil {
 		t.Fatalf("Failed to walk conformance dir: %v", err)
 	}
@@ -133,7 +81,7 @@ func TestWASMConformance(t *testing.T) {
 				t.Fatalf("Failed to read test file: %v", err)
 			}
 
-			expected, input, code := parseDirectives(string(content))
+			c := conformance.Parse(string(content))
 
 			// Create temp database for isolation
 			tmpDB, err := os.CreateTemp("", "losp-wasm-test-*.db")
@@ -148,20 +96,20 @@ func TestWASMConformance(t *testing.T) {
 			var args []string
 			var stdinContent string
 
-			if input != "" {
+			if c.HasInput() {
 				// INPUT mode: pass code via -e, pipe input to stdin
 				// For tests that define __startup__, append execution
-				eCode := code
-				if strings.Contains(code, "__startup__") {
-					eCode = code + "\n▶__startup__ ◆"
+				eCode := c.Code
+				if strings.Contains(c.Code, "__startup__") {
+					eCode = c.Code + "\n▶__startup__ ◆"
 				}
 				args = []string{"losp", "-db", tmpDBPath, "-e", eCode}
 				// Add trailing newline to match bash echo behavior
-				stdinContent = input + "\n"
+				stdinContent = c.Input + "\n"
 			} else {
 				// No INPUT: pipe code to stdin
 				args = []string{"losp", "-db", tmpDBPath}
-				stdinContent = code
+				stdinContent = c.Code + "\n"
 			}
 
 			actual, exitCode := runWASMInstance(t, args, stdinContent)
@@ -169,8 +117,8 @@ func TestWASMConformance(t *testing.T) {
 			// Trim trailing newlines to match bash $() capture behavior
 			actual = strings.TrimRight(actual, "\n")
 
-			if actual != expected {
-				t.Errorf("Output mismatch (exit=%d)\n  Expected: %q\n  Actual:   %q", exitCode, expected, actual)
+			if actual != c.Expected {
+				t.Errorf("Output mismatch (exit=%d)\n  Expected: %q\n  Actual:   %q", exitCode, c.Expected, actual)
 			}
 		})
 	}